@@ -0,0 +1,79 @@
+package app
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/controllers"
+	"github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/middleware"
+	"github.com/hauchongtang/splatbackend/routes"
+)
+
+// App is the whole API, built from a configuration and the dependencies it is given.
+// It is an http.Handler, so it can also be served by an httptest server.
+type App struct {
+	config Config
+	router *gin.Engine
+}
+
+func New(config Config, deps Dependencies) *App {
+	handlers := controllers.NewHandlers(controllers.Dependencies{
+		Users:         deps.Users,
+		Tasks:         deps.Tasks,
+		Sessions:      deps.Sessions,
+		ApiKeys:       deps.ApiKeys,
+		OneTimeTokens: deps.OneTimeTokens,
+		Cache:         deps.Cache,
+		CachePrefix:   deps.CachePrefix,
+		CacheMetrics:  deps.CacheMetrics,
+		Invalidations: deps.Invalidations,
+		Generations:   deps.Generations,
+		Once:          deps.Once,
+		Tokens:        deps.Tokens,
+		LoginThrottle: functions.NewLoginThrottle(deps.LoginAttempts, deps.Clock),
+		Mailer:        deps.Mailer,
+		Providers:     deps.Providers,
+		Unverified:    config.Unverified,
+		Links:         config.Links,
+		Clock:         deps.Clock,
+	})
+	auth := middleware.NewAuth(deps.Tokens, deps.Users, deps.ApiKeys, deps.Tasks, deps.Clock)
+
+	router := gin.Default()
+	// The client IP is throttled on failed logins, so it is only read from the headers of known proxies
+	err := router.SetTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		log.Default().Println(err, "Unable to use the trusted proxies, trusting none")
+		router.SetTrustedProxies(nil)
+	}
+	router.Use(middleware.CORSMiddleware())
+	router.Use(gin.Logger())
+	router.Use(middleware.CacheControl(config.CacheControl))
+	routes.AuthRoutes(router, handlers, auth)
+	routes.UserRoutes(router, handlers, auth)
+	routes.TaskRoutes(router, handlers, auth)
+	routes.StatsRoutes(router, handlers, auth)
+	routes.KeyRoutes(router, handlers)
+	routes.CacheRoutes(router, handlers, auth)
+	routes.DocsRoutes(router)
+
+	router.GET("/splat/api", auth.Authentication(), func(c *gin.Context) {
+		c.JSON(
+			200,
+			gin.H{"success": "Access granted"},
+		)
+	})
+
+	return &App{config: config, router: router}
+}
+
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.router.ServeHTTP(w, r)
+}
+
+// Run serves the API on the configured port until it fails
+func (a *App) Run() error {
+	return a.router.Run(":" + a.config.Port)
+}
@@ -0,0 +1,193 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/controllers"
+	"github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/mailer"
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword is the password of every test user
+const testPassword = "secret1"
+
+// testPasswordHash is hashed at the lowest cost, so that logins in tests are fast
+var testPasswordHash = func() string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hash)
+}()
+
+// testMailer keeps the emails sent, so that tests can follow the links in them
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, message mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *testMailer) last() mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages) == 0 {
+		return mailer.Message{}
+	}
+	return m.messages[len(m.messages)-1]
+}
+
+// testAPI is the whole API, booted in process against in-memory stores and a fake clock
+type testAPI struct {
+	t     *testing.T
+	app   *App
+	deps  Dependencies
+	clock *clock.Fake
+	mails *testMailer
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	return newTestAPIWith(t, Config{})
+}
+
+// testLinks are the pages that the links in the emails of tests open
+var testLinks = controllers.EmailLinks{Verification: "https://splat.example/verify", PasswordReset: "https://splat.example/reset"}
+
+func newTestAPIWith(t *testing.T, config Config) *testAPI {
+	t.Helper()
+
+	if config.Links == (controllers.EmailLinks{}) {
+		config.Links = testLinks
+	}
+
+	keys, err := functions.NewKeyring("", nil, "test secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := clock.NewFake(time.Now().Truncate(time.Second))
+	mails := &testMailer{}
+	deps := InMemory(keys, mails, fake)
+
+	return &testAPI{t: t, app: New(config, deps), deps: deps, clock: fake, mails: mails}
+}
+
+// request sends a request to the API. headers are pairs of names and values, and may include the Host.
+func (a *testAPI) request(method string, path string, token string, body string, headers ...string) *httptest.ResponseRecorder {
+	a.t.Helper()
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		if headers[i] == "Host" {
+			request.Host = headers[i+1]
+		}
+		request.Header.Set(headers[i], headers[i+1])
+	}
+
+	response := httptest.NewRecorder()
+	a.app.ServeHTTP(response, request)
+	return response
+}
+
+// decode reads the JSON body of a response
+func decode(t *testing.T, response *httptest.ResponseRecorder, value interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(response.Body.Bytes(), value)
+	if err != nil {
+		t.Fatalf("%v in %s", err, response.Body.String())
+	}
+}
+
+// addUser stores a user with a verified email, the test password and role, and logs them in
+func (a *testAPI) addUser(email string, role string) (models.User, string) {
+	a.t.Helper()
+	return a.addUserWith(email, role, nil)
+}
+
+// addUserWith is addUser, with change setting more fields of the user before it is stored
+func (a *testAPI) addUserWith(email string, role string, change func(user *models.User)) (models.User, string) {
+	a.t.Helper()
+
+	ctx := context.Background()
+	now := a.clock.Now()
+	id := primitive.NewObjectID()
+	firstName, lastName, password, verified := "Test", "User", testPasswordHash, true
+
+	user := models.User{
+		ID:             id,
+		User_id:        id.Hex(),
+		First_name:     &firstName,
+		Last_name:      &lastName,
+		Email:          &email,
+		Email_verified: &verified,
+		Password:       &password,
+		User_type:      role,
+		Created_at:     now,
+		Updated_at:     now,
+	}
+	if change != nil {
+		change(&user)
+	}
+
+	err := a.deps.Users.InsertUser(ctx, user)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	token, _, err := a.deps.Tokens.StartSession(ctx, user, "test", "192.0.2.1", "test")
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return user, token
+}
+
+// addTask stores a task of a user
+func (a *testAPI) addTask(userId string) models.Task {
+	a.t.Helper()
+	return a.addModuleTask(userId, "CS1010")
+}
+
+// addModuleTask stores a task of a user on a module
+func (a *testAPI) addModuleTask(userId string, module string) models.Task {
+	a.t.Helper()
+
+	name, duration := "Revision", "30"
+	task := models.Task{
+		ID:          primitive.NewObjectID(),
+		Task_name:   &name,
+		Module_code: &module,
+		Duration:    &duration,
+		User_id:     userId,
+		Created_at:  a.clock.Now(),
+		Updated_at:  a.clock.Now(),
+	}
+
+	err := a.deps.Tasks.InsertTask(context.Background(), task)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return task
+}
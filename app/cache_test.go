@@ -0,0 +1,112 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+func TestCachedUsersAreKeyedInTheirFamily(t *testing.T) {
+	api := newTestAPI(t)
+	user, token := api.addUser("user@example.com", "")
+	_, adminToken := api.addUser("admin@example.com", models.RoleAdmin)
+	key := "/admin/cache/keys/user:" + user.User_id
+
+	cached := func(path string) bool {
+		t.Helper()
+
+		response := api.request("GET", path, adminToken, "")
+		if response.Code != http.StatusOK && response.Code != http.StatusNotFound {
+			t.Fatalf("inspecting %s got %d: %s", path, response.Code, response.Body.String())
+		}
+		return response.Code == http.StatusOK
+	}
+
+	if response := api.request("GET", "/cached/users/"+user.User_id, token, ""); response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body.String())
+	}
+	var info models.CacheKeyInfo
+	decode(t, api.request("GET", key, adminToken, ""), &info)
+	if info.Family != "user" {
+		t.Errorf("the key of the user is in family %q, want user", info.Family)
+	}
+	if cached("/admin/cache/keys/" + user.User_id) {
+		t.Error("the user is cached under their bare id")
+	}
+
+	// Changing the user evicts them
+	if response := api.request("PUT", "/users/"+user.User_id+"?pointstoadd=5", token, ""); response.Code != http.StatusOK {
+		t.Fatalf("adding points got %d: %s", response.Code, response.Body.String())
+	}
+	if cached(key) {
+		t.Error("the user is still cached after a change")
+	}
+
+	// As do the admin routes on the family
+	if response := api.request("POST", "/admin/cache/families/user/warm", adminToken, ""); response.Code != http.StatusOK {
+		t.Fatalf("warming got %d: %s", response.Code, response.Body.String())
+	}
+	if !cached(key) {
+		t.Error("warming the family did not cache the user")
+	}
+	if response := api.request("DELETE", "/admin/cache/families/user", adminToken, ""); response.Code != http.StatusOK {
+		t.Fatalf("purging got %d: %s", response.Code, response.Body.String())
+	}
+	if cached(key) {
+		t.Error("purging the family left the user cached")
+	}
+}
+
+func TestPopularModulesKeepTheirCacheKey(t *testing.T) {
+	api := newTestAPI(t)
+	user, token := api.addUser("user@example.com", "")
+	_, adminToken := api.addUser("admin@example.com", models.RoleAdmin)
+	api.addTask(user.User_id)
+
+	if response := api.request("GET", "/stats/mostpopular", token, ""); response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body.String())
+	}
+
+	// Caches filled by earlier versions, and dashboards watching the family, use this name
+	var info models.CacheKeyInfo
+	decode(t, api.request("GET", "/admin/cache/keys/mostpopularmodulescache", adminToken, ""), &info)
+	if info.Family != "mostpopularmodulescache" {
+		t.Errorf("the popular modules are cached in family %q, want mostpopularmodulescache", info.Family)
+	}
+}
+
+func TestPopularModulesAreSorted(t *testing.T) {
+	api := newTestAPI(t)
+	user, token := api.addUser("user@example.com", "")
+	_, adminToken := api.addUser("admin@example.com", models.RoleAdmin)
+	for _, module := range []string{"MA1521", "CS2030", "CS1010", "CS1231", "CS2030", "CS1231", "GEA1000"} {
+		api.addModuleTask(user.User_id, module)
+	}
+
+	want := []string{"CS1231", "CS2030", "CS1010", "GEA1000", "MA1521"}
+	etag := ""
+	for i := 0; i < 10; i++ {
+		// Each read counts the tasks again
+		if response := api.request("DELETE", "/admin/cache/families/mostpopularmodulescache", adminToken, ""); response.Code != http.StatusOK {
+			t.Fatalf("purging got %d: %s", response.Code, response.Body.String())
+		}
+
+		response := api.request("GET", "/stats/mostpopular", token, "")
+		var results []models.ModulePopularity
+		decode(t, response, &results)
+		if len(results) != len(want) {
+			t.Fatalf("got %d modules, want %d", len(results), len(want))
+		}
+		for j, result := range results {
+			if *result.ID.Module_code != want[j] {
+				t.Fatalf("got %s in place %d, want %s: %s", *result.ID.Module_code, j, want[j], response.Body.String())
+			}
+		}
+
+		if etag != "" && response.Header().Get("ETag") != etag {
+			t.Fatalf("the same counts have the ETags %s and %s", etag, response.Header().Get("ETag"))
+		}
+		etag = response.Header().Get("ETag")
+	}
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// expectVaryByCaller fails unless caches are told that a response depends on the credentials of the caller
+func expectVaryByCaller(t *testing.T, response *httptest.ResponseRecorder) {
+	t.Helper()
+
+	vary := map[string]bool{}
+	for _, name := range strings.Split(response.Header().Get("Vary"), ",") {
+		vary[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	if !vary["authorization"] || !vary["token"] {
+		t.Errorf("a %d response varies by %q, want Authorization and token", response.Code, response.Header().Get("Vary"))
+	}
+}
+
+func TestUserReadsVaryByCaller(t *testing.T) {
+	api := newTestAPI(t)
+	user, token := api.addUser("user@example.com", "")
+	_, otherToken := api.addUser("other@example.com", "")
+
+	for _, path := range []string{"/users/" + user.User_id, "/cached/users/" + user.User_id} {
+		t.Run(path, func(t *testing.T) {
+			public := api.request("GET", path, otherToken, "")
+			self := api.request("GET", path, token, "")
+			if public.Code != http.StatusOK || self.Code != http.StatusOK {
+				t.Fatalf("got %d and %d", public.Code, self.Code)
+			}
+			expectVaryByCaller(t, public)
+			expectVaryByCaller(t, self)
+
+			// The user sees their email, which others do not, and the two views are told apart by their tags
+			if strings.Contains(public.Body.String(), *user.Email) || !strings.Contains(self.Body.String(), *user.Email) {
+				t.Fatalf("the views do not differ as expected: %s and %s", public.Body.String(), self.Body.String())
+			}
+			if public.Header().Get("ETag") == self.Header().Get("ETag") {
+				t.Errorf("the public view and the view of the user have the same ETag %s", self.Header().Get("ETag"))
+			}
+
+			response := api.request("GET", path, token, "", "If-None-Match", public.Header().Get("ETag"))
+			if response.Code != http.StatusOK {
+				t.Errorf("the user revalidating the public view got %d, want 200", response.Code)
+			}
+
+			response = api.request("GET", path, token, "", "If-None-Match", self.Header().Get("ETag"))
+			if response.Code != http.StatusNotModified {
+				t.Fatalf("the user revalidating their view got %d, want 304", response.Code)
+			}
+			expectVaryByCaller(t, response)
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/controllers"
+	"github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/repository"
+	"github.com/joho/godotenv"
+)
+
+// Databases the API can store its data in
+const (
+	DatabaseMongo    = "mongodb"
+	DatabasePostgres = "postgres"
+	// DatabaseSQLite keeps everything in an SQLite file in the data directory, and needs no Redis either
+	DatabaseSQLite = "sqlite"
+)
+
+// Caches the API can keep copies of database reads in
+const (
+	CacheRedis  = "redis"
+	CacheMemory = "memory"
+	CacheNone   = "none"
+)
+
+// Where the tenant of a request is read from
+const (
+	// TenantFromHeader reads the tenant from the X-Tenant header
+	TenantFromHeader = "header"
+	// TenantFromSubdomain reads the tenant from the first label of the host, as in nus.splat.example
+	TenantFromSubdomain = "subdomain"
+)
+
+// How the data of tenants is kept apart in MongoDB
+const (
+	// TenantDatabases gives each tenant a database of its own, named after the configured database and the tenant
+	TenantDatabases = "database"
+	// TenantPrefixes keeps every tenant in the configured database, in collections prefixed with the tenant
+	TenantPrefixes = "prefix"
+)
+
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// defaultCacheControl lets clients keep the polled reads, but makes them check with an ETag that they are current
+var defaultCacheControl = map[string]string{
+	"/tasks":             "private, no-cache",
+	"/tasks/:id":         "private, no-cache",
+	"/cached/tasks":      "private, no-cache",
+	"/cached/tasks/:id":  "private, no-cache",
+	"/users":             "private, no-cache",
+	"/users/:id":         "private, no-cache",
+	"/cached/users":      "private, no-cache",
+	"/cached/users/:id":  "private, no-cache",
+	"/stats/mostpopular": "private, no-cache",
+}
+
+// Config is what the API needs to know to start
+type Config struct {
+	Port string
+	// Database is one of DatabaseMongo, DatabasePostgres and DatabaseSQLite
+	Database    string
+	Mongo       repository.MongoConfig
+	PostgresURL string
+	DataDir     string
+	RedisURI    string
+	// Cache is one of CacheRedis, CacheMemory and CacheNone. When empty, Redis is used when there is one.
+	Cache      string
+	Unverified controllers.UnverifiedPolicy
+	// Links are the pages that the links in emails open
+	Links controllers.EmailLinks
+	// MigrateOnStart applies the pending schema migrations when the API starts
+	MigrateOnStart bool
+	// Tenants are the communities served by this API, each with its own data. Without tenants there is a single one.
+	// Names are lowercase, as requests are matched to tenants whatever the case. PostgreSQL serves a single tenant.
+	Tenants []string
+	// TenantFrom is TenantFromHeader or TenantFromSubdomain
+	TenantFrom string
+	// TenantIsolation is TenantDatabases or TenantPrefixes
+	TenantIsolation string
+	// CacheControl is the Cache-Control header of reads, by route
+	CacheControl map[string]string
+	// TrustedProxies are the addresses or CIDR ranges of the proxies whose X-Forwarded-For header is believed.
+	// Without any, the client IP is the address the request came from.
+	TrustedProxies []string
+	// Keys sign and verify the tokens of users
+	Keys functions.KeyConfig
+	// AdminId is the user made admin at startup while no user is one, so that someone can grant roles to the others
+	AdminId string
+}
+
+// ConfigFromEnv reads the configuration from the environment, after loading .env when there is one.
+// It fails when a setting that has no default is missing.
+func ConfigFromEnv() (Config, error) {
+	err := godotenv.Load(".env")
+	if err != nil {
+		log.Println("error loading .env file")
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8000"
+	}
+
+	database := os.Getenv("DATABASE")
+	if database == "" {
+		database = DatabaseMongo
+	}
+
+	mongoDatabase := os.Getenv("MONGO_DATABASE")
+	if mongoDatabase == "" {
+		mongoDatabase = "splatbackend"
+	}
+
+	tenants := []string{}
+	for _, tenant := range strings.Split(os.Getenv("TENANTS"), ",") {
+		tenant = normalTenant(tenant)
+		if tenant != "" {
+			tenants = append(tenants, tenant)
+		}
+	}
+
+	trustedProxies := []string{}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+
+	tenantFrom := os.Getenv("TENANT_FROM")
+	if tenantFrom == "" {
+		tenantFrom = TenantFromHeader
+	}
+
+	tenantIsolation := os.Getenv("TENANT_ISOLATION")
+	if tenantIsolation == "" {
+		tenantIsolation = TenantDatabases
+	}
+
+	// As in CACHE_CONTROL="/cached/users=private, max-age=30;/users/:id=", where an empty policy sends no header
+	cacheControl := make(map[string]string)
+	for route, policy := range defaultCacheControl {
+		cacheControl[route] = policy
+	}
+	for _, setting := range strings.Split(os.Getenv("CACHE_CONTROL"), ";") {
+		route, policy, found := strings.Cut(setting, "=")
+		route, policy = strings.TrimSpace(route), strings.TrimSpace(policy)
+		if !found || route == "" {
+			continue
+		}
+		if policy == "" {
+			delete(cacheControl, route)
+		} else {
+			cacheControl[route] = policy
+		}
+	}
+
+	mongoMinPoolSize, err := strconv.ParseUint(os.Getenv("MONGO_MIN_POOL_SIZE"), 10, 64)
+	if err != nil {
+		log.Default().Println(err)
+	}
+
+	mongoMaxPoolSize, err := strconv.ParseUint(os.Getenv("MONGO_MAX_POOL_SIZE"), 10, 64)
+	if err != nil {
+		log.Default().Println(err)
+	}
+
+	mongoMaxIdleTimeMS, err := strconv.ParseInt(os.Getenv("MONGO_MAX_IDLE_TIME_MS"), 10, 64)
+	if err != nil {
+		log.Default().Println(err)
+	}
+
+	links := controllers.EmailLinksFromEnv()
+	err = links.Check()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return Config{
+		Port:     port,
+		Database: database,
+		Mongo: repository.MongoConfig{
+			URI:         os.Getenv("MONGODB_URI"),
+			Database:    mongoDatabase,
+			MinPoolSize: mongoMinPoolSize,
+			MaxPoolSize: mongoMaxPoolSize,
+			MaxIdleTime: time.Duration(mongoMaxIdleTimeMS) * time.Millisecond,
+		},
+		PostgresURL: os.Getenv("POSTGRES_URL"),
+		DataDir:     os.Getenv("DATA_DIR"),
+		RedisURI:    os.Getenv("REDIS_URI"),
+		Cache:       os.Getenv("CACHE"),
+		Unverified:  controllers.UnverifiedPolicyFromEnv(),
+		Links:       links,
+		// Deployments running migrations as a separate step turn this off
+		MigrateOnStart:  os.Getenv("MIGRATE_ON_START") != "false",
+		Tenants:         tenants,
+		TenantFrom:      tenantFrom,
+		TenantIsolation: tenantIsolation,
+		CacheControl:    cacheControl,
+		TrustedProxies:  trustedProxies,
+		Keys:            functions.KeyConfigFromEnv(),
+		AdminId:         os.Getenv("ADMIN_ID"),
+	}, nil
+}
+
+// SelfContained switches the configuration to keeping all data in dataDir, so that no other service is needed
+func (c Config) SelfContained(dataDir string) Config {
+	c.Database = DatabaseSQLite
+	c.DataDir = dataDir
+	return c
+}
+
+// tenants lists the tenants to serve. Without configured tenants, the only one is the unnamed tenant "".
+func (c Config) tenants() []string {
+	if len(c.Tenants) == 0 {
+		return []string{""}
+	}
+	return c.Tenants
+}
+
+// checkTenants tells whether the tenant settings can be served
+func (c Config) checkTenants() error {
+	if len(c.Tenants) == 0 {
+		return nil
+	}
+
+	if c.Database == DatabasePostgres {
+		return fmt.Errorf("tenants are not supported with PostgreSQL")
+	}
+
+	if c.TenantFrom != TenantFromHeader && c.TenantFrom != TenantFromSubdomain {
+		return fmt.Errorf("unknown tenant source %q", c.TenantFrom)
+	}
+
+	if c.TenantIsolation != TenantDatabases && c.TenantIsolation != TenantPrefixes {
+		return fmt.Errorf("unknown tenant isolation %q", c.TenantIsolation)
+	}
+
+	seen := make(map[string]bool)
+	for _, tenant := range c.Tenants {
+		// Tenant names end up in database, collection and file names
+		if !tenantName.MatchString(tenant) {
+			return fmt.Errorf("invalid tenant name %q", tenant)
+		}
+		if seen[tenant] {
+			return fmt.Errorf("tenant %q is listed twice", tenant)
+		}
+		seen[tenant] = true
+	}
+
+	return nil
+}
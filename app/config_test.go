@@ -0,0 +1,89 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hauchongtang/splatbackend/functions"
+)
+
+// unsetenv removes an environment variable for the duration of a test
+func unsetenv(t *testing.T, name string) {
+	value, found := os.LookupEnv(name)
+	os.Unsetenv(name)
+	t.Cleanup(func() {
+		if found {
+			os.Setenv(name, value)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+// inDir runs the rest of a test in dir
+func inDir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestConfigReadsSecretKeyFromDotEnv(t *testing.T) {
+	unsetenv(t, "SECRET_KEY")
+	unsetenv(t, "JWT_SIGNING_KEY_FILE")
+	t.Setenv("EMAIL_VERIFICATION_URL", testLinks.Verification)
+	t.Setenv("PASSWORD_RESET_URL", testLinks.PasswordReset)
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, ".env"), []byte("SECRET_KEY=secret from dotenv\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inDir(t, dir)
+
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Keys.SecretKey != "secret from dotenv" {
+		t.Fatalf("got secret key %q, want the one in .env", config.Keys.SecretKey)
+	}
+
+	_, err = functions.KeyringFromConfig(config.Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigRequiresEmailLinks(t *testing.T) {
+	inDir(t, t.TempDir())
+
+	for _, name := range []string{"EMAIL_VERIFICATION_URL", "PASSWORD_RESET_URL"} {
+		t.Setenv("EMAIL_VERIFICATION_URL", testLinks.Verification)
+		t.Setenv("PASSWORD_RESET_URL", testLinks.PasswordReset)
+
+		for _, link := range []string{"", "splat.example/page", "ftp://splat.example/page", "https://splat.example/page?from=email"} {
+			t.Setenv(name, link)
+			_, err := ConfigFromEnv()
+			if err == nil {
+				t.Errorf("the configuration was read with %s %q", name, link)
+			}
+		}
+	}
+
+	t.Setenv("EMAIL_VERIFICATION_URL", testLinks.Verification)
+	t.Setenv("PASSWORD_RESET_URL", testLinks.PasswordReset)
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Links != testLinks {
+		t.Errorf("got links %+v, want %+v", config.Links, testLinks)
+	}
+}
@@ -0,0 +1,401 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/controllers"
+	"github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/mailer"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/oidc"
+	"github.com/hauchongtang/splatbackend/rediscache"
+	"github.com/hauchongtang/splatbackend/repository"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Dependencies are the stores and services the API runs on
+type Dependencies struct {
+	Users         repository.UserStore
+	Tasks         repository.TaskStore
+	Sessions      repository.SessionStore
+	ApiKeys       repository.ApiKeyStore
+	OneTimeTokens repository.OneTimeTokenStore
+	Cache         rediscache.Cache
+	// CachePrefix keeps the cache keys of a tenant apart from those of the others
+	CachePrefix string
+	// CacheMetrics count the use of the cache by the tenant
+	CacheMetrics  *rediscache.CacheMetrics
+	Invalidations rediscache.Invalidations
+	// Generations hear of every invalidation, so that loads running meanwhile do not cache stale values
+	Generations   *rediscache.Generations
+	Once          rediscache.OnceStore
+	LoginAttempts rediscache.AttemptStore
+	Tokens        *functions.TokenIssuer
+	Mailer        mailer.Mailer
+	Providers     map[string]*oidc.Provider
+	Clock         clock.Clock
+}
+
+// stores are the stores kept in the configured database
+type stores struct {
+	users         repository.UserStore
+	tasks         repository.TaskStore
+	sessions      repository.SessionStore
+	apiKeys       repository.ApiKeyStore
+	oneTimeTokens repository.OneTimeTokenStore
+}
+
+func sqlStores(database *repository.SQLDatabase, clock clock.Clock) stores {
+	return stores{
+		users:         repository.NewSQLUserRepository(database, clock),
+		tasks:         repository.NewSQLTaskRepository(database, clock),
+		sessions:      repository.NewSQLSessionRepository(database, clock),
+		apiKeys:       repository.NewSQLApiKeyRepository(database),
+		oneTimeTokens: repository.NewSQLOneTimeTokenRepository(database, clock),
+	}
+}
+
+// lruCacheSize is how many values the in-process cache holds
+const lruCacheSize = 10000
+
+// shared are the connections and settings that every tenant uses
+type shared struct {
+	mongoClient   *mongo.Client
+	redisClient   *redis.Client
+	cache         rediscache.Cache
+	invalidations rediscache.Invalidations
+	generations   *rediscache.Generations
+	keys          *functions.Keyring
+	providers     map[string]*oidc.Provider
+	mailer        mailer.Mailer
+	clock         clock.Clock
+}
+
+// mongoNamespace is where the collections of a tenant are in MongoDB
+func mongoNamespace(config Config, client *mongo.Client, tenant string) repository.MongoNamespace {
+	namespace := repository.MongoNamespace{Client: client, Database: config.Mongo.Database}
+	if tenant == "" {
+		return namespace
+	}
+
+	if config.TenantIsolation == TenantPrefixes {
+		namespace.CollectionPrefix = tenant + "_"
+	} else {
+		namespace.Database = config.Mongo.Database + "_" + tenant
+	}
+	return namespace
+}
+
+// openSQL opens the SQL database of a tenant. In SQLite every tenant has a file of its own.
+func openSQL(ctx context.Context, config Config, tenant string) (*repository.SQLDatabase, error) {
+	if config.Database == DatabasePostgres {
+		if tenant != "" {
+			return nil, fmt.Errorf("tenants are not supported with PostgreSQL")
+		}
+		return repository.OpenSQL(ctx, repository.Postgres, config.PostgresURL)
+	}
+
+	if config.DataDir == "" {
+		return nil, fmt.Errorf("a data directory is needed to store data in SQLite")
+	}
+
+	err := os.MkdirAll(config.DataDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	file := "splat.db"
+	if tenant != "" {
+		file = "splat_" + tenant + ".db"
+	}
+
+	return repository.OpenSQL(ctx, repository.SQLite, repository.SQLiteFile(filepath.Join(config.DataDir, file)))
+}
+
+func connectStores(ctx context.Context, config Config, mongoClient *mongo.Client, tenant string, clock clock.Clock) (stores, error) {
+	switch config.Database {
+	case DatabaseMongo:
+		namespace := mongoNamespace(config, mongoClient, tenant)
+
+		if config.MigrateOnStart {
+			err := repository.MigrateMongo(ctx, namespace)
+			if err != nil {
+				return stores{}, err
+			}
+		}
+
+		return stores{
+			users:         repository.NewUserRepository(namespace, ctx, clock),
+			tasks:         repository.NewTaskRepository(namespace, ctx, clock),
+			sessions:      repository.NewSessionRepository(namespace, clock),
+			apiKeys:       repository.NewApiKeyRepository(namespace),
+			oneTimeTokens: repository.NewOneTimeTokenRepository(namespace, clock),
+		}, nil
+	case DatabasePostgres, DatabaseSQLite:
+		database, err := openSQL(ctx, config, tenant)
+		if err != nil {
+			return stores{}, err
+		}
+
+		if config.MigrateOnStart {
+			err = database.Migrate(ctx)
+			if err != nil {
+				return stores{}, err
+			}
+		}
+
+		return sqlStores(database, clock), nil
+	}
+
+	return stores{}, fmt.Errorf("unknown database %q", config.Database)
+}
+
+// Migrate applies the pending schema migrations of the configured database, for every tenant, without starting the API
+func Migrate(ctx context.Context, config Config) error {
+	err := config.checkTenants()
+	if err != nil {
+		return err
+	}
+
+	switch config.Database {
+	case DatabaseMongo:
+		mongoClient, err := repository.NewMongoClient(ctx, config.Mongo)
+		if err != nil {
+			return err
+		}
+		defer mongoClient.Disconnect(ctx)
+
+		for _, tenant := range config.tenants() {
+			err = repository.MigrateMongo(ctx, mongoNamespace(config, mongoClient, tenant))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case DatabasePostgres, DatabaseSQLite:
+		for _, tenant := range config.tenants() {
+			database, err := openSQL(ctx, config, tenant)
+			if err != nil {
+				return err
+			}
+
+			err = database.Migrate(ctx)
+			database.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown database %q", config.Database)
+}
+
+// connectShared connects to what every tenant uses: MongoDB and Redis when they are configured, the signing keys,
+// the mailer and the OpenID Connect providers
+func connectShared(ctx context.Context, config Config) (shared, error) {
+	var err error
+	s := shared{mailer: mailer.FromEnv(), clock: clock.System{}}
+
+	if config.Database == DatabaseMongo {
+		s.mongoClient, err = repository.NewMongoClient(ctx, config.Mongo)
+		if err != nil {
+			return shared{}, err
+		}
+	}
+
+	// Without Redis, what it would hold is kept in process
+	if config.Database != DatabaseSQLite && config.RedisURI != "" {
+		s.redisClient, err = rediscache.NewClient(ctx, config.RedisURI)
+		if err != nil {
+			return shared{}, err
+		}
+	} else if config.Database != DatabaseSQLite {
+		log.Default().Println("No REDIS_URI configured, keeping the cache and revocations in process")
+	}
+
+	switch config.Cache {
+	case CacheNone:
+		s.cache = rediscache.NoopCache{}
+	case CacheMemory:
+		s.cache = rediscache.NewLRUCache(lruCacheSize, s.clock)
+	case CacheRedis, "":
+		if s.redisClient != nil {
+			s.cache = rediscache.NewRedisBreakerCache(s.redisClient)
+		} else if config.Cache == "" {
+			s.cache = rediscache.NewLRUCache(lruCacheSize, s.clock)
+		} else {
+			return shared{}, fmt.Errorf("a Redis is needed for the Redis cache")
+		}
+	default:
+		return shared{}, fmt.Errorf("unknown cache %q", config.Cache)
+	}
+
+	s.generations = rediscache.NewGenerations()
+	if s.redisClient == nil {
+		s.invalidations = rediscache.NewLocalInvalidations(s.cache, s.generations)
+	} else {
+		invalidations := rediscache.NewRedisInvalidations(s.redisClient, s.cache, s.generations)
+		// Every instance hears from the others what they invalidate, which caches in process must evict
+		go invalidations.Listen(context.Background())
+		s.invalidations = invalidations
+	}
+
+	s.keys, err = functions.KeyringFromConfig(config.Keys)
+	if err != nil {
+		return shared{}, err
+	}
+
+	s.providers, err = oidc.ProvidersFromEnv(s.clock)
+	if err != nil {
+		return shared{}, err
+	}
+
+	return s, nil
+}
+
+// dependencies builds the dependencies of one tenant. Tenants share the cache and Redis, with keys kept apart by
+// the tenant, and tokens issued for one tenant are refused by the others.
+func (s shared) dependencies(ctx context.Context, config Config, tenant string) (Dependencies, error) {
+	stores, err := connectStores(ctx, config, s.mongoClient, tenant, s.clock)
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	deps := Dependencies{
+		Users:         stores.users,
+		Tasks:         stores.tasks,
+		Sessions:      stores.sessions,
+		ApiKeys:       stores.apiKeys,
+		OneTimeTokens: stores.oneTimeTokens,
+		Cache:         s.cache,
+		CacheMetrics:  rediscache.NewCacheMetrics(),
+		Invalidations: s.invalidations,
+		Generations:   s.generations,
+		Mailer:        s.mailer,
+		Providers:     s.providers,
+		Clock:         s.clock,
+	}
+
+	var revocations rediscache.RevocationStore
+	if s.redisClient == nil {
+		revocations = rediscache.NewMemoryRevocationStore(s.clock)
+		deps.Once = rediscache.NewMemoryOnceStore(s.clock)
+		deps.LoginAttempts = rediscache.NewMemoryAttemptStore(s.clock)
+	} else {
+		// Revocations and failed logins keep being tracked on this instance while Redis is unreachable
+		revocations = rediscache.NewFallbackRevocationStore(rediscache.NewRedisRevocationStore(s.redisClient), rediscache.NewMemoryRevocationStore(s.clock))
+		deps.Once = rediscache.NewRedisOnceStore(s.redisClient)
+		deps.LoginAttempts = rediscache.NewFallbackAttemptStore(rediscache.NewRedisAttemptStore(s.redisClient, s.clock), rediscache.NewMemoryAttemptStore(s.clock))
+	}
+
+	// Revoked sessions and single use markers are keyed by random ids, which tenants cannot share by chance
+	if tenant != "" {
+		deps.CachePrefix = tenant + ":"
+		deps.LoginAttempts = rediscache.NewScopedAttemptStore(deps.LoginAttempts, tenant)
+	}
+
+	deps.Tokens = functions.NewTokenIssuer(s.keys, revocations, stores.sessions, s.clock).ForTenant(tenant)
+
+	err = bootstrapAdmin(ctx, deps, config.AdminId)
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	return deps, nil
+}
+
+// bootstrapAdmin makes adminId an admin when no user is one yet. Once there is an admin, roles are only changed
+// through the API, and adminId is ignored.
+func bootstrapAdmin(ctx context.Context, deps Dependencies, adminId string) error {
+	if adminId == "" {
+		return nil
+	}
+
+	hasAdmin, err := deps.Users.HasRole(ctx, models.RoleAdmin)
+	if err != nil || hasAdmin {
+		return err
+	}
+
+	_, err = deps.Users.SetRole(ctx, adminId, models.RoleAdmin)
+	if err == repository.ErrNotFound { // Not fatal, the user may sign up and be made admin at the next start
+		log.Default().Println("No user", adminId, "to make admin")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Default().Println("Made", adminId, "the first admin")
+	return deps.Invalidations.Invalidate(ctx, deps.CachePrefix+controllers.UserCacheKey(adminId))
+}
+
+// Connect builds the dependencies used in production, backed by MongoDB or PostgreSQL, and Redis.
+// With DatabaseSQLite nothing but the data directory is used: what Redis would hold is kept in process, as it is
+// when no REDIS_URI is configured. A Redis that stops answering is bypassed until it answers again.
+// Signing keys, the mailer and the OpenID Connect providers are read from the environment.
+func Connect(ctx context.Context, config Config) (Dependencies, error) {
+	s, err := connectShared(ctx, config)
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	return s.dependencies(ctx, config, "")
+}
+
+// ConnectTenants builds the dependencies of every configured tenant, like Connect does for a single one
+func ConnectTenants(ctx context.Context, config Config) (map[string]Dependencies, error) {
+	err := config.checkTenants()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := connectShared(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make(map[string]Dependencies)
+	for _, tenant := range config.tenants() {
+		tenants[tenant], err = s.dependencies(ctx, config, tenant)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+
+	return tenants, nil
+}
+
+// InMemory builds dependencies that live in process only, for tests and local development.
+// Nothing is persisted and nothing is shared with other instances.
+func InMemory(keys *functions.Keyring, mail mailer.Mailer, clock clock.Clock) Dependencies {
+	sessions := repository.NewMemorySessionStore(clock)
+	cache := rediscache.NewLRUCache(lruCacheSize, clock)
+	generations := rediscache.NewGenerations()
+
+	return Dependencies{
+		Users:         repository.NewMemoryUserStore(clock),
+		Tasks:         repository.NewMemoryTaskStore(clock),
+		Sessions:      sessions,
+		ApiKeys:       repository.NewMemoryApiKeyStore(),
+		OneTimeTokens: repository.NewMemoryOneTimeTokenStore(clock),
+		Cache:         cache,
+		CacheMetrics:  rediscache.NewCacheMetrics(),
+		Invalidations: rediscache.NewLocalInvalidations(cache, generations),
+		Generations:   generations,
+		Once:          rediscache.NewMemoryOnceStore(clock),
+		LoginAttempts: rediscache.NewMemoryAttemptStore(clock),
+		Tokens:        functions.NewTokenIssuer(keys, rediscache.NewMemoryRevocationStore(clock), sessions, clock),
+		Mailer:        mail,
+		Providers:     map[string]*oidc.Provider{},
+		Clock:         clock,
+	}
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/rediscache"
+)
+
+// writeSigningKey writes a new Ed25519 private key to a PEM file, and returns its path
+func writeSigningKey(t *testing.T, name string) string {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), name+".pem")
+	err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// useKeys restarts the API with the keys of config, keeping its stores
+func (a *testAPI) useKeys(config functions.KeyConfig) {
+	a.t.Helper()
+
+	keys, err := functions.KeyringFromConfig(config)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	a.deps.Tokens = functions.NewTokenIssuer(keys, rediscache.NewMemoryRevocationStore(a.clock), a.deps.Sessions, a.clock)
+	a.app = New(Config{Links: testLinks}, a.deps)
+}
+
+// kidOf reads the kid header of a token, which is empty for tokens signed with the secret key
+func kidOf(t *testing.T, token string) string {
+	t.Helper()
+
+	encoded, _, _ := strings.Cut(token, ".")
+	header, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err = json.Unmarshal(header, &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fields.Kid
+}
+
+func TestKeyRotation(t *testing.T) {
+	api := newTestAPI(t)
+	api.addUser("user@example.com", "")
+	oldKey, newKey := writeSigningKey(t, "old"), writeSigningKey(t, "new")
+
+	// Tokens of before signing keys are HS256 tokens without a kid
+	legacy := api.login("user@example.com").Token
+	if kidOf(t, legacy) != "" {
+		t.Fatal("a token signed with the secret key has a kid")
+	}
+
+	api.useKeys(functions.KeyConfig{SigningKeyFile: oldKey, SecretKey: "test secret"})
+	old := api.login("user@example.com").Token
+
+	// The new key signs, while the old one only verifies until the tokens it signed expire
+	api.useKeys(functions.KeyConfig{SigningKeyFile: newKey, VerificationKeyFiles: []string{oldKey}, SecretKey: "test secret"})
+	current := api.login("user@example.com").Token
+
+	if kidOf(t, old) == "" || kidOf(t, current) == "" || kidOf(t, old) == kidOf(t, current) {
+		t.Fatalf("the tokens of the two keys have the kids %q and %q", kidOf(t, old), kidOf(t, current))
+	}
+	for name, token := range map[string]string{"legacy": legacy, "old": old, "current": current} {
+		if response := api.request("GET", "/users/me/sessions", token, ""); response.Code != http.StatusOK {
+			t.Errorf("the %s token got %d, want 200", name, response.Code)
+		}
+	}
+
+	var jwks models.JSONWebKeySet
+	decode(t, api.request("GET", "/.well-known/jwks.json", "", ""), &jwks)
+	published := map[string]bool{}
+	for _, key := range jwks.Keys {
+		published[key.Kid] = true
+	}
+	if len(jwks.Keys) != 2 || !published[kidOf(t, old)] || !published[kidOf(t, current)] {
+		t.Errorf("the published keys are %+v, want the old and the new one", jwks.Keys)
+	}
+
+	// Once the old key and the secret key are retired, their tokens are refused
+	api.useKeys(functions.KeyConfig{SigningKeyFile: newKey, SecretKey: "test secret", RejectSecretKey: true})
+	for name, token := range map[string]string{"legacy": legacy, "old": old} {
+		if response := api.request("GET", "/users/me/sessions", token, ""); response.Code != http.StatusUnauthorized {
+			t.Errorf("the %s token got %d once its key was retired, want 401", name, response.Code)
+		}
+	}
+	if response := api.request("GET", "/users/me/sessions", current, ""); response.Code != http.StatusOK {
+		t.Errorf("the current token got %d, want 200", response.Code)
+	}
+}
@@ -0,0 +1,346 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/oidc"
+)
+
+const (
+	mockClientID    = "splat"
+	mockRedirectURL = "https://splat.example.com/users/oidc/mock/callback"
+	mockKid         = "mock-key"
+)
+
+// mockGrant is what the mock issuer remembers of an authorization request, to check the token request against it
+type mockGrant struct {
+	challenge string
+	nonce     string
+}
+
+// mockIssuer is an OpenID Connect provider running locally, which signs in the same account every time
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	clock  clock.Clock
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+func newMockIssuer(t *testing.T, clock clock.Clock) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &mockIssuer{t: t, key: key, clock: clock, grants: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": mockKid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (i *mockIssuer) provider() *oidc.Provider {
+	return oidc.NewProvider(oidc.Config{
+		Name:        "mock",
+		Issuer:      i.server.URL,
+		ClientID:    mockClientID,
+		RedirectURL: mockRedirectURL,
+		Scopes:      []string{"openid", "email", "profile"},
+	}, i.clock)
+}
+
+// authorize plays the sign in of the user at the issuer, and returns the code it sends back with the callback
+func (i *mockIssuer) authorize(location string) string {
+	i.t.Helper()
+
+	authorization, err := url.Parse(location)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	query := authorization.Query()
+
+	if authorization.Path != "/authorize" || query.Get("client_id") != mockClientID ||
+		query.Get("redirect_uri") != mockRedirectURL || query.Get("response_type") != "code" {
+		i.t.Fatalf("unexpected authorization request %s", location)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" || query.Get("nonce") == "" {
+		i.t.Fatalf("authorization request without PKCE or nonce: %s", location)
+	}
+
+	code, err := oidc.RandomString()
+	if err != nil {
+		i.t.Fatal(err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.grants[code] = mockGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	return code
+}
+
+// token trades a code for an ID token, once, and only with the verifier of the challenge it was issued for
+func (i *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	refuse := func(reason string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": reason})
+	}
+
+	if r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		refuse("not an authorization code grant")
+		return
+	}
+	if r.PostForm.Get("client_id") != mockClientID || r.PostForm.Get("redirect_uri") != mockRedirectURL {
+		refuse("unknown client")
+		return
+	}
+
+	i.mu.Lock()
+	grant, found := i.grants[r.PostForm.Get("code")]
+	delete(i.grants, r.PostForm.Get("code"))
+	i.mu.Unlock()
+
+	if !found {
+		refuse("unknown code")
+		return
+	}
+	if oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+		refuse("code verifier does not match the challenge")
+		return
+	}
+
+	now := i.clock.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            i.server.URL,
+		"sub":            "mock-subject",
+		"aud":            mockClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          grant.nonce,
+		"email":          "Mock.User@example.com",
+		"email_verified": true,
+		"given_name":     "Mock",
+		"family_name":    "User",
+	})
+	idToken.Header["kid"] = mockKid
+
+	signed, err := idToken.SignedString(i.key)
+	if err != nil {
+		i.t.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "unused", "token_type": "Bearer", "id_token": signed})
+}
+
+// newOIDCTestAPI is the API with the mock issuer as its only provider
+func newOIDCTestAPI(t *testing.T) (*testAPI, *mockIssuer) {
+	api := newTestAPI(t)
+	issuer := newMockIssuer(t, api.clock)
+
+	api.deps.Providers = map[string]*oidc.Provider{"mock": issuer.provider()}
+	api.app = New(Config{Links: testLinks}, api.deps)
+
+	return api, issuer
+}
+
+// startOIDCLogin starts a sign in, and returns the state cookie it set and the code the issuer sends back
+func startOIDCLogin(t *testing.T, api *testAPI, issuer *mockIssuer) (*http.Cookie, string, string) {
+	t.Helper()
+
+	response := api.request("GET", "/users/oidc/mock/login", "", "")
+	if response.Code != http.StatusFound {
+		t.Fatalf("got %d, want a redirect: %s", response.Code, response.Body.String())
+	}
+
+	location := response.Header().Get("Location")
+	authorization, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cookie *http.Cookie
+	for _, set := range response.Result().Cookies() {
+		if set.Name == "oidc_state" {
+			cookie = set
+		}
+	}
+	if cookie == nil {
+		t.Fatal("no oidc_state cookie was set")
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.Path != "/users/oidc/mock/callback" {
+		t.Errorf("the state cookie is not HttpOnly, Secure and limited to the callback: %+v", cookie)
+	}
+	if cookie.Value != authorization.Query().Get("state") {
+		t.Errorf("the state cookie %q does not hold the state sent to the issuer", cookie.Value)
+	}
+
+	return cookie, authorization.Query().Get("state"), issuer.authorize(location)
+}
+
+func callback(api *testAPI, state string, code string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	path := "/users/oidc/mock/callback?" + url.Values{"state": {state}, "code": {code}}.Encode()
+	if cookie == nil {
+		return api.request("GET", path, "", "")
+	}
+	return api.request("GET", path, "", "", "Cookie", cookie.Name+"="+cookie.Value)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	api, issuer := newOIDCTestAPI(t)
+
+	cookie, state, code := startOIDCLogin(t, api, issuer)
+	response := callback(api, state, code, cookie)
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body.String())
+	}
+
+	var result models.LoginResult
+	decode(t, response, &result)
+	if result.Token == "" || result.Refresh_token == "" {
+		t.Errorf("the sign in gave no tokens: %s", response.Body.String())
+	}
+
+	user, err := api.deps.Users.FindUserByIdentity(context.Background(), "mock", "mock-subject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *user.Email != "mock.user@example.com" || !user.IsEmailVerified() {
+		t.Errorf("the user was created with email %q, verified %v", *user.Email, user.IsEmailVerified())
+	}
+
+	// The state is used up with the sign in
+	if response := callback(api, state, code, cookie); response.Code != http.StatusBadRequest {
+		t.Errorf("replaying the callback got %d, want 400", response.Code)
+	}
+}
+
+func TestOIDCCallbackNeedsTheStateCookie(t *testing.T) {
+	api, issuer := newOIDCTestAPI(t)
+
+	// An attacker starts a sign in with their own account, and has the victim open its callback
+	_, state, code := startOIDCLogin(t, api, issuer)
+	if response := callback(api, state, code, nil); response.Code != http.StatusBadRequest {
+		t.Errorf("a callback without the state cookie got %d, want 400", response.Code)
+	}
+
+	// The victim started a sign in of their own, which does not make the attacker's callback valid
+	victimCookie, _, _ := startOIDCLogin(t, api, issuer)
+	if response := callback(api, state, code, victimCookie); response.Code != http.StatusBadRequest {
+		t.Errorf("a callback with the state cookie of another sign in got %d, want 400", response.Code)
+	}
+}
+
+func TestOIDCCodeNeedsItsVerifier(t *testing.T) {
+	api, issuer := newOIDCTestAPI(t)
+
+	// A code intercepted from one sign in cannot complete another, which has a different PKCE verifier
+	_, _, stolenCode := startOIDCLogin(t, api, issuer)
+	cookie, state, _ := startOIDCLogin(t, api, issuer)
+
+	if response := callback(api, state, stolenCode, cookie); response.Code != http.StatusUnauthorized {
+		t.Errorf("a code with the wrong verifier got %d, want 401: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestOIDCTakesOverUnverifiedAccount(t *testing.T) {
+	api, issuer := newOIDCTestAPI(t)
+	ctx := context.Background()
+	// The tokens of the squatter are revoked right before the owner gets theirs, in the same request
+	api.clock.Step(time.Microsecond)
+
+	// Someone signed up with the email of the provider account without verifying it, then set up an API key and
+	// a second factor of their own
+	squatter, squatterToken := api.addUserWith("mock.user@example.com", "", func(user *models.User) {
+		verified := false
+		user.Email_verified = &verified
+	})
+
+	var apiKey models.CreatedApiKey
+	decode(t, api.request("POST", "/users/apikeys", squatterToken, `{"name":"script","scopes":["users:read"]}`), &apiKey)
+	if apiKey.Key == "" {
+		t.Fatal("the squatter could not create an API key")
+	}
+
+	err := api.deps.Users.SetPendingTwoFactor(ctx, squatter.User_id, "SQUATTERSECRET", []string{"code"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = api.deps.Users.EnableTwoFactor(ctx, squatter.User_id, "SQUATTERSECRET", []string{"code"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner of the email signs in with the provider, and gets the account without the second factor
+	cookie, state, code := startOIDCLogin(t, api, issuer)
+	response := callback(api, state, code, cookie)
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body.String())
+	}
+
+	var result models.LoginResult
+	decode(t, response, &result)
+	if result.Token == "" {
+		t.Fatalf("the owner was not signed in: %s", response.Body.String())
+	}
+
+	user, err := api.deps.Users.FindUserById(ctx, squatter.User_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != nil || user.Two_factor_enabled || user.Totp_secret != nil || len(user.Recovery_codes) != 0 {
+		t.Errorf("the account kept credentials of the squatter: %+v", user)
+	}
+
+	// Nothing the squatter held still works
+	path := "/users/" + squatter.User_id
+	if response := api.request("GET", path, squatterToken, ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("the token of the squatter got %d, want 401", response.Code)
+	}
+	if response := api.request("GET", path, apiKey.Key, ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("the API key of the squatter got %d, want 401", response.Code)
+	}
+	if response := api.request("POST", "/users/login", "", loginBody("mock.user@example.com", testPassword)); response.Code == http.StatusOK {
+		t.Errorf("the password of the squatter still logs in: %s", response.Body.String())
+	}
+	if response := api.request("GET", path, result.Token, ""); response.Code != http.StatusOK {
+		t.Errorf("the owner got %d, want 200", response.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hauchongtang/splatbackend/controllers"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// unreadableUser is a user store that cannot read one user, as when the database fails on that request
+type unreadableUser struct {
+	repository.UserStore
+	userId string
+}
+
+func (s unreadableUser) FindUserById(ctx context.Context, userId string) (*models.User, error) {
+	if userId == s.userId {
+		return nil, errors.New("the database is unreachable")
+	}
+	return s.UserStore.FindUserById(ctx, userId)
+}
+
+func TestIncreasePointsRefusesWhatItCannotCheck(t *testing.T) {
+	config := Config{Unverified: controllers.UnverifiedPolicy{BlockPoints: true}}
+	api := newTestAPIWith(t, config)
+	user, _ := api.addUser("user@example.com", "")
+	_, adminToken := api.addUser("admin@example.com", models.RoleAdmin)
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"with points that are not a number", "/users/" + user.User_id + "?pointstoadd=many", http.StatusBadRequest},
+		{"without points", "/users/" + user.User_id, http.StatusBadRequest},
+		{"to a missing user", "/users/" + primitive.NewObjectID().Hex() + "?pointstoadd=5", http.StatusNotFound},
+	} {
+		response := api.request("PUT", test.path, adminToken, "")
+		if response.Code != test.status {
+			t.Errorf("adding points %s got %d, want %d: %s", test.name, response.Code, test.status, response.Body.String())
+		}
+	}
+
+	// Whether the user may earn points is unknown when they cannot be read
+	api.deps.Users = unreadableUser{api.deps.Users, user.User_id}
+	api.app = New(Config{Unverified: config.Unverified, Links: testLinks}, api.deps)
+	response := api.request("PUT", "/users/"+user.User_id+"?pointstoadd=5", adminToken, "")
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("adding points to a user that cannot be read got %d, want 500", response.Code)
+	}
+
+	found, err := api.deps.Users.(unreadableUser).UserStore.FindUserById(context.Background(), user.User_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Points != 0 {
+		t.Errorf("the user got %d points, want none", found.Points)
+	}
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// login logs a user in with the test password
+func (a *testAPI) login(email string) models.LoginResult {
+	a.t.Helper()
+
+	var login models.LoginResult
+	decode(a.t, a.request("POST", "/users/login", "", loginBody(email, testPassword)), &login)
+	if login.Token == "" || login.Refresh_token == "" {
+		a.t.Fatal("the login got no tokens")
+	}
+	return login
+}
+
+// refresh exchanges a refresh token, returning the status and the new tokens
+func (a *testAPI) refresh(refreshToken string) (int, models.TokenResult) {
+	a.t.Helper()
+
+	var tokens models.TokenResult
+	response := a.request("POST", "/users/refresh", "", `{"refresh_token":"`+refreshToken+`"}`)
+	if response.Code == http.StatusOK {
+		decode(a.t, response, &tokens)
+	}
+	return response.Code, tokens
+}
+
+func TestRefreshTokenReuseEndsTheSession(t *testing.T) {
+	api := newTestAPI(t)
+	api.addUser("user@example.com", "")
+	stolen := api.login("user@example.com")
+	other := api.login("user@example.com")
+
+	api.clock.Advance(time.Minute)
+	status, rotated := api.refresh(stolen.Refresh_token)
+	if status != http.StatusOK {
+		t.Fatalf("the first refresh got %d", status)
+	}
+
+	// Whoever replays the refresh token that was rotated away, the user or a thief, ends the session for both
+	api.clock.Advance(time.Minute)
+	if status, _ := api.refresh(stolen.Refresh_token); status != http.StatusUnauthorized {
+		t.Fatalf("replaying a used refresh token got %d, want 401", status)
+	}
+	if status, _ := api.refresh(rotated.Refresh_token); status != http.StatusUnauthorized {
+		t.Errorf("the refresh token rotated in got %d after the replay, want 401", status)
+	}
+	for name, token := range map[string]string{"first": stolen.Token, "rotated": rotated.Token} {
+		if response := api.request("GET", "/users/me/sessions", token, ""); response.Code != http.StatusUnauthorized {
+			t.Errorf("the %s access token of the session got %d after the replay, want 401", name, response.Code)
+		}
+	}
+
+	// Other sessions of the user go on
+	if response := api.request("GET", "/users/me/sessions", other.Token, ""); response.Code != http.StatusOK {
+		t.Errorf("another session got %d, want 200", response.Code)
+	}
+	if status, _ := api.refresh(other.Refresh_token); status != http.StatusOK {
+		t.Errorf("the refresh token of another session got %d, want 200", status)
+	}
+}
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/middleware"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// Who may call a route
+const (
+	// public routes can be called by anyone
+	public = iota
+	// authenticated routes can be called by any user
+	authenticated
+	// self routes act on resources of the caller, and do not find those of others
+	self
+	// owner routes act on a resource of a user, and can be called by that user or an admin
+	owner
+	// admin routes can only be called by admins
+	admin
+)
+
+// routeAccess lists every route with who may call it. In paths and bodies, {user} is replaced with the user owning
+// the targeted resources, {task} with a task of theirs, {session} with their session and {apikey} with an API key
+// of theirs.
+var routeAccess = []struct {
+	method string
+	route  string
+	path   string
+	body   string
+	access int
+}{
+	{"POST", "/users/signup", "/users/signup", `{}`, public},
+	{"POST", "/users/login", "/users/login", `{}`, public},
+	{"POST", "/users/login/2fa", "/users/login/2fa", `{}`, public},
+	{"POST", "/users/refresh", "/users/refresh", `{}`, public},
+	{"GET", "/users/oidc/:provider/login", "/users/oidc/unknown/login", ``, public},
+	{"GET", "/users/oidc/:provider/callback", "/users/oidc/unknown/callback", ``, public},
+	{"POST", "/users/password/forgot", "/users/password/forgot", `{}`, public},
+	{"POST", "/users/password/reset", "/users/password/reset", `{}`, public},
+	{"GET", "/users/verify", "/users/verify", ``, public},
+	{"POST", "/users/verify/resend", "/users/verify/resend", ``, authenticated},
+	{"POST", "/users/unlock", "/users/unlock?email=a@example.com", ``, admin},
+	{"POST", "/users/2fa/enroll", "/users/2fa/enroll", ``, authenticated},
+	{"POST", "/users/2fa/confirm", "/users/2fa/confirm", `{}`, authenticated},
+	{"POST", "/users/2fa/disable", "/users/2fa/disable", `{}`, authenticated},
+	{"POST", "/users/apikeys", "/users/apikeys", `{}`, authenticated},
+	{"GET", "/users/apikeys", "/users/apikeys", ``, authenticated},
+	{"DELETE", "/users/apikeys/:keyId", "/users/apikeys/{apikey}", ``, self},
+	{"GET", "/users/me/sessions", "/users/me/sessions", ``, authenticated},
+	{"DELETE", "/users/me/sessions/:id", "/users/me/sessions/{session}", ``, self},
+	{"POST", "/users/logout", "/users/logout", ``, authenticated},
+	{"POST", "/users/logout/all", "/users/logout/all", ``, authenticated},
+
+	{"GET", "/users", "/users", ``, authenticated},
+	{"GET", "/users/:id", "/users/{user}", ``, authenticated},
+	{"GET", "/cached/users/:id", "/cached/users/{user}", ``, authenticated},
+	{"GET", "/cached/users", "/cached/users", ``, authenticated},
+	{"PUT", "/users/:id", "/users/{user}?pointstoadd=5", ``, owner},
+	{"PUT", "/users/update/:id", "/users/update/{user}?first_name=Changed", ``, owner},
+	{"PUT", "/users/modules/:id", "/users/modules/{user}?link=https://nusmods.com/timetable", ``, owner},
+	{"PUT", "/users/role/:id", "/users/role/{user}?role=moderator", ``, admin},
+	{"DELETE", "/users/:id", "/users/{user}", ``, admin},
+
+	{"GET", "/tasks", "/tasks", ``, authenticated},
+	{"GET", "/cached/tasks", "/cached/tasks", ``, authenticated},
+	{"GET", "/tasks/:id", "/tasks/{user}", ``, authenticated},
+	{"GET", "/cached/tasks/:id", "/cached/tasks/{user}", ``, authenticated},
+	{"PUT", "/tasks/:id", "/tasks/{task}", ``, owner},
+	{"POST", "/tasks", "/tasks", `{"user_id":"{user}","moduleCode":"CS2040","taskName":"Lab","duration":"60"}`, owner},
+
+	{"GET", "/stats/mostpopular", "/stats/mostpopular", ``, authenticated},
+
+	{"GET", "/metrics", "/metrics", ``, admin},
+	{"GET", "/admin/cache/keys/:key", "/admin/cache/keys/alluserscache", ``, admin},
+	{"DELETE", "/admin/cache/families/:family", "/admin/cache/families/alluserscache", ``, admin},
+	{"POST", "/admin/cache/families/:family/warm", "/admin/cache/families/alluserscache/warm", ``, admin},
+	{"POST", "/admin/cache/warm", "/admin/cache/warm", ``, admin},
+
+	{"GET", "/.well-known/jwks.json", "/.well-known/jwks.json", ``, public},
+	{"GET", "/docs/*any", "/docs/index.html", ``, public},
+	{"GET", "/splat/api", "/splat/api", ``, authenticated},
+}
+
+// TestEveryRouteIsListed keeps routeAccess complete, so that new routes get their access tested
+func TestEveryRouteIsListed(t *testing.T) {
+	listed := make(map[string]bool)
+	for _, route := range routeAccess {
+		listed[route.method+" "+route.route] = true
+	}
+
+	for _, route := range newTestAPI(t).app.router.Routes() {
+		if !listed[route.Method+" "+route.Path] {
+			t.Errorf("%s %s is missing from routeAccess", route.Method, route.Path)
+		}
+	}
+}
+
+func TestRouteAccess(t *testing.T) {
+	callers := []string{"anonymous", "owner", "other user", "admin"}
+
+	for _, route := range routeAccess {
+		for _, caller := range callers {
+			t.Run(route.method+" "+route.route+" as "+caller, func(t *testing.T) {
+				// Every caller gets an API of its own, since calls like DELETE /users/:id change what later calls see
+				api := newTestAPI(t)
+				target, targetToken := api.addUser("owner@example.com", "")
+				_, otherToken := api.addUser("other@example.com", "")
+				_, adminToken := api.addUser("admin@example.com", models.RoleAdmin)
+
+				var sessions []models.Session
+				decode(t, api.request("GET", "/users/me/sessions", targetToken, ""), &sessions)
+
+				var apiKey models.CreatedApiKey
+				created := api.request("POST", "/users/apikeys", targetToken, `{"name":"script","scopes":["tasks:read"]}`)
+				decode(t, created, &apiKey)
+
+				// The owner of a POST /tasks is the user the task is added for, which only admins choose freely
+				replacer := strings.NewReplacer(
+					"{user}", target.User_id,
+					"{task}", api.addTask(target.User_id).ID.Hex(),
+					"{session}", sessions[0].Session_id,
+					"{apikey}", apiKey.Key_id,
+				)
+
+				token := map[string]string{"anonymous": "", "owner": targetToken, "other user": otherToken, "admin": adminToken}[caller]
+				response := api.request(route.method, replacer.Replace(route.path), token, replacer.Replace(route.body))
+
+				want := expectedAccess(route.access, caller)
+				switch {
+				case want == 0 && (response.Code == http.StatusUnauthorized || response.Code == http.StatusForbidden):
+					t.Errorf("got %d, want access: %s", response.Code, response.Body.String())
+				case want != 0 && response.Code != want:
+					t.Errorf("got %d, want %d: %s", response.Code, want, response.Body.String())
+				}
+			})
+		}
+	}
+}
+
+// expectedAccess is the status a caller gets from a route it may not call, or 0 when it may call it
+func expectedAccess(access int, caller string) int {
+	if caller == "anonymous" {
+		if access == public {
+			return 0
+		}
+		return http.StatusUnauthorized
+	}
+
+	switch access {
+	case self:
+		if caller != "owner" {
+			return http.StatusNotFound
+		}
+	case owner:
+		if caller == "other user" {
+			return http.StatusForbidden
+		}
+	case admin:
+		if caller != "admin" {
+			return http.StatusForbidden
+		}
+	}
+	return 0
+}
+
+// TestAddTaskForAnotherUser checks that a task cannot be forged under the id of someone else
+func TestAddTaskForAnotherUser(t *testing.T) {
+	api := newTestAPI(t)
+	victim, _ := api.addUser("victim@example.com", "")
+	_, attackerToken := api.addUser("attacker@example.com", "")
+
+	response := api.request("POST", "/tasks", attackerToken, `{"user_id":"`+victim.User_id+`","moduleCode":"CS1010"}`)
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("got %d, want 403: %s", response.Code, response.Body.String())
+	}
+
+	var tasks []models.Task
+	decode(t, api.request("GET", "/tasks/"+victim.User_id, attackerToken, ""), &tasks)
+	if len(tasks) != 0 {
+		t.Errorf("the victim has %d tasks, want none", len(tasks))
+	}
+
+	// Without a user_id, the task is the caller's
+	response = api.request("POST", "/tasks", attackerToken, `{"moduleCode":"CS1010"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", response.Code, response.Body.String())
+	}
+}
+
+// TestOptionalAuthentication checks that a route open to anonymous callers still tells them from logged-in ones
+func TestOptionalAuthentication(t *testing.T) {
+	api := newTestAPI(t)
+	user, token := api.addUser("user@example.com", "")
+
+	auth := middleware.NewAuth(api.deps.Tokens, api.deps.Users, api.deps.ApiKeys, api.deps.Tasks, api.deps.Clock)
+	router := gin.New()
+	router.GET("/caller", auth.OptionalAuthentication(models.ScopeUsersRead), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"authenticated": middleware.IsAuthenticated(c), "uid": c.GetString("uid")})
+	})
+	api.app = &App{router: router}
+
+	var anonymous, loggedIn struct {
+		Authenticated bool
+		Uid           string
+	}
+	decode(t, api.request("GET", "/caller", "", ""), &anonymous)
+	if anonymous.Authenticated || anonymous.Uid != "" {
+		t.Errorf("an anonymous caller was taken for %+v", anonymous)
+	}
+
+	decode(t, api.request("GET", "/caller", token, ""), &loggedIn)
+	if !loggedIn.Authenticated || loggedIn.Uid != user.User_id {
+		t.Errorf("a logged-in caller was taken for %+v", loggedIn)
+	}
+
+	// A token that is sent has to be valid, so that clients notice when theirs expired
+	if response := api.request("GET", "/caller", "not a token", ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("got %d with an invalid token, want 401", response.Code)
+	}
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/rediscache"
+)
+
+// secretField matches the names of the fields users must never see, in JSON keys and in the Go fields of cached values
+var secretField = regexp.MustCompile(`(?i)password|token|totp|recovery|secret|subject`)
+
+// recordingCache keeps a description of every value put in the cache, unexported and untagged fields included
+type recordingCache struct {
+	rediscache.Cache
+
+	mu     sync.Mutex
+	values []string
+}
+
+func (r *recordingCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	r.mu.Lock()
+	r.values = append(r.values, fmt.Sprintf("%s: %+v", key, value))
+	r.mu.Unlock()
+
+	return r.Cache.Set(ctx, key, value, ttl)
+}
+
+// userSecrets stores the secrets of a user under values that tests can look for
+func userSecrets(totpSecret string) func(user *models.User) {
+	return func(user *models.User) {
+		token, refreshToken, pendingSecret := "stored-access-token", "stored-refresh-token", "PENDINGTOTPSECRET"
+		user.Token = &token
+		user.Refresh_token = &refreshToken
+		user.Totp_secret = &totpSecret
+		user.Totp_pending_secret = &pendingSecret
+		user.Recovery_codes = []string{"stored-recovery-code-hash"}
+		user.Pending_recovery_codes = []string{"stored-pending-recovery-code-hash"}
+		user.Identities = []models.Identity{{Provider: "mock", Subject: "stored-provider-subject", Email: *user.Email}}
+		user.Two_factor_enabled = totpSecret != ""
+	}
+}
+
+// secretValues are the stored secrets of a user set up by userSecrets
+func secretValues(user models.User) []string {
+	values := []string{*user.Password, *user.Token, *user.Refresh_token, *user.Totp_pending_secret, user.Identities[0].Subject}
+	values = append(values, user.Recovery_codes...)
+	values = append(values, user.Pending_recovery_codes...)
+	if *user.Totp_secret != "" {
+		values = append(values, *user.Totp_secret)
+	}
+	return values
+}
+
+// findSecrets lists the secret keys found in a JSON value, except those allowed
+func findSecrets(value interface{}, allowed map[string]bool) []string {
+	var found []string
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if secretField.MatchString(key) && !allowed[key] {
+				found = append(found, key)
+			}
+			found = append(found, findSecrets(field, allowed)...)
+		}
+	case []interface{}:
+		for _, element := range value {
+			found = append(found, findSecrets(element, allowed)...)
+		}
+	}
+	return found
+}
+
+// expectNoSecrets fails when a response shows a secret of users, by its key or by its stored value
+func expectNoSecrets(t *testing.T, body string, values []string, allowed map[string]bool) {
+	t.Helper()
+
+	var decoded interface{}
+	err := json.Unmarshal([]byte(body), &decoded)
+	if err != nil {
+		t.Fatalf("%v in %s", err, body)
+	}
+
+	if keys := findSecrets(decoded, allowed); len(keys) > 0 {
+		t.Errorf("the response shows %v: %s", keys, body)
+	}
+	for _, value := range values {
+		if strings.Contains(body, value) {
+			t.Errorf("the response shows the stored secret %q: %s", value, body)
+		}
+	}
+}
+
+func TestUserRoutesHideSecrets(t *testing.T) {
+	api := newTestAPI(t)
+	cache := &recordingCache{Cache: api.deps.Cache}
+	api.deps.Cache = cache
+	api.app = New(Config{Links: testLinks}, api.deps)
+
+	totpSecret, err := functions.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, userToken := api.addUserWith("user@example.com", "", userSecrets(""))
+	twoFactorUser, _ := api.addUserWith("2fa@example.com", "", userSecrets(totpSecret))
+	_, adminToken := api.addUser("admin@example.com", models.RoleAdmin)
+
+	secrets := append(secretValues(user), secretValues(twoFactorUser)...)
+
+	// Logins answer with new tokens, and nothing else secret. They come first, as changing the role of a user
+	// logs them out.
+	allowed := map[string]bool{"token": true, "refresh_token": true}
+
+	response := api.request("POST", "/users/login", "", loginBody("user@example.com", testPassword))
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body.String())
+	}
+	expectNoSecrets(t, response.Body.String(), secrets, allowed)
+
+	var challenge models.TwoFactorChallenge
+	decode(t, api.request("POST", "/users/login", "", loginBody("2fa@example.com", testPassword)), &challenge)
+	code, err := functions.TOTPCode(totpSecret, functions.TOTPStep(api.clock.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response = api.request("POST", "/users/login/2fa", "", `{"challenge_token":"`+challenge.Challenge_token+`","code":"`+code+`"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body.String())
+	}
+	expectNoSecrets(t, response.Body.String(), secrets, allowed)
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/users"},
+		{"GET", "/users/{user}"},
+		{"GET", "/cached/users"},
+		{"GET", "/cached/users/{user}"},
+		{"PUT", "/users/{user}?pointstoadd=1"},
+		{"PUT", "/users/update/{user}?first_name=Changed"},
+		{"PUT", "/users/modules/{user}?link=https://nusmods.com/timetable"},
+		{"PUT", "/users/role/{user}?role=user"},
+	}
+
+	for _, route := range routes {
+		for _, id := range []string{user.User_id, twoFactorUser.User_id} {
+			path := strings.ReplaceAll(route.path, "{user}", id)
+
+			for caller, token := range map[string]string{"anonymous": "", "owner": userToken, "admin": adminToken} {
+				response := api.request(route.method, path, token, "")
+				if response.Code != http.StatusOK {
+					continue // Refused calls show nothing of users
+				}
+
+				t.Run(route.method+" "+path+" as "+caller, func(t *testing.T) {
+					expectNoSecrets(t, response.Body.String(), secrets, nil)
+				})
+			}
+		}
+	}
+
+	// The cache holds the users the cached routes answer with, so it must not hold their secrets either
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if len(cache.values) == 0 {
+		t.Fatal("nothing was cached")
+	}
+	for _, value := range cache.values {
+		if field := secretField.FindString(value); field != "" {
+			t.Errorf("the cache holds a %s field: %s", field, value)
+		}
+		for _, secret := range secrets {
+			if strings.Contains(value, secret) {
+				t.Errorf("the cache holds the stored secret %q: %s", secret, value)
+			}
+		}
+	}
+}
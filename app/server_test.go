@@ -0,0 +1,107 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// call sends a request to a running server, and decodes its JSON answer into value when it is not nil
+func call(t *testing.T, server *httptest.Server, method string, path string, token string, body string, value interface{}) int {
+	t.Helper()
+
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if value != nil && response.StatusCode == http.StatusOK {
+		err = json.NewDecoder(response.Body).Decode(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response.StatusCode
+}
+
+// TestTokenLifetimes serves the whole API over HTTP, and lets tokens and sessions expire on the fake clock
+func TestTokenLifetimes(t *testing.T) {
+	api := newTestAPI(t)
+	api.addUser("user@example.com", "")
+
+	server := httptest.NewServer(api.app)
+	defer server.Close()
+
+	var login models.LoginResult
+	if status := call(t, server, "POST", "/users/login", "", loginBody("user@example.com", testPassword), &login); status != http.StatusOK {
+		t.Fatalf("login got %d", status)
+	}
+
+	api.clock.Advance(23 * time.Hour)
+	if status := call(t, server, "GET", "/users/me/sessions", login.Token, "", nil); status != http.StatusOK {
+		t.Fatalf("got %d within the lifetime of the access token", status)
+	}
+
+	api.clock.Advance(2 * time.Hour)
+	if status := call(t, server, "GET", "/users/me/sessions", login.Token, "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("got %d past the lifetime of the access token, want 401", status)
+	}
+
+	var refreshed models.TokenResult
+	status := call(t, server, "POST", "/users/refresh", "", `{"refresh_token":"`+login.Refresh_token+`"}`, &refreshed)
+	if status != http.StatusOK {
+		t.Fatalf("refresh got %d", status)
+	}
+	if status := call(t, server, "GET", "/users/me/sessions", refreshed.Token, "", nil); status != http.StatusOK {
+		t.Fatalf("got %d with the refreshed access token", status)
+	}
+
+	// A session left unused for longer than a refresh token lives is over
+	api.clock.Advance(8 * 24 * time.Hour)
+	status = call(t, server, "POST", "/users/refresh", "", `{"refresh_token":"`+refreshed.Refresh_token+`"}`, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("refresh got %d past the lifetime of the session, want 401", status)
+	}
+}
+
+// TestLoginRightAfterLogoutEverywhere logs in within the second of a log out everywhere, which only ends older tokens
+func TestLoginRightAfterLogoutEverywhere(t *testing.T) {
+	api := newTestAPI(t)
+	_, oldToken := api.addUser("user@example.com", "")
+
+	if response := api.request("POST", "/users/logout/all", oldToken, ""); response.Code != http.StatusOK {
+		t.Fatalf("logout got %d: %s", response.Code, response.Body.String())
+	}
+
+	api.clock.Advance(10 * time.Millisecond)
+	var login models.LoginResult
+	decode(t, api.request("POST", "/users/login", "", loginBody("user@example.com", testPassword)), &login)
+
+	if response := api.request("GET", "/users/me/sessions", login.Token, ""); response.Code != http.StatusOK {
+		t.Errorf("the new login got %d, want 200: %s", response.Code, response.Body.String())
+	}
+	if response := api.request("GET", "/users/me/sessions", oldToken, ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("the token from before the logout got %d, want 401", response.Code)
+	}
+
+	var refreshed models.TokenResult
+	decode(t, api.request("POST", "/users/refresh", "", `{"refresh_token":"`+login.Refresh_token+`"}`), &refreshed)
+	if refreshed.Token == "" {
+		t.Error("the refresh token of the new login was refused")
+	}
+}
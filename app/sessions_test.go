@@ -0,0 +1,80 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// loginFrom logs a user in from a browser, and returns the tokens
+func (a *testAPI) loginFrom(email string, userAgent string) models.LoginResult {
+	a.t.Helper()
+
+	var login models.LoginResult
+	decode(a.t, a.request("POST", "/users/login", "", loginBody(email, testPassword), "User-Agent", userAgent), &login)
+	return login
+}
+
+// sessions lists the sessions of the user of token, by device
+func (a *testAPI) sessions(token string) map[string]models.Session {
+	a.t.Helper()
+
+	var sessions []models.Session
+	decode(a.t, a.request("GET", "/users/me/sessions", token, ""), &sessions)
+
+	devices := make(map[string]models.Session)
+	for _, session := range sessions {
+		devices[session.Device] = session
+	}
+	return devices
+}
+
+func TestSessions(t *testing.T) {
+	api := newTestAPI(t)
+	// addUser starts a session too, on the device "test"
+	api.addUser("user@example.com", "")
+	_, otherToken := api.addUser("other@example.com", "")
+
+	laptop := api.loginFrom("user@example.com", "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0")
+	api.clock.Advance(time.Minute)
+	phone := api.loginFrom("user@example.com", "Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36")
+
+	sessions := api.sessions(laptop.Token)
+	if len(sessions) != 3 {
+		t.Fatalf("got sessions %+v, want one per login", sessions)
+	}
+	onLaptop, onPhone := sessions["Firefox on Linux"], sessions["Chrome on Android"]
+	if !onLaptop.Current || onPhone.Current {
+		t.Errorf("the session of the request is not the only current one: %+v", sessions)
+	}
+	if !onPhone.Created_at.After(onLaptop.Created_at) || onPhone.Ip == "" {
+		t.Errorf("the sessions do not tell when and where they started: %+v", sessions)
+	}
+
+	// Another user can neither list nor end the sessions
+	if sessions := api.sessions(otherToken); len(sessions) != 1 {
+		t.Errorf("another user lists %d sessions, want their own only", len(sessions))
+	}
+	if response := api.request("DELETE", "/users/me/sessions/"+onPhone.Session_id, otherToken, ""); response.Code != http.StatusNotFound {
+		t.Errorf("another user ending the session got %d, want 404", response.Code)
+	}
+	if response := api.request("DELETE", "/users/me/sessions/unknown", laptop.Token, ""); response.Code != http.StatusNotFound {
+		t.Errorf("ending an unknown session got %d, want 404", response.Code)
+	}
+
+	// Ending the session of the phone logs it out at once, and leaves the laptop logged in
+	if response := api.request("DELETE", "/users/me/sessions/"+onPhone.Session_id, laptop.Token, ""); response.Code != http.StatusOK {
+		t.Fatalf("ending the session got %d: %s", response.Code, response.Body.String())
+	}
+	if response := api.request("GET", "/users/me/sessions", phone.Token, ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("the access token of the ended session got %d, want 401", response.Code)
+	}
+	if status, _ := api.refresh(phone.Refresh_token); status != http.StatusUnauthorized {
+		t.Errorf("the refresh token of the ended session got %d, want 401", status)
+	}
+	if sessions := api.sessions(laptop.Token); len(sessions) != 2 || !sessions["Firefox on Linux"].Current {
+		t.Errorf("after ending the phone session, the sessions are %+v", sessions)
+	}
+}
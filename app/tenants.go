@@ -0,0 +1,62 @@
+package app
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TenantHeader names the tenant of a request when tenants are read from a header
+const TenantHeader = "X-Tenant"
+
+// Tenants serves several tenants, each with an App of its own, and sends every request to the App of its tenant
+type Tenants struct {
+	config Config
+	apps   map[string]*App
+}
+
+func NewTenants(config Config, tenants map[string]Dependencies) *Tenants {
+	apps := make(map[string]*App)
+	for tenant, deps := range tenants {
+		apps[tenant] = New(config, deps)
+	}
+
+	return &Tenants{config: config, apps: apps}
+}
+
+// normalTenant is a tenant name as tenants are configured, whatever its case and surrounding spaces
+func normalTenant(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// tenant reads the tenant a request is for
+func (t *Tenants) tenant(r *http.Request) string {
+	if t.config.TenantFrom == TenantFromSubdomain {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		label, _, _ := strings.Cut(host, ".")
+		return normalTenant(label)
+	}
+
+	return normalTenant(r.Header.Get(TenantHeader))
+}
+
+func (t *Tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app, found := t.apps[t.tenant(r)]
+	if !found {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "unknown tenant"})
+		return
+	}
+
+	app.ServeHTTP(w, r)
+}
+
+// Run serves every tenant on the configured port until it fails
+func (t *Tenants) Run() error {
+	return http.ListenAndServe(":"+t.config.Port, t)
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/functions"
+)
+
+// tenantRequest sends a request to the tenants, for the tenant named in the X-Tenant header
+func tenantRequest(t *testing.T, tenants http.Handler, tenant string, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(TenantHeader, tenant)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response := httptest.NewRecorder()
+	tenants.ServeHTTP(response, request)
+	return response
+}
+
+// connectTestTenants connects tenants nus and ntu, each in a SQLite file of its own
+func connectTestTenants(t *testing.T, config Config) map[string]Dependencies {
+	t.Helper()
+
+	config.Database = DatabaseSQLite
+	config.DataDir = t.TempDir()
+	config.MigrateOnStart = true
+	config.Tenants = []string{"nus", "ntu"}
+	config.TenantIsolation = TenantDatabases
+	config.Keys = functions.KeyConfig{SecretKey: "test secret"}
+
+	tenants, err := ConnectTenants(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tenants
+}
+
+func TestTenantsAreIsolated(t *testing.T) {
+	config := Config{TenantFrom: TenantFromHeader}
+	deps := connectTestTenants(t, config)
+	tenants := NewTenants(config, deps)
+
+	// The helpers of testAPI store users and tasks straight into the stores of a tenant
+	seed := func(tenant string) *testAPI {
+		return &testAPI{t: t, deps: deps[tenant], clock: clock.NewFake(time.Now().Truncate(time.Second))}
+	}
+	nus, ntu := seed("nus"), seed("ntu")
+
+	nusUser, nusToken := nus.addUser("user@nus.example.com", "")
+	nusTask := nus.addTask(nusUser.User_id)
+	ntuUser, ntuToken := ntu.addUser("user@ntu.example.com", "")
+	ntu.addTask(ntuUser.User_id)
+
+	routes := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/users", ""},
+		{"GET", "/users/" + nusUser.User_id, ""},
+		{"GET", "/cached/users", ""},
+		{"GET", "/cached/users/" + nusUser.User_id, ""},
+		{"GET", "/tasks", ""},
+		{"GET", "/tasks/" + nusUser.User_id, ""},
+		{"GET", "/cached/tasks", ""},
+		{"GET", "/cached/tasks/" + nusUser.User_id, ""},
+		{"POST", "/users/login", loginBody("user@nus.example.com", testPassword)},
+	}
+
+	// What nus reads first is in the cache that both tenants share, which must not hand it to ntu
+	for _, route := range routes {
+		response := tenantRequest(t, tenants, "nus", route.method, route.path, nusToken, route.body)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s %s for nus got %d: %s", route.method, route.path, response.Code, response.Body.String())
+		}
+	}
+
+	leaks := []string{nusUser.User_id, *nusUser.Email, nusTask.ID.Hex()}
+	for _, route := range routes {
+		for _, token := range []string{"", ntuToken} {
+			response := tenantRequest(t, tenants, "ntu", route.method, route.path, token, route.body)
+			if route.path == "/users/login" && response.Code == http.StatusOK {
+				t.Errorf("a user of nus logged in to ntu: %s", response.Body.String())
+			}
+			for _, leak := range leaks {
+				if strings.Contains(response.Body.String(), leak) {
+					t.Errorf("%s %s for ntu shows %s of nus: %s", route.method, route.path, leak, response.Body.String())
+				}
+			}
+		}
+	}
+
+	// A token of nus is no key to ntu
+	if response := tenantRequest(t, tenants, "ntu", "GET", "/users/me/sessions", nusToken, ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("a token of nus got %d from ntu, want 401", response.Code)
+	}
+
+	if response := tenantRequest(t, tenants, "unknown", "GET", "/users", "", ""); response.Code != http.StatusNotFound {
+		t.Errorf("an unknown tenant got %d, want 404", response.Code)
+	}
+}
+
+func TestTenantNamesIgnoreCase(t *testing.T) {
+	for _, tenantFrom := range []string{TenantFromHeader, TenantFromSubdomain} {
+		t.Run(tenantFrom, func(t *testing.T) {
+			config := Config{TenantFrom: tenantFrom}
+			deps := connectTestTenants(t, config)
+			tenants := NewTenants(config, deps)
+
+			nus := &testAPI{t: t, deps: deps["nus"], clock: clock.NewFake(time.Now().Truncate(time.Second))}
+			user, token := nus.addUser("user@nus.example.com", "")
+
+			for _, name := range []string{"nus", "NUS", " Nus "} {
+				request := httptest.NewRequest("GET", "/users/"+user.User_id, nil)
+				request.Header.Set("Authorization", "Bearer "+token)
+				if tenantFrom == TenantFromSubdomain {
+					request.Host = strings.TrimSpace(name) + ".splat.example:8080"
+				} else {
+					request.Header.Set(TenantHeader, name)
+				}
+
+				response := httptest.NewRecorder()
+				tenants.ServeHTTP(response, request)
+				if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), user.User_id) {
+					t.Errorf("tenant %q got %d: %s", name, response.Code, response.Body.String())
+				}
+			}
+		})
+	}
+}
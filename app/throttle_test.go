@@ -0,0 +1,148 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+func loginBody(email string, password string) string {
+	return `{"email":"` + email + `","password":"` + password + `"}`
+}
+
+// failLogin logs in with a wrong password, and returns the Retry-After header of the answer
+func failLogin(t *testing.T, api *testAPI, email string) string {
+	t.Helper()
+
+	response := api.request("POST", "/users/login", "", loginBody(email, "wrong password"))
+	if response.Code == http.StatusOK || response.Code == http.StatusTooManyRequests {
+		t.Fatalf("got %d for a wrong password: %s", response.Code, response.Body.String())
+	}
+	return response.Header().Get("Retry-After")
+}
+
+func expectLogin(t *testing.T, api *testAPI, email string, want int, wantRetryAfter string) {
+	t.Helper()
+
+	response := api.request("POST", "/users/login", "", loginBody(email, testPassword))
+	if response.Code != want {
+		t.Fatalf("got %d, want %d: %s", response.Code, want, response.Body.String())
+	}
+	if retryAfter := response.Header().Get("Retry-After"); retryAfter != wantRetryAfter {
+		t.Errorf("got Retry-After %q, want %q", retryAfter, wantRetryAfter)
+	}
+}
+
+func TestLoginBackoff(t *testing.T) {
+	api := newTestAPI(t)
+	api.addUser("user@example.com", "")
+
+	for i := 0; i < 3; i++ {
+		if retryAfter := failLogin(t, api, "user@example.com"); retryAfter != "" {
+			t.Fatalf("failure %d got Retry-After %q, want none", i+1, retryAfter)
+		}
+	}
+
+	// Past the free attempts, the delay doubles with every failure
+	for _, want := range []string{"1", "2", "4", "8"} {
+		if retryAfter := failLogin(t, api, "user@example.com"); retryAfter != want {
+			t.Fatalf("got Retry-After %q, want %q", retryAfter, want)
+		}
+
+		expectLogin(t, api, "user@example.com", http.StatusTooManyRequests, want)
+		api.clock.Advance(retryAfterDuration(t, want))
+	}
+
+	// A successful login forgets the failures
+	expectLogin(t, api, "user@example.com", http.StatusOK, "")
+	if retryAfter := failLogin(t, api, "user@example.com"); retryAfter != "" {
+		t.Errorf("got Retry-After %q after a successful login, want none", retryAfter)
+	}
+}
+
+func retryAfterDuration(t *testing.T, retryAfter string) time.Duration {
+	t.Helper()
+
+	var seconds int
+	_, err := fmt.Sscan(retryAfter, &seconds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// lockOut fails logins until the account is locked, waiting out every delay before it
+func lockOut(t *testing.T, api *testAPI, email string) {
+	t.Helper()
+
+	for i := 0; i < 9; i++ {
+		if retryAfter := failLogin(t, api, email); retryAfter != "" {
+			api.clock.Advance(retryAfterDuration(t, retryAfter))
+		}
+	}
+
+	if retryAfter := failLogin(t, api, email); retryAfter != "900" {
+		t.Fatalf("got Retry-After %q at the lockout, want 900", retryAfter)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	api := newTestAPI(t)
+	api.addUser("user@example.com", "")
+
+	lockOut(t, api, "user@example.com")
+
+	api.clock.Advance(14 * time.Minute)
+	expectLogin(t, api, "user@example.com", http.StatusTooManyRequests, "60")
+
+	api.clock.Advance(time.Minute)
+	expectLogin(t, api, "user@example.com", http.StatusOK, "")
+}
+
+func TestUnlockAccount(t *testing.T) {
+	api := newTestAPI(t)
+	api.addUser("user@example.com", "")
+	_, adminToken := api.addUser("admin@example.com", models.RoleAdmin)
+
+	lockOut(t, api, "user@example.com")
+
+	response := api.request("POST", "/users/unlock?email=user@example.com", adminToken, "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body.String())
+	}
+
+	expectLogin(t, api, "user@example.com", http.StatusOK, "")
+}
+
+// failLoginsFrom fails logins of unknown accounts, each from a different X-Forwarded-For, and tells whether one of
+// them was throttled
+func failLoginsFrom(api *testAPI, attempts int) bool {
+	for i := 0; i < attempts; i++ {
+		response := api.request("POST", "/users/login", "", loginBody(fmt.Sprintf("nobody%d@example.com", i), "wrong password"),
+			"X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		if response.Code == http.StatusTooManyRequests {
+			return true
+		}
+	}
+	return false
+}
+
+func TestForwardedForIsIgnoredByDefault(t *testing.T) {
+	api := newTestAPI(t)
+
+	if !failLoginsFrom(api, 25) {
+		t.Error("no login was throttled, the client IP was read from X-Forwarded-For")
+	}
+}
+
+func TestForwardedForOfTrustedProxies(t *testing.T) {
+	// Test requests come from 192.0.2.1
+	api := newTestAPIWith(t, Config{TrustedProxies: []string{"192.0.2.0/24"}})
+
+	if failLoginsFrom(api, 25) {
+		t.Error("a login was throttled, the client IP was not read from X-Forwarded-For")
+	}
+}
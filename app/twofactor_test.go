@@ -0,0 +1,100 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// totpCode is the code of the authenticator app of secret at the time of the fake clock
+func (a *testAPI) totpCode(secret string) string {
+	a.t.Helper()
+
+	code, err := functions.TOTPCode(secret, functions.TOTPStep(a.clock.Now()))
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return code
+}
+
+// enrollTwoFactor turns on two factor authentication for the user of token, and returns the enrollment
+func (a *testAPI) enrollTwoFactor(token string) models.TwoFactorEnrollment {
+	a.t.Helper()
+
+	var enrollment models.TwoFactorEnrollment
+	decode(a.t, a.request("POST", "/users/2fa/enroll", token, ""), &enrollment)
+
+	response := a.request("POST", "/users/2fa/confirm", token, `{"code":"`+a.totpCode(enrollment.Secret)+`"}`)
+	if response.Code != http.StatusOK {
+		a.t.Fatalf("confirming two factor authentication got %d: %s", response.Code, response.Body.String())
+	}
+	return enrollment
+}
+
+// secondFactorLogin logs in with the test password, then answers the challenge with a code in field, which is code or
+// recovery_code. It returns the status of the answer.
+func (a *testAPI) secondFactorLogin(email string, field string, code string) int {
+	a.t.Helper()
+
+	var challenge models.TwoFactorChallenge
+	decode(a.t, a.request("POST", "/users/login", "", loginBody(email, testPassword)), &challenge)
+	if !challenge.Two_factor_required {
+		a.t.Fatal("the login did not ask for a second factor")
+	}
+
+	body := `{"challenge_token":"` + challenge.Challenge_token + `","` + field + `":"` + code + `"}`
+	return a.request("POST", "/users/login/2fa", "", body).Code
+}
+
+func TestTOTPCodesWorkOnce(t *testing.T) {
+	api := newTestAPI(t)
+	_, token := api.addUser("user@example.com", "")
+	enrollment := api.enrollTwoFactor(token)
+
+	// The code confirming the enrollment is used up
+	confirmed := api.totpCode(enrollment.Secret)
+	if status := api.secondFactorLogin("user@example.com", "code", confirmed); status != http.StatusUnauthorized {
+		t.Errorf("the code confirming the enrollment got %d, want 401", status)
+	}
+
+	api.clock.Advance(30 * time.Second)
+	code := api.totpCode(enrollment.Secret)
+	if status := api.secondFactorLogin("user@example.com", "code", code); status != http.StatusOK {
+		t.Fatalf("the code of the next step got %d, want 200", status)
+	}
+	if status := api.secondFactorLogin("user@example.com", "code", code); status != http.StatusUnauthorized {
+		t.Errorf("replaying a code got %d, want 401", status)
+	}
+
+	// Codes of earlier steps are accepted for clock skew, but not once a later one was used
+	if status := api.secondFactorLogin("user@example.com", "code", confirmed); status != http.StatusUnauthorized {
+		t.Errorf("the code of an earlier step got %d after a later one was used, want 401", status)
+	}
+}
+
+func TestRecoveryCodesWorkOnce(t *testing.T) {
+	api := newTestAPI(t)
+	_, token := api.addUser("user@example.com", "")
+	enrollment := api.enrollTwoFactor(token)
+	recoveryCode := enrollment.Recovery_codes[0]
+
+	if status := api.secondFactorLogin("user@example.com", "recovery_code", recoveryCode); status != http.StatusOK {
+		t.Fatalf("a recovery code got %d, want 200", status)
+	}
+	if status := api.secondFactorLogin("user@example.com", "recovery_code", recoveryCode); status != http.StatusUnauthorized {
+		t.Errorf("a used recovery code got %d, want 401", status)
+	}
+	if status := api.secondFactorLogin("user@example.com", "recovery_code", enrollment.Recovery_codes[1]); status != http.StatusOK {
+		t.Errorf("another recovery code got %d, want 200", status)
+	}
+
+	// A recovery code also turns two factor authentication off, once
+	recoveryCode = enrollment.Recovery_codes[2]
+	if response := api.request("POST", "/users/2fa/disable", token, `{"recovery_code":"`+recoveryCode+`"}`); response.Code != http.StatusOK {
+		t.Fatalf("disabling with a recovery code got %d: %s", response.Code, response.Body.String())
+	}
+	api.login("user@example.com")
+}
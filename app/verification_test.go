@@ -0,0 +1,67 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+var linkInEmail = regexp.MustCompile(`https?://\S+`)
+
+// emailedToken reads the token of the link in the last email, which must lead to page
+func emailedToken(t *testing.T, api *testAPI, page string) string {
+	t.Helper()
+
+	link, err := url.Parse(linkInEmail.FindString(api.mails.last().Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Scheme+"://"+link.Host+link.Path != page {
+		t.Fatalf("the email links to %s, want %s", link, page)
+	}
+	return link.Query().Get("token")
+}
+
+func TestVerificationLinkIgnoresRequestHeaders(t *testing.T) {
+	api := newTestAPI(t)
+	_, token := api.addUserWith("user@example.com", "", func(user *models.User) {
+		verified := false
+		user.Email_verified = &verified
+	})
+
+	// Whoever sends the request chooses its Host, which must not choose where the emailed token goes
+	response := api.request("POST", "/users/verify/resend", token, "", "Host", "attacker.example", "X-Forwarded-Proto", "http", "X-Forwarded-Host", "attacker.example")
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body.String())
+	}
+
+	verificationToken := emailedToken(t, api, testLinks.Verification)
+	response = api.request("GET", "/users/verify?token="+url.QueryEscape(verificationToken), "", "")
+	if response.Code != http.StatusOK {
+		t.Errorf("following the link got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestPasswordResetLink(t *testing.T) {
+	api := newTestAPI(t)
+	api.addUser("user@example.com", "")
+
+	response := api.request("POST", "/users/password/forgot", "", `{"email":"user@example.com"}`, "Host", "attacker.example")
+	if response.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", response.Code, response.Body.String())
+	}
+
+	resetToken := emailedToken(t, api, testLinks.PasswordReset)
+	response = api.request("POST", "/users/password/reset", "", `{"token":"`+resetToken+`","password":"secret2"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("resetting the password got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = api.request("POST", "/users/login", "", loginBody("user@example.com", "secret2"))
+	if response.Code != http.StatusOK {
+		t.Errorf("logging in with the new password got %d: %s", response.Code, response.Body.String())
+	}
+}
@@ -0,0 +1,54 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time. Code that depends on elapsed time takes a Clock so that tests can control time.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the wall clock
+type System struct{}
+
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+// Fake is a clock that only moves when told to
+type Fake struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now
+	f.now = f.now.Add(f.step)
+	return now
+}
+
+// Advance moves the fake clock forward by d
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+// Step makes every later reading of the fake clock move it forward by d, as the wall clock does between two readings
+func (f *Fake) Step(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.step = d
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type apiKeyType = models.ApiKey
+type createApiKeyRequest = models.CreateApiKeyModel
+type createdApiKey = models.CreatedApiKey
+
+// CreateApiKey godoc
+// @Summary Create an API key
+// @Description Creates a named API key limited to the given scopes (tasks:read, tasks:write, stats:read, users:read, users:write). The key is only shown in this response. Send it like an access token.
+// @Tags authentication
+// @Param data body createApiKeyRequest true "Name and scopes of the key"
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} createdApiKey
+// @Failure 400 {object} errorResult
+// @Router /users/apikeys [post]
+func (h *Handlers) CreateApiKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var request models.CreateApiKeyModel
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		for _, scope := range request.Scopes {
+			if !models.IsValidScope(scope) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope})
+				return
+			}
+		}
+
+		key, prefix, err := helper.GenerateApiKey()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		apiKey := models.ApiKey{
+			ID:         primitive.NewObjectID(),
+			User_id:    c.GetString("uid"),
+			Name:       *request.Name,
+			Scopes:     request.Scopes,
+			Prefix:     prefix,
+			Key_hash:   helper.HashOpaqueToken(key),
+			Created_at: h.clock.Now(),
+		}
+		apiKey.Key_id = apiKey.ID.Hex()
+
+		err = h.apiKeys.Create(ctx, apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.CreatedApiKey{ApiKey: apiKey, Key: key})
+	}
+}
+
+// GetApiKeys godoc
+// @Summary List API keys
+// @Description Lists the API keys of the logged in user, with when each was last used. Keys themselves are not returned.
+// @Tags authentication
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {array} apiKeyType
+// @Failure 500 {object} errorResult
+// @Router /users/apikeys [get]
+func (h *Handlers) GetApiKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		apiKeys, err := h.apiKeys.FindByUser(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, apiKeys)
+	}
+}
+
+// RevokeApiKey godoc
+// @Summary Revoke an API key
+// @Description Deletes an API key of the logged in user. Requests using it are refused from then on.
+// @Tags authentication
+// @Param keyId path string true "Key id"
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 404 {object} errorResult
+// @Router /users/apikeys/{keyId} [delete]
+func (h *Handlers) RevokeApiKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err := h.apiKeys.Delete(ctx, c.GetString("uid"), c.Param("keyId"))
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, "API Key Revoked")
+	}
+}
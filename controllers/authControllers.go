@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/repository"
+)
+
+type refreshRequest = models.RefreshModel
+type tokenResult = models.TokenResult
+
+// RefreshToken godoc
+// @Summary Exchange a refresh token
+// @Description Responds with a new token and refresh token for the same session. The refresh token is rotated, and replaying an already used refresh token ends its session.
+// @Tags authentication
+// @Param data body refreshRequest true "Refresh token"
+// @Produce json
+// @Success 200 {object} tokenResult
+// @Failure 401 {object} errorResult
+// @Router /users/refresh [post]
+func (h *Handlers) RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var request models.RefreshModel
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		presented := *request.Refresh_token
+		claims, msg := h.tokens.ValidateToken(presented)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		if claims.Token_type != helper.RefreshToken || claims.Family == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not a refresh token"})
+			return
+		}
+
+		revoked, err := h.tokens.IsRevoked(claims)
+		if err != nil {
+			log.Default().Println(err, "Unable to check token revocation")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unable to check whether the token was revoked"})
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has been revoked"})
+			return
+		}
+
+		session, err := h.sessions.FindActiveById(ctx, claims.Family)
+		if err != nil || session.User_id != claims.Uid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the session has ended, log in again"})
+			return
+		}
+
+		presentedHash := helper.HashOpaqueToken(presented)
+		if session.Refresh_token_hash != presentedHash {
+			// A validly signed refresh token of an active session that is no longer its current one
+			// has already been rotated, so someone is replaying it
+			h.revokeReusedSession(ctx, *session)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has already been used"})
+			return
+		}
+
+		foundUser, err := h.users.FindUserById(ctx, claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		token, refreshToken, err := h.tokens.GenerateFamilyTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, foundUser.User_id, foundUser.Role(), session.Session_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := h.clock.Now()
+		rotated, err := h.sessions.Rotate(ctx, session.Session_id, presentedHash, models.Session{
+			Refresh_token_hash: helper.HashOpaqueToken(refreshToken),
+			Ip:                 c.ClientIP(),
+			User_agent:         c.Request.UserAgent(),
+			Last_seen_at:       now,
+			Expires_at:         now.Add(helper.RefreshTokenLifetime),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !rotated { // Lost the race against another exchange of the same refresh token
+			h.revokeReusedSession(ctx, *session)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has already been used"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.TokenResult{Token: token, Refresh_token: refreshToken})
+	}
+}
+
+func (h *Handlers) revokeReusedSession(ctx context.Context, session models.Session) {
+	log.Default().Println("Refresh token reuse detected for", session.User_id, "revoking session", session.Session_id)
+
+	err := h.tokens.RevokeSession(ctx, session.User_id, session.Session_id)
+	if err != nil {
+		log.Default().Println(err, "Unable to revoke session")
+	}
+}
+
+// Logout godoc
+// @Summary Log out
+// @Description Revokes the token used for this request and ends its session.
+// @Tags authentication
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 500 {object} errorResult
+// @Router /users/logout [post]
+func (h *Handlers) Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+
+		err := h.tokens.RevokeToken(c.GetString("token_id"), c.GetInt64("token_expires_at"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if sessionId := c.GetString("session_id"); sessionId != "" {
+			err = h.tokens.RevokeSession(c.Request.Context(), uid, sessionId)
+			if err != nil && err != repository.ErrNotFound {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, "Logout Success")
+	}
+}
+
+// LogoutEverywhere godoc
+// @Summary Log out everywhere
+// @Description Revokes every token issued to the user so far and ends every session, on every device.
+// @Tags authentication
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 500 {object} errorResult
+// @Router /users/logout/all [post]
+func (h *Handlers) LogoutEverywhere() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+
+		err := h.tokens.RevokeAllUserTokens(uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, "Logout Success")
+	}
+}
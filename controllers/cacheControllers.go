@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/rediscache"
+	"github.com/hauchongtang/splatbackend/repository"
+)
+
+type cacheKeyInfo = models.CacheKeyInfo
+type cacheFamilyResult = models.CacheFamilyResult
+
+// Families of the keys of the cached endpoints. The key of a user is userFamily, a colon and the user id, and the key
+// of the tasks of a user is userTasksFamily followed by the user id. The other families have a single key, named
+// after them.
+const (
+	usersFamily      = "alluserscache"
+	userFamily       = "user"
+	tasksFamily      = "alltaskscache"
+	userTasksFamily  = "taskOf"
+	popularityFamily = "mostpopularmodulescache"
+)
+
+var cacheFamilies = []string{usersFamily, userFamily, tasksFamily, userTasksFamily, popularityFamily}
+
+var errUnknownFamily = errors.New("unknown cache family")
+
+// UserCacheKey is the key of a user in the cache, before the prefix of the tenant
+func UserCacheKey(userId string) string {
+	return userFamily + ":" + userId
+}
+
+func (h *Handlers) cachedUsers(ctx context.Context) ([]models.PublicUser, error) {
+	return rediscache.GetOrLoad(ctx, h.loader, h.cacheKey(usersFamily), usersCache, func(ctx context.Context) ([]models.PublicUser, error) {
+		results, err := h.users.FindUsers(ctx, h.unverified.HideFromLeaderboard)
+		if err != nil {
+			return nil, err
+		}
+		return models.PublicViews(*results), nil
+	})
+}
+
+func (h *Handlers) cachedUser(ctx context.Context, userId string) (models.AdminUser, error) {
+	return rediscache.GetOrLoad(ctx, h.loader, h.cacheKey(UserCacheKey(userId)), userCache, func(ctx context.Context) (models.AdminUser, error) {
+		result, err := h.users.FindUserById(ctx, userId)
+		if err != nil {
+			return models.AdminUser{}, err
+		}
+		return result.AdminView(), nil
+	})
+}
+
+func (h *Handlers) cachedTasks(ctx context.Context) ([]models.Task, error) {
+	return rediscache.GetOrLoad(ctx, h.loader, h.cacheKey(tasksFamily), tasksCache, h.tasks.FindTasks)
+}
+
+func (h *Handlers) cachedUserTasks(ctx context.Context, userId string) ([]models.Task, error) {
+	return rediscache.GetOrLoad(ctx, h.loader, h.cacheKey(userTasksFamily+userId), userTasksCache, func(ctx context.Context) ([]models.Task, error) {
+		return h.tasks.FindTasksByUser(ctx, userId)
+	})
+}
+
+func (h *Handlers) cachedPopularity(ctx context.Context) ([]models.ModulePopularity, error) {
+	return rediscache.GetOrLoad(ctx, h.loader, h.cacheKey(popularityFamily), popularityCache, func(ctx context.Context) ([]models.ModulePopularity, error) {
+		results, err := h.tasks.ModulePopularity(ctx)
+		if err != nil {
+			return nil, err
+		}
+		models.SortModulePopularity(results)
+		return results, nil
+	})
+}
+
+// userIds lists the id of every user, for the families with a key per user
+func (h *Handlers) userIds(ctx context.Context) ([]string, error) {
+	users, err := h.users.FindUsers(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(*users))
+	for i, user := range *users {
+		ids[i] = user.User_id
+	}
+	return ids, nil
+}
+
+// familyKeys lists the keys of a family, without the cache prefix
+func (h *Handlers) familyKeys(ctx context.Context, family string) ([]string, error) {
+	switch family {
+	case usersFamily, tasksFamily, popularityFamily:
+		return []string{family}, nil
+	case userFamily, userTasksFamily:
+		ids, err := h.userIds(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := range ids {
+			if family == userFamily {
+				ids[i] = UserCacheKey(ids[i])
+			} else {
+				ids[i] = userTasksFamily + ids[i]
+			}
+		}
+		return ids, nil
+	}
+	return nil, errUnknownFamily
+}
+
+// warm caches every key of a family that is not cached, and returns how many keys the family has
+func (h *Handlers) warm(ctx context.Context, family string) (int, error) {
+	switch family {
+	case usersFamily:
+		_, err := h.cachedUsers(ctx)
+		return 1, err
+	case tasksFamily:
+		_, err := h.cachedTasks(ctx)
+		return 1, err
+	case popularityFamily:
+		_, err := h.cachedPopularity(ctx)
+		return 1, err
+	case userFamily, userTasksFamily:
+		ids, err := h.userIds(ctx)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range ids {
+			if family == userFamily {
+				_, err = h.cachedUser(ctx, id)
+			} else {
+				_, err = h.cachedUserTasks(ctx, id)
+			}
+			// A user deleted meanwhile, or without tasks, has nothing to cache
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				return 0, err
+			}
+		}
+		return len(ids), nil
+	}
+	return 0, errUnknownFamily
+}
+
+// GetCacheMetrics gdoc
+// @Summary Get cache metrics
+// @Description Gets the hits, misses, errors and latency of the cache by family of keys, in the Prometheus text format. Only admin access.
+// @Tags cache
+// @Produce plain
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 403 {object} errorResult
+// @Router /metrics [get]
+func (h *Handlers) GetCacheMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+
+		err := h.cacheMetrics.WritePrometheus(c.Writer)
+		if err != nil {
+			log.Default().Println(err, "Unable to write metrics")
+		}
+	}
+}
+
+// InspectCacheKey gdoc
+// @Summary Inspect a cache key
+// @Description Gets how long a key is still cached and the size of its value. Only admin access.
+// @Tags cache
+// @Produce json
+// @Param key path string true "Cache key, such as alluserscache, user:<userId> or taskOf<userId>"
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} cacheKeyInfo
+// @Failure 403 {object} errorResult
+// @Failure 404 {object} errorResult
+// @Failure 501 {object} errorResult
+// @Router /admin/cache/keys/{key} [get]
+func (h *Handlers) InspectCacheKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		key := c.Param("key")
+
+		info, err := rediscache.Inspect(ctx, h.cache, h.cacheKey(key))
+
+		switch {
+		case err == rediscache.ErrCacheMiss:
+			c.JSON(http.StatusNotFound, gin.H{"error": "The key is not cached!"})
+			return
+		case err == rediscache.ErrInspectUnsupported:
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "The cache cannot inspect its keys!"})
+			return
+		case err != nil:
+			log.Default().Println(err, "Unable to inspect", key)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to reach the cache!"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.CacheKeyInfo{
+			Key:         key,
+			Family:      h.cacheFamily(info.Key),
+			Ttl_seconds: info.TTL.Seconds(),
+			Size_bytes:  info.Size,
+		})
+	}
+}
+
+// PurgeCacheFamily gdoc
+// @Summary Purge a family of cache keys
+// @Description Evicts every key of a family from the cache of every instance. Families are alluserscache, user, alltaskscache, taskOf and mostpopularmodulescache. Only admin access.
+// @Tags cache
+// @Produce json
+// @Param family path string true "Family of cache keys"
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} cacheFamilyResult
+// @Failure 403 {object} errorResult
+// @Failure 404 {object} errorResult
+// @Failure 500 {object} errorResult
+// @Router /admin/cache/families/{family} [delete]
+func (h *Handlers) PurgeCacheFamily() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		family := c.Param("family")
+
+		keys, err := h.familyKeys(ctx, family)
+		if err == errUnknownFamily {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown cache family!"})
+			return
+		}
+
+		if err == nil {
+			for i := range keys {
+				keys[i] = h.cacheKey(keys[i])
+			}
+			err = h.invalidations.Invalidate(ctx, keys...)
+		}
+
+		if err != nil {
+			log.Default().Println(err, "Unable to purge", family)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to purge the cache!"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.CacheFamilyResult{Family: family, Keys: len(keys)})
+	}
+}
+
+// WarmCacheFamily gdoc
+// @Summary Warm a family of cache keys
+// @Description Caches every key of a family that is not cached yet. Families are alluserscache, user, alltaskscache, taskOf and mostpopularmodulescache. Only admin access.
+// @Tags cache
+// @Produce json
+// @Param family path string true "Family of cache keys"
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} cacheFamilyResult
+// @Failure 403 {object} errorResult
+// @Failure 404 {object} errorResult
+// @Failure 500 {object} errorResult
+// @Router /admin/cache/families/{family}/warm [post]
+func (h *Handlers) WarmCacheFamily() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		family := c.Param("family")
+
+		keys, err := h.warm(ctx, family)
+
+		if err == errUnknownFamily {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown cache family!"})
+			return
+		}
+		if err != nil {
+			log.Default().Println(err, "Unable to warm", family)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to warm the cache!"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.CacheFamilyResult{Family: family, Keys: keys})
+	}
+}
+
+// WarmCaches gdoc
+// @Summary Warm every cache
+// @Description Caches every key of every family that is not cached yet. Only admin access.
+// @Tags cache
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} []cacheFamilyResult
+// @Failure 403 {object} errorResult
+// @Failure 500 {object} errorResult
+// @Router /admin/cache/warm [post]
+func (h *Handlers) WarmCaches() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		results := []models.CacheFamilyResult{}
+
+		for _, family := range cacheFamilies {
+			keys, err := h.warm(ctx, family)
+			if err != nil {
+				log.Default().Println(err, "Unable to warm", family)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to warm the cache!"})
+				return
+			}
+			results = append(results, models.CacheFamilyResult{Family: family, Keys: keys})
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// etag is a strong entity tag of a response body
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified tells whether the client already has the response, going by If-None-Match when it is sent and by
+// If-Modified-Since otherwise
+func notModified(r *http.Request, tag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == tag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		at, err := http.ParseTime(since)
+		return err == nil && !lastModified.Truncate(time.Second).After(at)
+	}
+
+	return false
+}
+
+// varyByCaller names the headers a caller is authenticated by. Users are shown differently to the public, to
+// themselves and to admins, so caches must not hand the response of one caller to another.
+const varyByCaller = "Authorization, token"
+
+// respondJSON sends body as JSON with an ETag, and a Last-Modified header when lastModified is not zero.
+// A client that already has this response gets a 304 without a body instead.
+func respondJSON(c *gin.Context, body interface{}, lastModified time.Time) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Default().Println(err, "Unable to encode response")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to encode response!"})
+		return
+	}
+
+	tag := etag(data)
+	c.Header("ETag", tag)
+	c.Header("Vary", varyByCaller)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(c.Request, tag, lastModified) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// lastTaskUpdate is when the most recently updated of tasks was updated
+func lastTaskUpdate(tasks []models.Task) time.Time {
+	last := time.Time{}
+	for _, task := range tasks {
+		if task.Updated_at.After(last) {
+			last = task.Updated_at
+		}
+	}
+	return last
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	helper "github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/mailer"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/oidc"
+	"github.com/hauchongtang/splatbackend/rediscache"
+	"github.com/hauchongtang/splatbackend/repository"
+)
+
+// Dependencies are the stores and services the handlers work with
+type Dependencies struct {
+	Users         repository.UserStore
+	Tasks         repository.TaskStore
+	Sessions      repository.SessionStore
+	ApiKeys       repository.ApiKeyStore
+	OneTimeTokens repository.OneTimeTokenStore
+	Cache         rediscache.Cache
+	// CachePrefix is put before every cache key, so that tenants sharing a cache do not see each other's entries
+	CachePrefix   string
+	CacheMetrics  *rediscache.CacheMetrics
+	Invalidations rediscache.Invalidations
+	Generations   *rediscache.Generations
+	Once          rediscache.OnceStore
+	Tokens        *helper.TokenIssuer
+	LoginThrottle *helper.LoginThrottle
+	Mailer        mailer.Mailer
+	Providers     map[string]*oidc.Provider
+	Unverified    UnverifiedPolicy
+	Links         EmailLinks
+	Clock         clock.Clock
+}
+
+// Handlers serve every endpoint of the API
+type Handlers struct {
+	users         repository.UserStore
+	tasks         repository.TaskStore
+	sessions      repository.SessionStore
+	apiKeys       repository.ApiKeyStore
+	oneTimeTokens repository.OneTimeTokenStore
+	cache         rediscache.Cache
+	loader        *rediscache.Loader
+	cachePrefix   string
+	cacheMetrics  *rediscache.CacheMetrics
+	invalidations rediscache.Invalidations
+	once          rediscache.OnceStore
+	tokens        *helper.TokenIssuer
+	loginThrottle *helper.LoginThrottle
+	mailer        mailer.Mailer
+	providers     map[string]*oidc.Provider
+	unverified    UnverifiedPolicy
+	links         EmailLinks
+	clock         clock.Clock
+}
+
+func NewHandlers(deps Dependencies) *Handlers {
+	h := &Handlers{
+		users:         deps.Users,
+		tasks:         deps.Tasks,
+		sessions:      deps.Sessions,
+		apiKeys:       deps.ApiKeys,
+		oneTimeTokens: deps.OneTimeTokens,
+		cache:         deps.Cache,
+		cachePrefix:   deps.CachePrefix,
+		cacheMetrics:  deps.CacheMetrics,
+		invalidations: deps.Invalidations,
+		once:          deps.Once,
+		tokens:        deps.Tokens,
+		loginThrottle: deps.LoginThrottle,
+		mailer:        deps.Mailer,
+		providers:     deps.Providers,
+		unverified:    deps.Unverified,
+		links:         deps.Links,
+		clock:         deps.Clock,
+	}
+
+	h.cache = rediscache.NewMeteredCache(deps.Cache, deps.CacheMetrics, h.cacheFamily)
+	h.loader = rediscache.NewLoader(h.cache, deps.Generations, deps.Clock)
+	return h
+}
+
+// cacheKey is the key of an entry in the cache of these handlers
+func (h *Handlers) cacheKey(key string) string {
+	return h.cachePrefix + key
+}
+
+// cacheFamily is the family of a key of the cache of these handlers
+func (h *Handlers) cacheFamily(key string) string {
+	key = strings.TrimPrefix(key, h.cachePrefix)
+	switch {
+	case key == usersFamily || key == tasksFamily || key == popularityFamily:
+		return key
+	case strings.HasPrefix(key, userTasksFamily):
+		return userTasksFamily
+	}
+	return userFamily
+}
+
+// How long the cached endpoints keep what they read
+var (
+	usersCache      = rediscache.LoadOptions{TTL: time.Hour, Stale: 5 * time.Minute}
+	userCache       = rediscache.LoadOptions{TTL: 72 * time.Hour, Stale: time.Hour, Missing: time.Minute}
+	tasksCache      = rediscache.LoadOptions{TTL: time.Hour, Stale: 5 * time.Minute}
+	userTasksCache  = rediscache.LoadOptions{TTL: 15 * time.Minute, Stale: time.Minute}
+	popularityCache = rediscache.LoadOptions{TTL: 72 * time.Hour, Stale: time.Hour}
+)
+
+// staleKeys lists the cache keys that an event makes stale
+func staleKeys(event models.Event) []string {
+	switch event.Kind {
+	case models.UserCreated, models.UserUpdated, models.UserDeleted:
+		return []string{UserCacheKey(event.User_id), usersFamily}
+	case models.TaskCreated, models.TaskUpdated:
+		return []string{userTasksFamily + event.User_id, tasksFamily, popularityFamily}
+	}
+	return nil
+}
+
+// emit tells that a user or a task changed, so that every instance evicts what it cached of them
+func (h *Handlers) emit(ctx context.Context, event models.Event) {
+	keys := staleKeys(event)
+	for i := range keys {
+		keys[i] = h.cacheKey(keys[i])
+	}
+
+	err := h.invalidations.Invalidate(ctx, keys...)
+	if err != nil { // Not fatal, the keys expire in the end
+		log.Default().Println(err, "Unable to invalidate", keys)
+	}
+}
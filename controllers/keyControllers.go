@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+type jsonWebKeySet = models.JSONWebKeySet
+
+// GetJWKS godoc
+// @Summary Get the token verification keys
+// @Description Responds with the public keys that verify tokens issued by this API, as a JSON Web Key Set.
+// @Tags authentication
+// @Produce json
+// @Success 200 {object} jsonWebKeySet
+// @Router /.well-known/jwks.json [get]
+func (h *Handlers) GetJWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, h.tokens.JWKS())
+	}
+}
@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/oidc"
+	"github.com/hauchongtang/splatbackend/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// oidcLoginLifetime is how long a user has to sign in at the provider
+const oidcLoginLifetime = time.Minute * 10
+
+var errUnverifiedProviderEmail = errors.New("the provider has not verified the email of this account")
+
+// oidcStateCookie holds the state of a sign in in the browser that started it, so that a callback carrying the
+// code of someone else is refused
+const oidcStateCookie = "oidc_state"
+
+func oidcStateKey(state string) string {
+	return "oidc:state:" + helper.HashOpaqueToken(state)
+}
+
+// setOIDCStateCookie keeps the state for the callback of the provider, which is the only path the cookie is sent to.
+// A negative maxAge deletes it.
+func setOIDCStateCookie(c *gin.Context, provider string, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode) // The provider sends the user back with a top level GET from another site
+	c.SetCookie(oidcStateCookie, state, maxAge, "/users/oidc/"+provider+"/callback", "", true, true)
+}
+
+// findOrLinkOIDCUser returns the user linked to a provider identity. An identity seen for the first time is linked
+// to the user with the same email when the provider verified that email, and a new user is created otherwise.
+func (h *Handlers) findOrLinkOIDCUser(ctx context.Context, provider string, claims *oidc.IDToken) (*models.User, error) {
+	foundUser, err := h.users.FindUserByIdentity(ctx, provider, claims.Subject)
+	if err == nil {
+		return foundUser, nil
+	}
+	if err != repository.ErrNotFound {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedProviderEmail
+	}
+
+	identity := models.Identity{Provider: provider, Subject: claims.Subject, Email: claims.Email, Linked_at: h.clock.Now()}
+
+	foundUser, err = h.users.FindUserByEmail(ctx, helper.NormalizeEmail(claims.Email))
+	if err == nil {
+		return h.linkIdentity(ctx, *foundUser, identity)
+	}
+	if err != repository.ErrNotFound {
+		return nil, err
+	}
+
+	return h.createOIDCUser(ctx, claims, identity)
+}
+
+func (h *Handlers) linkIdentity(ctx context.Context, foundUser models.User, identity models.Identity) (*models.User, error) {
+	// Whoever signed up with an email they could not verify must not keep access to the account of its owner
+	dropPassword := !foundUser.IsEmailVerified()
+	if dropPassword {
+		err := h.tokens.RevokeAllUserTokens(foundUser.User_id)
+		if err != nil {
+			return nil, err
+		}
+
+		err = h.apiKeys.DeleteByUser(ctx, foundUser.User_id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := h.users.LinkIdentity(ctx, foundUser.User_id, identity, dropPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	h.emit(ctx, models.Event{Kind: models.UserUpdated, User_id: foundUser.User_id})
+
+	return h.users.FindUserById(ctx, foundUser.User_id)
+}
+
+func (h *Handlers) createOIDCUser(ctx context.Context, claims *oidc.IDToken, identity models.Identity) (*models.User, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && claims.Name != "" {
+		firstName, lastName, _ = strings.Cut(claims.Name, " ")
+	}
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	email := helper.NormalizeEmail(claims.Email)
+	emailVerified := true
+	now, _ := time.Parse(time.RFC3339, h.clock.Now().Format(time.RFC3339))
+	user := models.User{
+		ID:             primitive.NewObjectID(),
+		First_name:     &firstName,
+		Last_name:      &lastName,
+		Email:          &email,
+		Email_verified: &emailVerified,
+		Created_at:     now,
+		Updated_at:     now,
+		User_type:      models.RoleUser,
+		Identities:     []models.Identity{identity},
+	}
+	user.User_id = user.ID.Hex()
+
+	err := h.users.InsertUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	h.emit(ctx, models.Event{Kind: models.UserCreated, User_id: user.User_id})
+
+	return &user, nil
+}
+
+// OIDCLogin godoc
+// @Summary Sign in with an OpenID Connect provider
+// @Description Redirects to the sign in page of the provider, using the authorization code flow with PKCE. The provider sends the user back to the callback, which only completes in the browser that got the oidc_state cookie set here.
+// @Tags authentication
+// @Param provider path string true "Provider name"
+// @Success 302
+// @Failure 404 {object} errorResult
+// @Router /users/oidc/{provider}/login [get]
+func (h *Handlers) OIDCLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		provider, found := h.providers[c.Param("provider")]
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+			return
+		}
+
+		state, err := oidc.RandomString()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		nonce, err := oidc.RandomString()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		codeVerifier, err := oidc.RandomString()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		authorizationURL, err := provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+		if err != nil {
+			log.Default().Println(err, "Unable to reach OIDC provider", provider.Name)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "unable to reach the provider"})
+			return
+		}
+
+		loginState := models.OIDCLoginState{Provider: provider.Name, Code_verifier: codeVerifier, Nonce: nonce}
+		err = h.once.PutOnce(ctx, oidcStateKey(state), loginState, oidcLoginLifetime)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		setOIDCStateCookie(c, provider.Name, state, int(oidcLoginLifetime.Seconds()))
+		c.Redirect(http.StatusFound, authorizationURL)
+	}
+}
+
+// OIDCCallback godoc
+// @Summary Complete a sign in with an OpenID Connect provider
+// @Description Responds with user details, including OAuth2 tokens. The provider account is linked to the user with the same email when the provider verified it, otherwise a new user is created. Users with two factor authentication get a challenge token to complete at /users/login/2fa instead.
+// @Tags authentication
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State sent to the provider"
+// @Produce json
+// @Success 200 {object} loginResult
+// @Failure 400 {object} errorResult
+// @Failure 401 {object} errorResult
+// @Failure 403 {object} errorResult
+// @Router /users/oidc/{provider}/callback [get]
+func (h *Handlers) OIDCCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var loginState models.OIDCLoginState
+
+		provider, found := h.providers[c.Param("provider")]
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+			return
+		}
+
+		if providerErr := c.Query("error"); providerErr != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "sign in refused by the provider: " + providerErr})
+			return
+		}
+
+		state := c.Query("state")
+		cookieState, err := c.Cookie(oidcStateCookie)
+		if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookieState), []byte(state)) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the sign in was not started from this browser"})
+			return
+		}
+		setOIDCStateCookie(c, provider.Name, "", -1)
+
+		err = h.once.TakeOnce(ctx, oidcStateKey(state), &loginState)
+		if err != nil || loginState.Provider != provider.Name {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the sign in has expired, start again"})
+			return
+		}
+
+		claims, err := provider.Exchange(ctx, c.Query("code"), loginState.Code_verifier, loginState.Nonce)
+		if err != nil {
+			log.Default().Println(err, "Unable to complete sign in with", provider.Name)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unable to sign in with the provider"})
+			return
+		}
+
+		foundUser, err := h.findOrLinkOIDCUser(ctx, provider.Name, claims)
+		if err == errUnverifiedProviderEmail {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if foundUser.Two_factor_enabled { // The provider replaces the password, not the second factor
+			challengeToken, err := h.tokens.GenerateChallengeToken(foundUser.User_id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, models.TwoFactorChallenge{Two_factor_required: true, Challenge_token: challengeToken})
+			return
+		}
+
+		h.completeLogin(ctx, c, *foundUser, deviceLabel(c, nil))
+	}
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/mailer"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/repository"
+)
+
+type forgotPasswordRequest = models.ForgotPasswordModel
+type resetPasswordRequest = models.ResetPasswordModel
+
+const passwordResetLifetime = time.Hour * 1
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Emails a single use password reset link to the user. Responds the same way whether or not the email exists.
+// @Tags authentication
+// @Param data body forgotPasswordRequest true "Account email"
+// @Produce json
+// @Success 200 {string} string
+// @Failure 400 {object} errorResult
+// @Router /users/password/forgot [post]
+func (h *Handlers) ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var request models.ForgotPasswordModel
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		response := "If the email belongs to an account, a password reset link has been sent to it"
+
+		foundUser, err := h.users.FindUserByEmail(ctx, helper.NormalizeEmail(*request.Email))
+		if err != nil { // Do not reveal which emails have accounts
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		token, err := helper.GenerateOpaqueToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = h.oneTimeTokens.Issue(ctx, foundUser.User_id, models.PasswordResetPurpose, helper.HashOpaqueToken(token), h.clock.Now().Add(passwordResetLifetime))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = h.mailer.Send(ctx, mailer.Message{
+			To:      *foundUser.Email,
+			Subject: "Reset your password",
+			Body: "Someone asked to reset the password of your account. If it was you, use the link below within the next hour.\n\n" +
+				emailLink(h.links.PasswordReset, token) + "\n\n" +
+				"If it was not you, you can ignore this email.",
+		})
+		if err != nil {
+			log.Default().Println(err, "Unable to send password reset email")
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Sets a new password using a token from a password reset email. Every existing session of the user is logged out.
+// @Tags authentication
+// @Param data body resetPasswordRequest true "Reset token and new password"
+// @Produce json
+// @Success 200 {string} string
+// @Failure 400 {object} errorResult
+// @Router /users/password/reset [post]
+func (h *Handlers) ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var request models.ResetPasswordModel
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		resetToken, err := h.oneTimeTokens.Consume(ctx, helper.HashOpaqueToken(*request.Token), models.PasswordResetPurpose)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the reset token is invalid or has expired"})
+			return
+		}
+
+		err = h.users.SetPassword(ctx, resetToken.User_id, HashPassword(*request.Password))
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the reset token is invalid or has expired"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = h.tokens.RevokeAllUserTokens(resetToken.User_id)
+		if err != nil {
+			log.Default().Println(err, "Unable to revoke tokens after password reset")
+		}
+
+		h.emit(ctx, models.Event{Kind: models.UserUpdated, User_id: resetToken.User_id})
+
+		c.JSON(http.StatusOK, "Password Reset Success")
+	}
+}
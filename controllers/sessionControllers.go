@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/repository"
+)
+
+type sessionType = models.Session
+
+const maxDeviceLabelLength = 100
+
+// deviceLabel names the device of a new session, as given by the client or guessed from its user agent
+func deviceLabel(c *gin.Context, requested *string) string {
+	if requested != nil && strings.TrimSpace(*requested) != "" {
+		label := strings.TrimSpace(*requested)
+		if len(label) > maxDeviceLabelLength {
+			label = label[:maxDeviceLabelLength]
+		}
+		return label
+	}
+
+	userAgent := c.Request.UserAgent()
+
+	browser := ""
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+
+	system := ""
+	for _, candidate := range []struct{ token, name string }{
+		{"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Android", "Android"}, {"Windows", "Windows"}, {"Macintosh", "macOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			system = candidate.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "" || system != "":
+		return browser + system
+	}
+
+	return "Unknown device"
+}
+
+// GetSessions godoc
+// @Summary List my sessions
+// @Description Lists the active sessions of the logged in user, one per login, with the device, IP and last time each was used. The session of this request is marked as current.
+// @Tags authentication
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {array} sessionType
+// @Failure 500 {object} errorResult
+// @Router /users/me/sessions [get]
+func (h *Handlers) GetSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		sessions, err := h.sessions.FindActiveByUser(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		for i := range sessions {
+			sessions[i].Current = sessions[i].Session_id == c.GetString("session_id")
+		}
+
+		c.JSON(http.StatusOK, sessions)
+	}
+}
+
+// RevokeSession godoc
+// @Summary End one of my sessions
+// @Description Logs out the device of a session of the logged in user. Its tokens stop working immediately.
+// @Tags authentication
+// @Param id path string true "Session id"
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 404 {object} errorResult
+// @Router /users/me/sessions/{id} [delete]
+func (h *Handlers) RevokeSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err := h.tokens.RevokeSession(ctx, c.GetString("uid"), c.Param("id"))
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, "Session Ended")
+	}
+}
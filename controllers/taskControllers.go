@@ -7,20 +7,13 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/cache/v9"
 	"github.com/hauchongtang/splatbackend/models"
-	"github.com/hauchongtang/splatbackend/repository"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var taskCollection *mongo.Collection = repository.OpenCollection(repository.Client, "tasks")
-
 type taskType = models.Task
 type taskAddType = models.TaskResult
-type popularModule = struct{}
+type popularModule = models.ModulePopularity
 
 // GetAllActivity gdoc
 // @Summary Get all task activities
@@ -28,36 +21,23 @@ type popularModule = struct{}
 // @Tags task
 // @Produce json
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
+// @param token header string false "Authorization token, when not sent as a Bearer token"
 // @Success 200 {object} []taskType
 // @Failure 404 {object} errorResult
 // @Router /tasks [get]
-func GetAllActivity() gin.HandlerFunc {
+func (h *Handlers) GetAllActivity() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
-		results := make([]models.Task, 0)
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
-		// result := models.User{}
-		filter := bson.M{}
-		opts := options.Find().SetSort(bson.D{{"_id", -1}})
-		docCursor, err := taskCollection.Find(ctx, filter, opts)
-
-		if err != nil {
-			log.Fatal("unable to find users")
-			log.Fatal(err)
-			log.Fatal(docCursor)
-		}
-
-		err = docCursor.All(context.TODO(), &results)
+		results, err := h.tasks.FindTasks(ctx)
 
 		if err != nil {
-			log.Fatal("Unable to decode list of users")
-			log.Fatal(err)
-			log.Fatal(docCursor.Current)
+			log.Default().Println(err, "Unable to find tasks")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find tasks in database!"})
 			return
 		}
 
-		c.JSON(http.StatusOK, &results)
+		respondJSON(c, results, lastTaskUpdate(results))
 	}
 }
 
@@ -67,74 +47,40 @@ func GetAllActivity() gin.HandlerFunc {
 // @Tags task
 // @Produce json
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
+// @param token header string false "Authorization token, when not sent as a Bearer token"
 // @Success 200 {object} []taskType
 // @Failure 404 {object} errorResult
 // @Router /cached/tasks [get]
-func GetCachedAllActivity() gin.HandlerFunc {
+func (h *Handlers) GetCachedAllActivity() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
-		results := make([]models.Task, 0)
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
-		// result := models.User{}
-
-		errorMsg := redisCache.Get(ctx, "alltaskscache", &results)
-
-		if errorMsg != nil {
-			log.Default().Println(errorMsg, "Cache fetch error")
-		}
-
-		if len(results) != 0 {
-			c.JSON(http.StatusOK, &results)
-			log.Default().Println("Fetched from cache!")
-			return
-		}
-
-		filter := bson.M{}
-		opts := options.Find().SetSort(bson.D{{"_id", -1}})
-		docCursor, err := taskCollection.Find(ctx, filter, opts)
 
-		if err != nil {
-			log.Fatal("unable to find tasks")
-			log.Fatal(err)
-			log.Fatal(docCursor)
-		}
-
-		err = docCursor.All(context.TODO(), &results)
+		results, err := h.cachedTasks(ctx)
 
 		if err != nil {
-			log.Fatal("Unable to decode list of tasks")
-			log.Fatal(err)
-			log.Fatal(docCursor.Current)
+			log.Default().Println(err, "Unable to find tasks")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find tasks in database!"})
 			return
 		}
 
-		err = redisCache.Set(&cache.Item{
-			Key:   "alltaskscache",
-			Value: results,
-			TTL:   time.Hour * 1,
-		})
-
-		if err != nil {
-			log.Default().Println("Unable to set cache!")
-		}
-
-		c.JSON(http.StatusOK, &results)
+		respondJSON(c, results, lastTaskUpdate(results))
 	}
 }
 
 // AddTask godoc
 // @Summary Add a task
-// @Description Adds task to the database
+// @Description Adds a task of the caller to the database. The user_id may be left out, and only admins can add tasks of other users.
 // @Tags task
 // @Param data body taskAddType true "Task details"
 // @Produce json
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
+// @param token header string false "Authorization token, when not sent as a Bearer token"
 // @Success 200 {object} taskType
+// @Failure 403 {object} errorResult
 // @Failure 500 {object} errorResult
 // @Router /tasks [post]
-func AddTask() gin.HandlerFunc {
+func (h *Handlers) AddTask() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		var task models.Task
@@ -146,25 +92,20 @@ func AddTask() gin.HandlerFunc {
 			return
 		}
 
-		task.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		task.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		task.ID = primitive.NewObjectID()
-
-		resultFromInsertTask, err := taskCollection.InsertOne(ctx, task)
-
-		if err != nil {
-			msg := err
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		// Tasks are added for the caller, only admins can add them for someone else
+		if task.User_id == "" {
+			task.User_id = c.GetString("uid")
+		}
+		if task.User_id != c.GetString("uid") && c.GetString("user_type") != models.RoleAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized to access this resource"})
 			return
 		}
 
-		userTasks := GetTasksByUserIdResult(task.User_id)
+		task.Created_at, _ = time.Parse(time.RFC3339, h.clock.Now().Format(time.RFC3339))
+		task.Updated_at, _ = time.Parse(time.RFC3339, h.clock.Now().Format(time.RFC3339))
+		task.ID = primitive.NewObjectID()
 
-		err = redisCache.Set(&cache.Item{
-			Key:   "taskOf" + task.User_id,
-			Value: userTasks,
-			TTL:   time.Minute * 15,
-		})
+		err := h.tasks.InsertTask(ctx, task)
 
 		if err != nil {
 			msg := err
@@ -172,14 +113,9 @@ func AddTask() gin.HandlerFunc {
 			return
 		}
 
-		// Flush alltaskscache
-		err = redisCache.Delete(ctx, "alltaskscache")
-
-		if err != nil {
-			log.Fatalln(err, "Failed to flush cache")
-		}
+		h.emit(ctx, models.Event{Kind: models.TaskCreated, User_id: task.User_id})
 
-		c.JSON(http.StatusOK, resultFromInsertTask)
+		c.JSON(http.StatusOK, gin.H{"InsertedID": task.ID})
 	}
 }
 
@@ -190,56 +126,26 @@ func AddTask() gin.HandlerFunc {
 // @Produce json
 // @Param id path string true "userId"
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
+// @param token header string false "Authorization token, when not sent as a Bearer token"
 // @Success 200 {object} []taskType
 // @Failure 404 {object} errorResult
 // @Router /tasks/{id} [get]
-func GetTasksByUserId() gin.HandlerFunc {
+func (h *Handlers) GetTasksByUserId() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
-		result := make([]models.Task, 0)
 		targetId := c.Param("id")
 
-		filter := bson.M{"user_id": targetId}
-		opts := options.Find().SetSort(bson.D{{"_id", -1}})
-		docCursor, err := taskCollection.Find(ctx, filter, opts)
+		result, err := h.tasks.FindTasksByUser(ctx, targetId)
 
 		if err != nil {
-			log.Println(err)
-		}
-
-		err = docCursor.All(context.TODO(), &result)
-
-		if err != nil {
-			log.Default().Print("Unable to decode object from mongoDB")
-			log.Fatal(err)
+			log.Default().Println(err, "Unable to find tasks of", targetId)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find tasks in database!"})
+			return
 		}
 
-		c.JSON(http.StatusOK, &result)
-	}
-}
-
-func GetTasksByUserIdResult(targetId string) []models.Task {
-	ctx := context.Background()
-	result := make([]models.Task, 0)
-
-	filter := bson.M{"user_id": targetId}
-	opts := options.Find().SetSort(bson.D{{"_id", -1}})
-	docCursor, err := taskCollection.Find(ctx, filter, opts)
-
-	if err != nil {
-		log.Println(err)
+		respondJSON(c, result, lastTaskUpdate(result))
 	}
-
-	err = docCursor.All(context.TODO(), &result)
-
-	if err != nil {
-		log.Default().Print(err, "Unable to decode object from mongoDB")
-		return result
-	}
-
-	return result
 }
 
 // GetCachedTasksByUserId gdoc
@@ -249,55 +155,25 @@ func GetTasksByUserIdResult(targetId string) []models.Task {
 // @Produce json
 // @Param id path string true "userId"
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
+// @param token header string false "Authorization token, when not sent as a Bearer token"
 // @Success 200 {object} []taskType
 // @Failure 404 {object} errorResult
 // @Router /cached/tasks/{id} [get]
-func GetCachedTasksByUserId() gin.HandlerFunc {
+func (h *Handlers) GetCachedTasksByUserId() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
-		result := make([]models.Task, 0)
 		targetId := c.Param("id")
 
-		errorMsg := redisCache.Get(ctx, "taskOf"+targetId, &result)
-
-		if errorMsg != nil {
-			log.Default().Println("Unable to get and parse from cache")
-		}
-
-		if len(result) != 0 {
-			log.Default().Println("Fetched from cache!")
-			c.JSON(http.StatusOK, result)
-			return
-		}
-
-		filter := bson.M{"user_id": targetId}
-		opts := options.Find().SetSort(bson.D{{"_id", -1}})
-		docCursor, err := taskCollection.Find(ctx, filter, opts)
-
-		if err != nil {
-			log.Println(err)
-		}
-
-		err = docCursor.All(context.TODO(), &result)
-
-		if err != nil {
-			log.Default().Print("Unable to decode object from mongoDB")
-			c.JSON(http.StatusNotFound, err)
-		}
-
-		err = redisCache.Set(&cache.Item{
-			Key:   "taskOf" + targetId,
-			Value: result,
-			TTL:   time.Minute * 15, // Prevent stale data in cache
-		})
+		result, err := h.cachedUserTasks(ctx, targetId)
 
 		if err != nil {
-			log.Default().Println("unable to set cache")
+			log.Default().Println(err, "Unable to find tasks of", targetId)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find tasks in database!"})
+			return
 		}
 
-		c.JSON(http.StatusOK, &result)
+		respondJSON(c, result, lastTaskUpdate(result))
 	}
 }
 
@@ -308,74 +184,28 @@ func GetCachedTasksByUserId() gin.HandlerFunc {
 // @Produce json
 // @Param id path string true "taskId"
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
+// @param token header string false "Authorization token, when not sent as a Bearer token"
 // @Success 200 {object} taskType
+// @Failure 403 {object} errorResult
 // @Failure 404 {object} errorResult
 // @Router /tasks/{id} [put]
-func UpdateHiddenStatus() gin.HandlerFunc {
+func (h *Handlers) UpdateHiddenStatus() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
 		targetId := c.Param("id")
-		_id, err := primitive.ObjectIDFromHex(targetId)
 		result := models.Task{}
-		userTasks := make([]models.Task, 0)
-
-		if err != nil {
-			log.Println(err)
-		}
-
-		result.Hidden = false
-		err = redisCache.Set(&cache.Item{
-			Key:   "task" + targetId,
-			Value: result,
-			TTL:   time.Hour * 72,
-		})
-
-		if err != nil {
-			log.Default().Println("Unable to set cache")
-		}
-
-		filter := bson.M{"_id": _id}
-		update := bson.D{
-			{"$set", bson.D{{"hidden", false}}},
-			{"$set", bson.D{{"updated_at", time.Now()}}},
-		}
-		docCursor := taskCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate())
 
-		docCursor.Decode(&result)
-		result.Hidden = false
-		// Update cache for user-tasks
-		err = redisCache.Get(ctx, "taskOf"+result.User_id, &userTasks)
+		updated, err := h.tasks.SetTaskHidden(ctx, targetId, false)
 
 		if err != nil {
-			log.Default().Println(err, "Unable to get user tasks from cache.")
-			c.JSON(http.StatusOK, &result) // terminate early
+			log.Default().Println(err, "Unable to update task", targetId)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find task in database!"})
 			return
 		}
 
-		for i := 0; i < len(userTasks); i++ {
-			if userTasks[i].ID.Hex() == targetId {
-				userTasks[i] = result
-			}
-		}
-
-		err = redisCache.Set(&cache.Item{
-			Key:   "taskOf" + result.User_id,
-			Value: userTasks,
-			TTL:   time.Minute * 15,
-		})
-
-		if err != nil {
-			log.Default().Println(err, "Unable to set cache")
-		}
-
-		// Flush alltaskscache
-		err = redisCache.Delete(ctx, "alltaskscache")
-
-		if err != nil {
-			log.Fatalln(err, "Failed to flush cache")
-		}
+		result = *updated
+		h.emit(ctx, models.Event{Kind: models.TaskUpdated, User_id: result.User_id})
 
 		c.JSON(http.StatusOK, &result)
 	}
@@ -383,57 +213,28 @@ func UpdateHiddenStatus() gin.HandlerFunc {
 
 // GetMostPopularModule gdoc
 // @Summary Get the most popular modules
-// @Description Gets the module that has the most tasks done on it.
+// @Description Counts the tasks done on each module, the most popular modules first and those as popular by module code.
 // @Tags stats
 // @Produce json
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
+// @param token header string false "Authorization token, when not sent as a Bearer token"
 // @Success 200 {object} []popularModule
 // @Failure 404 {object} errorResult
 // @Router /stats/mostpopular [get]
-func GetMostPopularModule() gin.HandlerFunc {
+func (h *Handlers) GetMostPopularModule() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
 
-		var results []bson.M
-		errMsg := redisCache.Get(ctx, "mostpopularmodulescache", &results)
-
-		if errMsg != nil {
-			log.Default().Println(errMsg, "Faild to retrive from cache")
-		}
-
-		if len(results) != 0 {
-			log.Default().Println("Fetched from cache!")
-			c.JSON(http.StatusOK, results)
-			return
-		}
-
-		docCursor, err := taskCollection.Aggregate(ctx, mongo.Pipeline{
-			{{"$group", bson.D{{"count", bson.D{{"$sum", 1}}}, {"_id", bson.D{{"module_code", "$module_code"}}}}}},
-		})
+		results, err := h.cachedPopularity(ctx)
 
 		if err != nil {
 			log.Println(err)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to count tasks per module!"})
+			return
 		}
 
-		if err = docCursor.All(ctx, &results); err != nil {
-			panic(err)
-		}
-		if err := docCursor.Close(ctx); err != nil {
-			panic(err)
-		}
-
-		err = redisCache.Set(&cache.Item{
-			Key:   "mostpopularmodulescache",
-			Value: results,
-			TTL:   time.Hour * 72,
-		})
-
-		if err != nil {
-			log.Default().Println("Unable to set cache")
-		}
-
-		c.JSON(http.StatusOK, results)
+		// Counts carry no update time, so only the ETag tells whether they changed
+		respondJSON(c, results, time.Time{})
 	}
 }
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+}
+
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	setRetryAfter(c, wait)
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+}
+
+// UnlockAccount gdoc
+// @Summary Unlock an account
+// @Description Lifts the login lockout of an account, and optionally of a client IP. Only admin access.
+// @Tags authentication
+// @Produce json
+// @Param email query string true "Email of the locked account"
+// @Param ip query string false "Client IP to unlock as well"
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 400 {object} errorResult
+// @Failure 403 {object} errorResult
+// @Router /users/unlock [post]
+func (h *Handlers) UnlockAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		email, emailValid := c.GetQuery("email")
+		ip, ipValid := c.GetQuery("ip")
+
+		if !emailValid || email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
+
+		err := h.loginThrottle.Unlock(ctx, email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if ipValid && ip != "" {
+			err = h.loginThrottle.UnlockIP(ctx, ip)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, "Unlock Success")
+	}
+}
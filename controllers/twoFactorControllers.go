@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/repository"
+)
+
+type twoFactorEnrollment = models.TwoFactorEnrollment
+type twoFactorCode = models.TwoFactorCodeModel
+type twoFactorChallenge = models.TwoFactorChallenge
+type twoFactorLogin = models.TwoFactorLoginModel
+
+const (
+	totpIssuer         = "splat"
+	recoveryCodesCount = 10
+)
+
+func hashRecoveryCodes(codes []string) []string {
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, helper.HashOpaqueToken(helper.NormalizeRecoveryCode(code)))
+	}
+
+	return hashes
+}
+
+// useTOTPCode accepts a code at most once, by only moving the last used step forward
+func (h *Handlers) useTOTPCode(ctx context.Context, user models.User, code string) bool {
+	if user.Totp_secret == nil {
+		return false
+	}
+
+	step, valid := helper.VerifyTOTP(*user.Totp_secret, code, h.clock.Now())
+	if !valid {
+		return false
+	}
+
+	used, err := h.users.UseTOTPStep(ctx, user.User_id, step)
+	if err != nil {
+		log.Default().Println(err, "Unable to record used TOTP step")
+		return false
+	}
+
+	return used
+}
+
+// useRecoveryCode removes a recovery code so that it works only once
+func (h *Handlers) useRecoveryCode(ctx context.Context, user models.User, code string) bool {
+	hash := helper.HashOpaqueToken(helper.NormalizeRecoveryCode(code))
+
+	used, err := h.users.UseRecoveryCode(ctx, user.User_id, hash)
+	if err != nil {
+		log.Default().Println(err, "Unable to consume recovery code")
+		return false
+	}
+
+	return used
+}
+
+// verifySecondFactor checks either a TOTP code or a recovery code, whichever was given
+func (h *Handlers) verifySecondFactor(ctx context.Context, user models.User, code *string, recoveryCode *string) bool {
+	if code != nil && *code != "" {
+		return h.useTOTPCode(ctx, user, *code)
+	}
+	if recoveryCode != nil && *recoveryCode != "" {
+		return h.useRecoveryCode(ctx, user, *recoveryCode)
+	}
+
+	return false
+}
+
+// EnrollTwoFactor godoc
+// @Summary Start two factor enrollment
+// @Description Generates a TOTP secret and recovery codes. Two factor authentication is only enabled once a code from the authenticator app is confirmed.
+// @Tags authentication
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} twoFactorEnrollment
+// @Failure 400 {object} errorResult
+// @Router /users/2fa/enroll [post]
+func (h *Handlers) EnrollTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := h.users.FindUserById(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
+			return
+		}
+
+		if user.Two_factor_enabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := helper.GenerateTOTPSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		recoveryCodes, err := helper.GenerateRecoveryCodes(recoveryCodesCount)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = h.users.SetPendingTwoFactor(ctx, user.User_id, secret, hashRecoveryCodes(recoveryCodes))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.TwoFactorEnrollment{
+			Otpauth_uri:    helper.TOTPURI(totpIssuer, *user.Email, secret),
+			Secret:         secret,
+			Recovery_codes: recoveryCodes,
+		})
+	}
+}
+
+// ConfirmTwoFactor godoc
+// @Summary Confirm two factor enrollment
+// @Description Enables two factor authentication once a code generated from the enrolled secret is given.
+// @Tags authentication
+// @Param data body twoFactorCode true "Code from the authenticator app"
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 400 {object} errorResult
+// @Router /users/2fa/confirm [post]
+func (h *Handlers) ConfirmTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var request models.TwoFactorCodeModel
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := h.users.FindUserById(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
+			return
+		}
+
+		if user.Totp_pending_secret == nil || request.Code == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no two factor enrollment to confirm"})
+			return
+		}
+
+		step, valid := helper.VerifyTOTP(*user.Totp_pending_secret, *request.Code, h.clock.Now())
+		if !valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+			return
+		}
+
+		err = h.users.EnableTwoFactor(ctx, user.User_id, *user.Totp_pending_secret, user.Pending_recovery_codes, step)
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no two factor enrollment to confirm"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		h.emit(ctx, models.Event{Kind: models.UserUpdated, User_id: user.User_id})
+		c.JSON(http.StatusOK, "Two Factor Enabled")
+	}
+}
+
+// DisableTwoFactor godoc
+// @Summary Disable two factor authentication
+// @Description Turns off two factor authentication after checking a current code or a recovery code.
+// @Tags authentication
+// @Param data body twoFactorCode true "Code from the authenticator app or a recovery code"
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 400 {object} errorResult
+// @Router /users/2fa/disable [post]
+func (h *Handlers) DisableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var request models.TwoFactorCodeModel
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := h.users.FindUserById(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
+			return
+		}
+
+		if !user.Two_factor_enabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two factor authentication is not enabled"})
+			return
+		}
+
+		if !h.verifySecondFactor(ctx, *user, request.Code, request.Recovery_code) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+			return
+		}
+
+		err = h.users.DisableTwoFactor(ctx, user.User_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		h.emit(ctx, models.Event{Kind: models.UserUpdated, User_id: user.User_id})
+		c.JSON(http.StatusOK, "Two Factor Disabled")
+	}
+}
+
+// LoginTwoFactor godoc
+// @Summary Complete a two factor log in
+// @Description Exchanges the challenge token from /users/login and a TOTP or recovery code for the user details, including OAuth2 tokens.
+// @Tags authentication
+// @Param data body twoFactorLogin true "Challenge token and second factor"
+// @Produce json
+// @Success 200 {object} loginResult
+// @Failure 401 {object} errorResult
+// @Failure 429 {object} errorResult
+// @Router /users/login/2fa [post]
+func (h *Handlers) LoginTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var request models.TwoFactorLoginModel
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		claims, msg := h.tokens.ValidateToken(*request.Challenge_token)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		if claims.Token_type != helper.ChallengeToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge token"})
+			return
+		}
+
+		revoked, err := h.tokens.IsRevoked(claims)
+		if err != nil {
+			log.Default().Println(err, "Unable to check token revocation")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unable to check whether the token was revoked"})
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge token"})
+			return
+		}
+
+		foundUser, err := h.users.FindUserById(ctx, claims.Uid)
+		if err != nil || foundUser.Email == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		// Guessing six digit codes is throttled like guessing passwords
+		clientIP := c.ClientIP()
+		if wait := h.loginThrottle.Check(ctx, *foundUser.Email, clientIP); wait > 0 {
+			tooManyAttempts(c, wait)
+			return
+		}
+
+		if !h.verifySecondFactor(ctx, *foundUser, request.Code, request.Recovery_code) {
+			setRetryAfter(c, h.loginThrottle.Fail(ctx, *foundUser.Email, clientIP))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+
+		err = h.loginThrottle.Succeed(ctx, *foundUser.Email)
+		if err != nil {
+			log.Default().Println(err, "Unable to reset failed login attempts")
+		}
+
+		// A challenge completes a single login
+		err = h.tokens.RevokeToken(claims.Id, claims.ExpiresAt)
+		if err != nil {
+			log.Default().Println(err, "Unable to revoke challenge token")
+		}
+
+		h.completeLogin(ctx, c, *foundUser, deviceLabel(c, request.Device))
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 	"strconv"
 
 	"net/http"
@@ -12,31 +11,43 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"github.com/go-redis/cache/v9"
 	errors "github.com/hauchongtang/splatbackend/errors"
-	helper "github.com/hauchongtang/splatbackend/functions"
 	"github.com/hauchongtang/splatbackend/models"
-	"github.com/hauchongtang/splatbackend/rediscache"
+	helper "github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/middleware"
 	"github.com/hauchongtang/splatbackend/repository"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var userRepository *repository.UserRepository = repository.NewUserRepository(repository.Client, context.TODO())
-var userCollection *mongo.Collection = repository.OpenCollection(repository.Client, "users")
-var redisCache = rediscache.Cache
 var validate = validator.New()
 
-type userType = models.User
+type publicUser = models.PublicUser
+type selfUser = models.SelfUser
+type adminUser = models.AdminUser
+type loginResult = models.LoginResult
 type userSignUp = models.SignUp
 type signUpResult = models.SignUpResult
 type errorResult = errors.ErrorModel
 type userLogin = models.LoginModel
 
+// viewUser picks what the caller may see of a user: every field for admins, the private ones for the user themselves
+// and only the public ones for everyone else, anonymous callers included
+func viewUser(c *gin.Context, user models.AdminUser) interface{} {
+	if !middleware.IsAuthenticated(c) {
+		return user.PublicUser
+	}
+	if c.GetString("user_type") == models.RoleAdmin {
+		return user
+	}
+	if c.GetString("uid") == user.User_id {
+		return user.SelfUser
+	}
+
+	return user.PublicUser
+}
+
 func HashPassword(password string) string {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
@@ -68,26 +79,36 @@ func VerifyPassword(userPassword string, providedPassword string) (bool, string)
 // @Success 200 {object} signUpResult
 // @Failure 400 {object} errorResult
 // @Router /users/signup [post]
-func SignUp() gin.HandlerFunc {
+func (h *Handlers) SignUp() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		var user models.User
+		defer cancel()
+		var signUp models.SignUp
 
-		if err := c.BindJSON(&user); err != nil {
+		if err := c.BindJSON(&signUp); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		validationErr := validate.Struct(user)
+		validationErr := validate.Struct(signUp)
 		if validationErr != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
 
-		count, err := userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
-		defer cancel()
-		if err != nil {
-			log.Panic(err)
+		email := helper.NormalizeEmail(*signUp.Email)
+		emailVerified := false
+		user := models.User{
+			First_name:     signUp.First_name,
+			Last_name:      signUp.Last_name,
+			Email:          &email,
+			Password:       signUp.Password,
+			Email_verified: &emailVerified,
+		}
+
+		_, err := h.users.FindUserByEmail(ctx, *user.Email)
+		if err != nil && err != repository.ErrNotFound {
+			log.Default().Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking for the email"})
 			return
 		}
@@ -95,71 +116,55 @@ func SignUp() gin.HandlerFunc {
 		password := HashPassword(*user.Password)
 		user.Password = &password
 
-		if count > 0 {
+		if err == nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email already exists"})
 			return
 		}
 
-		user.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		user.Created_at, _ = time.Parse(time.RFC3339, h.clock.Now().Format(time.RFC3339))
+		user.Updated_at, _ = time.Parse(time.RFC3339, h.clock.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_id = user.ID.Hex()
-		token, refreshToken, _ := helper.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, user.User_id)
-		user.Token = &token
-		user.Refresh_token = &refreshToken
+		user.User_type = models.RoleUser
 
-		resultInsertionNumber, insertErr := userCollection.InsertOne(ctx, user)
+		insertErr := h.users.InsertUser(ctx, user)
+		if insertErr == repository.ErrDuplicate { // Signed up at the same time with the same email
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email already exists"})
+			return
+		}
 		if insertErr != nil {
 			msg := insertErr
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
 
-		// Set cache for user_id
-		insertErr = redisCache.Set(&cache.Item{
-			Key:   user.User_id,
-			Value: user,
-			TTL:   time.Hour * 72,
-		})
-		if insertErr != nil { // Not fatal if cache fails to set
-			msg := insertErr
-			log.Default().Println(msg)
-		}
-
-		resultsCache := make([]models.User, 0)
-		redisCache.Get(ctx, "alluserscache", &resultsCache)
-		resultsCache = append(resultsCache, user)
-		insertErr = redisCache.Set(&cache.Item{
-			Key:   "alluserscache",
-			Value: resultsCache,
-			TTL:   time.Hour * 1,
-		})
-		if insertErr != nil { // not fatal
-			msg := insertErr
-			log.Default().Println(msg)
-		}
+		h.emit(ctx, models.Event{Kind: models.UserCreated, User_id: user.User_id})
 
-		defer cancel()
+		insertErr = h.sendVerificationEmail(ctx, user)
+		if insertErr != nil { // not fatal, the user can ask for another email
+			log.Default().Println(insertErr, "Unable to send verification email")
+		}
 
-		c.JSON(http.StatusOK, resultInsertionNumber)
+		c.JSON(http.StatusOK, gin.H{"InsertedID": user.ID})
 
 	}
 }
 
 // Login godoc
 // @Summary User log in
-// @Description Responds with user details, including OAuth2 tokens.
+// @Description Responds with user details, including OAuth2 tokens. Users with two factor authentication get a challenge token to complete at /users/login/2fa instead.
 // @Tags authentication
 // @Param data body userLogin true "Sign in credentials"
 // @Produce json
-// @Success 200 {object} userType
+// @Success 200 {object} loginResult
 // @Failure 500 {object} errorResult
+// @Failure 429 {object} errorResult
 // @Router /users/login [post]
-func Login() gin.HandlerFunc {
+func (h *Handlers) Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		var user models.User
-		var foundUser models.User
+		defer cancel()
+		var user models.LoginModel
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
 
 		if err := c.BindJSON(&user); err != nil {
@@ -167,240 +172,215 @@ func Login() gin.HandlerFunc {
 			return
 		}
 
-		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
-		defer cancel()
+		validationErr := validate.Struct(user)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		email := helper.NormalizeEmail(*user.Email)
+		user.Email = &email
+
+		clientIP := c.ClientIP()
+		if wait := h.loginThrottle.Check(ctx, *user.Email, clientIP); wait > 0 {
+			tooManyAttempts(c, wait)
+			return
+		}
+
+		foundUser, err := h.users.FindUserByEmail(ctx, *user.Email)
 		if err != nil {
+			setRetryAfter(c, h.loginThrottle.Fail(ctx, *user.Email, clientIP))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "login or passowrd is incorrect"})
+			return
+		}
+
+		if foundUser.Password == nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "login or passowrd is incorrect"})
 			return
 		}
 
 		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
-		defer cancel()
 		if !passwordIsValid {
+			setRetryAfter(c, h.loginThrottle.Fail(ctx, *user.Email, clientIP))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
 
-		if foundUser.Email == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			return
+		err = h.loginThrottle.Succeed(ctx, *user.Email)
+		if err != nil {
+			log.Default().Println(err, "Unable to reset failed login attempts")
 		}
-		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, foundUser.User_id)
 
-		helper.UpdateAllTokens(token, refreshToken, foundUser.User_id)
-		err = userCollection.FindOne(ctx, bson.M{"user_id": foundUser.User_id}).Decode(&foundUser)
+		if foundUser.Two_factor_enabled { // The password alone is not enough, a second factor has to follow
+			challengeToken, err := h.tokens.GenerateChallengeToken(foundUser.User_id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusOK, models.TwoFactorChallenge{Two_factor_required: true, Challenge_token: challengeToken})
 			return
 		}
 
-		c.JSON(http.StatusOK, foundUser)
+		h.completeLogin(ctx, c, *foundUser, deviceLabel(c, user.Device))
+	}
+}
+
+// completeLogin starts a session for a user whose credentials have all been checked and responds with the user and its tokens
+func (h *Handlers) completeLogin(ctx context.Context, c *gin.Context, foundUser models.User, device string) {
+	if foundUser.Email == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+		return
+	}
+
+	token, refreshToken, err := h.tokens.StartSession(ctx, foundUser, device, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Tokens live in sessions now, copies left on the user by earlier logins are dropped
+	Updated_at, _ := time.Parse(time.RFC3339, h.clock.Now().Format(time.RFC3339))
+	err = h.users.RecordLogin(ctx, foundUser.User_id, Updated_at)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
+	loggedIn, err := h.users.FindUserById(ctx, foundUser.User_id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+
+	c.JSON(http.StatusOK, models.LoginResult{SelfUser: loggedIn.SelfView(), Token: token, Refresh_token: refreshToken})
 }
 
 // GetUsers gdoc
 // @Summary Get all users
-// @Description Gets all users from database directly. Use it to test whether cache is updated correctly.
+// @Description Gets all users from database directly. Use it to test whether cache is updated correctly. Admins get every field, others only the public ones.
 // @Tags user
 // @Produce json
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
-// @Success 200 {object} []userType
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} []publicUser
 // @Failure 404 {object} errorResult
 // @Router /users [get]
-func GetUsers() gin.HandlerFunc {
+func (h *Handlers) GetUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
-		results := make([]models.User, 0)
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
-		// result := models.User{}
-		filter := bson.M{}
-		opts := options.Find().SetSort(bson.D{{"points", -1}})
-		docCursor, err := userCollection.Find(ctx, filter, opts)
+		results, err := h.users.FindUsers(ctx, h.unverified.HideFromLeaderboard)
 
 		if err != nil {
-			log.Fatal("unable to find users")
-			log.Fatal(err)
-			log.Fatal(docCursor)
+			log.Default().Println(err, "Unable to find users")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find users in database!"})
+			return
 		}
 
-		err = docCursor.All(context.TODO(), &results)
-
-		if err != nil {
-			log.Fatal("Unable to decode list of users")
-			log.Fatal(err)
-			log.Fatal(docCursor.Current)
+		// No Last-Modified, deleting a user changes the list without changing when the others were updated
+		if c.GetString("user_type") == models.RoleAdmin {
+			respondJSON(c, models.AdminViews(*results), time.Time{})
 			return
 		}
 
-		log.Println(&results)
-		c.JSON(http.StatusOK, &results)
+		respondJSON(c, models.PublicViews(*results), time.Time{})
 	}
 }
 
 // GetCachedUsers gdoc
 // @Summary Get all users from cache
-// @Description Gets all users from cache.
+// @Description Gets all users from cache, with their public fields only.
 // @Tags user
 // @Produce json
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
-// @Success 200 {object} []userType
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} []publicUser
 // @Failure 404 {object} errorResult
 // @Router /cached/users [get]
-func GetCachedUsers() gin.HandlerFunc {
+func (h *Handlers) GetCachedUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
-		resultsCache := make([]models.User, 0)
-
-		errMsg := redisCache.Get(ctx, "alluserscache", &resultsCache)
 
-		if errMsg != nil { // If cache miss, then log this event to console.
-			log.Default().Println(errMsg, "Unable to fetch from cache!")
-		}
-
-		if len(resultsCache) != 0 {
-			c.JSON(http.StatusOK, &resultsCache)
-			log.Default().Println("Fetched from cache!")
-			return
-		}
-
-		results, err := userRepository.FindUsers(ctx)
+		results, err := h.cachedUsers(ctx)
 
 		if err != nil {
-			c.JSON(http.StatusNotFound, err)
+			log.Default().Println(err, "Unable to find users")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find users in database!"})
 			return
 		}
 
-		redisCache.Set(&cache.Item{
-			Key:   "alluserscache",
-			Value: results,
-			TTL:   time.Hour * 1,
-		})
-
-		c.JSON(http.StatusOK, &results)
+		respondJSON(c, results, time.Time{})
 	}
 }
 
 // GetUserById gdoc
 // @Summary Get a User by id from database
-// @Description Gets a user from database. Use this to check if the cache is updated compared to the database.
+// @Description Gets a user from database. Use this to check if the cache is updated compared to the database. Users get every field of their own account and the public fields of others, admins get every field.
 // @Tags user
 // @Produce json
 // @Param id path string true "userId"
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
-// @Success 200 {object} userType
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} adminUser
 // @Failure 404 {object} errorResult
 // @Router /users/{id} [get]
-func GetUserById() gin.HandlerFunc {
+func (h *Handlers) GetUserById() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
-		result := models.User{}
 		targetId := c.Param("id")
-		filter := bson.M{"user_id": targetId}
 
-		docCursor := userCollection.FindOne(ctx, filter)
-		err := docCursor.Decode(&result)
+		result, err := h.users.FindUserById(ctx, targetId)
 
 		if err != nil {
-			log.Default().Print("Unable to decode object from mongodb")
-			log.Fatal(err)
+			log.Default().Println(err, "Unable to find user", targetId)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
+			return
 		}
-		c.JSON(http.StatusOK, &result)
+		respondJSON(c, viewUser(c, result.AdminView()), result.Updated_at)
 	}
 }
 
 // GetCachedUserById gdoc
 // @Summary Get a User by id from cache
-// @Description Gets a user from the cache if there is a hit. This is the default endpoint.
+// @Description Gets a user from the cache if there is a hit. This is the default endpoint. Users get every field of their own account and the public fields of others, admins get every field.
 // @Tags user
 // @Produce json
 // @Param id path string true "userId"
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
-// @Success 200 {object} userType
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} adminUser
 // @Failure 404 {object} errorResult
 // @Router /cached/users/{id} [get]
-func GetCachedUserById() gin.HandlerFunc {
+func (h *Handlers) GetCachedUserById() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
-		resultCache := models.User{}
 		targetId := c.Param("id")
-		redisCache.Get(ctx, targetId, &resultCache)
-		if !resultCache.ID.IsZero() {
-			fmt.Println("Result from cache!")
-			c.JSON(http.StatusOK, &resultCache)
-			return
-		} else {
-			// filter := bson.M{"user_id": targetId}
-			// docCursor := userCollection.FindOne(ctx, filter)
-			// err := docCursor.Decode(&result)
-			result, err := userRepository.FindUserById(ctx, targetId)
 
-			if err != nil {
-				log.Default().Print("Unable to find user", targetId)
-				log.Default().Println(err)
-				c.JSON(http.StatusNotFound, "Unable to find user in database!")
-				return
-			}
+		result := h.GetCachedUserResultById(targetId)
 
-			err = redisCache.Set(&cache.Item{
-				Key:   targetId,
-				Value: result,
-				TTL:   time.Hour * 72,
-			})
-
-			if err != nil {
-				log.Default().Println("Unable to set result into cache!")
-			}
-
-			c.JSON(http.StatusOK, &result)
+		if result == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
+			return
 		}
+
+		respondJSON(c, viewUser(c, *result), result.Updated_at)
 	}
 }
 
-func GetCachedUserResultById(targetId string) *models.User {
+func (h *Handlers) GetCachedUserResultById(targetId string) *models.AdminUser {
 	ctx := context.Background()
-	resultCache := models.User{}
-	redisCache.Get(ctx, targetId, &resultCache)
-	if !resultCache.ID.IsZero() {
-		fmt.Println("Result from cache!")
-		return &resultCache
-	} else {
-		// filter := bson.M{"user_id": targetId}
-		// docCursor := userCollection.FindOne(ctx, filter)
-		// err := docCursor.Decode(&result)
-		result, err := userRepository.FindUserById(ctx, targetId)
-
-		if err != nil {
-			log.Default().Print("Unable to find user", targetId)
-			log.Default().Println(err)
-			return nil
-		}
 
-		err = redisCache.Set(&cache.Item{
-			Key:   targetId,
-			Value: result,
-			TTL:   time.Hour * 72,
-		})
+	result, err := h.cachedUser(ctx, targetId)
 
-		if err != nil {
-			log.Default().Println("Unable to set result into cache!")
-		}
-
-		return result
+	if err != nil {
+		log.Default().Print("Unable to find user", targetId)
+		log.Default().Println(err)
+		return nil
 	}
-}
 
-type queryStruct struct {
-	name        string
-	dataStr     string
-	initialized bool
+	return &result
 }
 
 // ModifyParticulars gdoc
@@ -414,61 +394,68 @@ type queryStruct struct {
 // @Param email query string false "Email"
 // @Param password query string false "Password"
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
-// @Success 200 {object} userType
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} selfUser
+// @Failure 403 {object} errorResult
 // @Failure 404 {object} errorResult
 // @Router /users/update/{id} [put]
-func ModifyParticulars() gin.HandlerFunc {
+func (h *Handlers) ModifyParticulars() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
-		result := models.User{}
 		targetId := c.Param("id")
 		firstName, firstNameValid := c.GetQuery("first_name")
 		lastName, lastNameValid := c.GetQuery("last_name")
 		email, emailValid := c.GetQuery("email")
 		pwChange, pwValid := c.GetQuery("password")
-		filter := bson.M{"user_id": targetId}
-		toUpdate := bson.M{}
-
-		arr := [4]queryStruct{
-			{"first_name", firstName, firstNameValid},
-			{"last_name", lastName, lastNameValid},
-			{"email", email, emailValid},
-			{"password", pwChange, pwValid},
-		}
-		for _, value := range arr {
-			if value.initialized && (value.name == "password") {
-				toUpdate[value.name] = HashPassword(value.dataStr)
-				continue
-			}
-			if value.initialized {
-				toUpdate[value.name] = value.dataStr
-			}
+		particulars := models.UserParticulars{}
+
+		if firstNameValid {
+			particulars.First_name = &firstName
 		}
-		update := bson.M{
-			"$set": toUpdate,
+		if lastNameValid {
+			particulars.Last_name = &lastName
+		}
+		if emailValid { // A new email has to be verified again
+			email = helper.NormalizeEmail(email)
+			emailVerified := false
+			particulars.Email = &email
+			particulars.Email_verified = &emailVerified
+		}
+		if pwValid {
+			password := HashPassword(pwChange)
+			particulars.Password = &password
 		}
 
-		docCursor := userCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After))
-		err := docCursor.Decode(&result)
+		result, err := h.users.UpdateParticulars(ctx, targetId, particulars)
 
+		if err == repository.ErrDuplicate {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "this email already exists"})
+			return
+		}
 		if err != nil {
-			log.Default().Print("Unable to decode object from mongodb")
-			log.Fatal(err)
+			log.Default().Println(err, "Unable to update user", targetId)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
+			return
 		}
 
-		err = redisCache.Set(&cache.Item{
-			Key:   result.User_id,
-			Value: result,
-			TTL:   time.Hour * 72,
-		})
+		if emailValid {
+			err = h.sendVerificationEmail(ctx, *result)
+			if err != nil {
+				log.Default().Println(err, "Unable to send verification email")
+			}
+		}
 
-		if err != nil { // Unable to set cache not fatal
-			log.Default().Println(err)
+		if pwValid { // Sessions opened with the old password must not outlive the change
+			err = h.tokens.RevokeAllUserTokens(result.User_id)
+			if err != nil {
+				log.Default().Println(err, "Unable to revoke tokens after password change")
+			}
 		}
 
-		c.JSON(http.StatusOK, &result)
+		h.emit(ctx, models.Event{Kind: models.UserUpdated, User_id: result.User_id})
+
+		c.JSON(http.StatusOK, viewUser(c, result.AdminView()))
 	}
 }
 
@@ -478,48 +465,39 @@ func ModifyParticulars() gin.HandlerFunc {
 // @Tags user
 // @Produce json
 // @Param id path string true "userId"
-// @Param adminId query string true "adminId"
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
-// @Success 200 {object} userType
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 403 {object} errorResult
 // @Failure 404 {object} errorResult
 // @Router /users/{id} [delete]
-func DeleteUserById() gin.HandlerFunc {
+func (h *Handlers) DeleteUserById() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
 		targetId := c.Param("id")
-		adminId := c.Query("adminId")
-		objectId, err := primitive.ObjectIDFromHex(targetId)
-		trueAdminId := os.Getenv("ADMIN_ID")
+
+		err := h.users.DeleteUser(ctx, targetId)
 
 		if err != nil {
+			log.Println("Failed to delete from db")
 			log.Println(err)
 		}
 
-		if adminId != trueAdminId {
-			c.JSON(http.StatusBadRequest, "Not an admin!")
-			return
-		}
-
-		filter := bson.M{"_id": objectId}
-
-		_, err = userCollection.DeleteOne(ctx, filter)
+		err = h.tokens.RevokeAllUserTokens(targetId)
 
 		if err != nil {
-			log.Println("Failed to delete from db")
-			log.Println(err)
+			log.Default().Println(err, "Unable to revoke tokens of deleted user")
 		}
 
-		err = redisCache.Delete(ctx, targetId)
+		err = h.apiKeys.DeleteByUser(ctx, targetId)
 
-		if err != nil { // failure to delete from cache will result in cache not being updated correctly
-			log.Default().Println(err)
-			log.Default().Println("Fail to delete", targetId, "from cache")
-			c.JSON(http.StatusBadRequest, err)
-			return
+		if err != nil {
+			log.Default().Println(err, "Unable to delete API keys of deleted user")
 		}
 
+		h.emit(ctx, models.Event{Kind: models.UserDeleted, User_id: targetId})
+
 		c.JSON(http.StatusOK, "Delete Success")
 	}
 }
@@ -532,88 +510,56 @@ func DeleteUserById() gin.HandlerFunc {
 // @Param id path string true "userId"
 // @Param pointstoadd query string true "pointsToAdd"
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
-// @Success 200 {object} userType
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} selfUser
+// @Failure 400 {object} errorResult
+// @Failure 403 {object} errorResult
 // @Failure 404 {object} errorResult
 // @Router /users/{id} [put]
-func IncreasePoints() gin.HandlerFunc {
+func (h *Handlers) IncreasePoints() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
 		targetId := c.Param("id")
 		log.Println(targetId)
 		pointsToAdd := c.Query("pointstoadd")
-		_id, err := primitive.ObjectIDFromHex(targetId)
-
-		if err != nil {
-			log.Println(err)
-		}
 
 		points, err := strconv.ParseInt(pointsToAdd, 0, 64)
 
 		if err != nil {
 			log.Println(err, "Unable to parse pointsToAdd")
-		}
-
-		filter := bson.M{"_id": _id}
-		update := bson.D{
-			{"$inc", bson.D{{"points", points}}},
-		}
-		docCursor := userCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true))
-
-		result := models.User{}
-
-		err = docCursor.Decode(&result)
-
-		if err != nil {
-			log.Default().Println("Unable to decode result ", err)
-			c.JSON(http.StatusBadRequest, err)
-			err = nil
-		}
-
-		result.Points = result.Points + int(points)
-
-		err = redisCache.Set(&cache.Item{
-			Key:   targetId,
-			Value: result,
-			TTL:   time.Hour * 72,
-		})
-
-		if err != nil { // Unable to update cache: Fatal
-			log.Default().Println("Unable to update cache")
-			c.JSON(http.StatusBadRequest, err)
-			return
-		}
-
-		usersResult := make([]models.User, 0)
-		err = redisCache.Get(ctx, "alluserscache", &usersResult)
-
-		if err != nil { // Unable to update cache: Fatal
-			log.Default().Println("Unable to update cache")
-			c.JSON(http.StatusBadRequest, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pointstoadd must be a whole number"})
 			return
 		}
 
-		for i := 0; i < len(usersResult); i++ { // Linear Search O(N)
-			if usersResult[i].User_id == targetId {
-				usersResult[i] = result
-				break
+		if h.unverified.BlockPoints {
+			user, err := h.users.FindUserById(ctx, targetId)
+			if err == repository.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
+				return
+			}
+			if err != nil {
+				log.Default().Println(err, "Unable to find user")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !user.IsEmailVerified() {
+				c.JSON(http.StatusForbidden, gin.H{"error": "verify your email to earn points"})
+				return
 			}
 		}
 
-		err = redisCache.Set(&cache.Item{
-			Key:   "alluserscache",
-			Value: usersResult,
-			TTL:   time.Hour * 1,
-		})
+		result, err := h.users.AddPoints(ctx, targetId, int(points))
 
 		if err != nil {
-			log.Default().Println("Unable to update alluserscache")
-			c.JSON(http.StatusBadRequest, err)
+			log.Default().Println("Unable to add points ", err)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
 			return
 		}
 
-		c.JSON(http.StatusOK, result)
+		h.emit(ctx, models.Event{Kind: models.UserUpdated, User_id: targetId})
+
+		c.JSON(http.StatusOK, viewUser(c, result.AdminView()))
 	}
 }
 
@@ -625,11 +571,12 @@ func IncreasePoints() gin.HandlerFunc {
 // @Param id path string true "userId"
 // @Param linktoadd query string true "linkToAdd"
 // @Security ApiKeyAuth
-// @param token header string true "Authorization token"
-// @Success 200 {object} userType
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} selfUser
+// @Failure 403 {object} errorResult
 // @Failure 404 {object} errorResult
 // @Router /users/modules/{id} [put]
-func UpdateModuleImportLink() gin.HandlerFunc {
+func (h *Handlers) UpdateModuleImportLink() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 		c.Request.Header.Add("Access-Control-Allow-Origin", "*")
@@ -639,42 +586,60 @@ func UpdateModuleImportLink() gin.HandlerFunc {
 		// if !strings.Contains(linkToAdd, "nusmods.com/timetable") {
 		// 	c.JSON(http.StatusForbidden, userCollection.FindOne(ctx, bson.M{"_id": "0"}))
 		// }
-		_id, err := primitive.ObjectIDFromHex(targetId)
+		result, err := h.users.SetTimetable(ctx, targetId, linkToAdd)
 
 		if err != nil {
-			log.Println(err)
+			log.Default().Println("Unable to update timetable")
+			log.Default().Println(err)
+			c.JSON(http.StatusBadRequest, err)
+			return
 		}
 
-		filter := bson.M{"_id": _id}
-		update := bson.D{{"$set", bson.D{{"timetable", linkToAdd}}}}
-		docCursor := userCollection.FindOneAndUpdate(ctx, filter, update)
+		h.emit(ctx, models.Event{Kind: models.UserUpdated, User_id: targetId})
 
-		result := models.User{}
+		c.JSON(http.StatusOK, viewUser(c, result.AdminView()))
+	}
+}
 
-		err = docCursor.Decode(&result)
+// UpdateUserRole gdoc
+// @Summary Change the role of a user
+// @Description Sets the role of a user to user, moderator or admin. Only admin access. Tokens issued with the previous role are revoked.
+// @Tags user
+// @Produce json
+// @Param id path string true "userId"
+// @Param role query string true "Role"
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {object} adminUser
+// @Failure 400 {object} errorResult
+// @Failure 403 {object} errorResult
+// @Failure 404 {object} errorResult
+// @Router /users/role/{id} [put]
+func (h *Handlers) UpdateUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		targetId := c.Param("id")
+		role := c.Query("role")
 
-		if err != nil {
-			log.Default().Println("Unable to decode result")
-			log.Default().Println(err)
-			c.JSON(http.StatusBadRequest, err)
+		if !models.IsValidRole(role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role " + role})
 			return
 		}
 
-		result.Timetable = linkToAdd
-
-		err = redisCache.Set(&cache.Item{
-			Key:   targetId,
-			Value: result,
-			TTL:   time.Hour * 72,
-		})
+		result, err := h.users.SetRole(ctx, targetId, role)
 
 		if err != nil {
-			log.Default().Println("Unable to update cache")
-			log.Default().Panicln(err)
-			c.JSON(http.StatusBadRequest, err)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
 			return
 		}
 
-		c.JSON(http.StatusOK, docCursor)
+		err = h.tokens.RevokeAllUserTokens(targetId)
+		if err != nil {
+			log.Default().Println(err, "Unable to revoke tokens after role change")
+		}
+
+		h.emit(ctx, models.Event{Kind: models.UserUpdated, User_id: targetId})
+
+		c.JSON(http.StatusOK, result.AdminView())
 	}
 }
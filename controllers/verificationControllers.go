@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/mailer"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/repository"
+)
+
+const emailVerificationLifetime = time.Hour * 48
+
+// UnverifiedPolicy lists what accounts with an unverified email are kept out of
+type UnverifiedPolicy struct {
+	HideFromLeaderboard bool
+	BlockPoints         bool
+}
+
+// UnverifiedPolicyFromEnv reads UNVERIFIED_RESTRICTIONS, a comma separated list of "leaderboard" and "points".
+// Both apply when it is unset, and "none" lifts every restriction.
+func UnverifiedPolicyFromEnv() UnverifiedPolicy {
+	restrictions, found := os.LookupEnv("UNVERIFIED_RESTRICTIONS")
+	if !found {
+		return UnverifiedPolicy{HideFromLeaderboard: true, BlockPoints: true}
+	}
+
+	policy := UnverifiedPolicy{}
+	for _, restriction := range strings.Split(restrictions, ",") {
+		switch strings.TrimSpace(restriction) {
+		case "leaderboard":
+			policy.HideFromLeaderboard = true
+		case "points":
+			policy.BlockPoints = true
+		}
+	}
+
+	return policy
+}
+
+// EmailLinks are the pages opened by the links in emails, which get the token of the email in their query
+type EmailLinks struct {
+	// Verification is EMAIL_VERIFICATION_URL, such as the /users/verify endpoint of this API or a page of the app
+	Verification string
+	// PasswordReset is PASSWORD_RESET_URL, the page of the app where users choose a new password
+	PasswordReset string
+}
+
+// EmailLinksFromEnv reads EMAIL_VERIFICATION_URL and PASSWORD_RESET_URL
+func EmailLinksFromEnv() EmailLinks {
+	return EmailLinks{
+		Verification:  os.Getenv("EMAIL_VERIFICATION_URL"),
+		PasswordReset: os.Getenv("PASSWORD_RESET_URL"),
+	}
+}
+
+// Check tells whether every link is an absolute http or https URL without a query.
+// Links are never built from the headers of a request, which the sender of the request chooses.
+func (l EmailLinks) Check() error {
+	err := checkEmailLink("EMAIL_VERIFICATION_URL", l.Verification)
+	if err != nil {
+		return err
+	}
+
+	return checkEmailLink("PASSWORD_RESET_URL", l.PasswordReset)
+}
+
+func checkEmailLink(name string, link string) error {
+	if link == "" {
+		return fmt.Errorf("%s is not set", name)
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.RawQuery != "" {
+		return fmt.Errorf("%s is %q, which is not an http or https URL without a query", name, link)
+	}
+
+	return nil
+}
+
+// emailLink is the link to base sent with token
+func emailLink(base string, token string) string {
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+func (h *Handlers) sendVerificationEmail(ctx context.Context, user models.User) error {
+	token, err := helper.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = h.oneTimeTokens.Issue(ctx, user.User_id, models.EmailVerificationPurpose, helper.HashOpaqueToken(token), h.clock.Now().Add(emailVerificationLifetime))
+	if err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, mailer.Message{
+		To:      *user.Email,
+		Subject: "Verify your email",
+		Body: "Welcome to splat! Confirm that this is your email with the link below.\n\n" +
+			emailLink(h.links.Verification, token) + "\n\n" +
+			"The link expires in 48 hours.",
+	})
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email
+// @Description Marks the email of a user as verified using the token from a verification email.
+// @Tags authentication
+// @Param token query string true "Verification token"
+// @Produce json
+// @Success 200 {string} string
+// @Failure 400 {object} errorResult
+// @Router /users/verify [get]
+func (h *Handlers) VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		token := c.Query("token")
+
+		verificationToken, err := h.oneTimeTokens.Consume(ctx, helper.HashOpaqueToken(token), models.EmailVerificationPurpose)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the verification token is invalid or has expired"})
+			return
+		}
+
+		err = h.users.SetEmailVerified(ctx, verificationToken.User_id, true)
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the verification token is invalid or has expired"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The user may now appear on the leaderboard
+		h.emit(ctx, models.Event{Kind: models.UserUpdated, User_id: verificationToken.User_id})
+
+		c.JSON(http.StatusOK, "Email Verified")
+	}
+}
+
+// ResendVerificationEmail godoc
+// @Summary Resend the verification email
+// @Description Sends a new verification email to the logged in user. Links from earlier emails stop working.
+// @Tags authentication
+// @Produce json
+// @Security ApiKeyAuth
+// @param token header string false "Authorization token, when not sent as a Bearer token"
+// @Success 200 {string} string
+// @Failure 400 {object} errorResult
+// @Router /users/verify/resend [post]
+func (h *Handlers) ResendVerificationEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := h.users.FindUserById(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unable to find user in database!"})
+			return
+		}
+
+		if user.IsEmailVerified() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is already verified"})
+			return
+		}
+
+		err = h.sendVerificationEmail(ctx, *user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, "Verification Email Sent")
+	}
+}
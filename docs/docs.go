@@ -20,6 +20,248 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/.well-known/jwks.json": {
+            "get": {
+                "description": "Responds with the public keys that verify tokens issued by this API, as a JSON Web Key Set.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Get the token verification keys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.jsonWebKeySet"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/cache/families/{family}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Evicts every key of a family from the cache of every instance. Families are alluserscache, user, alltaskscache, taskOf and mostpopularmodulescache. Only admin access.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cache"
+                ],
+                "summary": "Purge a family of cache keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Family of cache keys",
+                        "name": "family",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.cacheFamilyResult"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/cache/families/{family}/warm": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Caches every key of a family that is not cached yet. Families are alluserscache, user, alltaskscache, taskOf and mostpopularmodulescache. Only admin access.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cache"
+                ],
+                "summary": "Warm a family of cache keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Family of cache keys",
+                        "name": "family",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.cacheFamilyResult"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/cache/keys/{key}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Gets how long a key is still cached and the size of its value. Only admin access.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cache"
+                ],
+                "summary": "Inspect a cache key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cache key, such as alluserscache, user:\u003cuserId\u003e or taskOf\u003cuserId\u003e",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.cacheKeyInfo"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/cache/warm": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Caches every key of every family that is not cached yet. Only admin access.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cache"
+                ],
+                "summary": "Warm every cache",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/controllers.cacheFamilyResult"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
         "/cached/tasks": {
             "get": {
                 "security": [
@@ -38,10 +280,9 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -88,10 +329,9 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -120,7 +360,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Gets all users from cache.",
+                "description": "Gets all users from cache, with their public fields only.",
                 "produces": [
                     "application/json"
                 ],
@@ -131,10 +371,9 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -143,7 +382,7 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/controllers.userType"
+                                "$ref": "#/definitions/controllers.publicUser"
                             }
                         }
                     },
@@ -163,7 +402,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Gets a user from the cache if there is a hit. This is the default endpoint.",
+                "description": "Gets a user from the cache if there is a hit. This is the default endpoint. Users get every field of their own account and the public fields of others, admins get every field.",
                 "produces": [
                     "application/json"
                 ],
@@ -181,17 +420,16 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controllers.userType"
+                            "$ref": "#/definitions/controllers.adminUser"
                         }
                     },
                     "404": {
@@ -203,6 +441,45 @@ const docTemplate = `{
                 }
             }
         },
+        "/metrics": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Gets the hits, misses, errors and latency of the cache by family of keys, in the Prometheus text format. Only admin access.",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "cache"
+                ],
+                "summary": "Get cache metrics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
         "/stats/mostpopular": {
             "get": {
                 "security": [
@@ -210,7 +487,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Gets the module that has the most tasks done on it.",
+                "description": "Counts the tasks done on each module, the most popular modules first and those as popular by module code.",
                 "produces": [
                     "application/json"
                 ],
@@ -221,10 +498,9 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -264,10 +540,9 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -294,7 +569,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Adds task to the database",
+                "description": "Adds a task of the caller to the database. The user_id may be left out, and only admins can add tasks of other users.",
                 "produces": [
                     "application/json"
                 ],
@@ -314,10 +589,9 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -327,6 +601,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/controllers.taskType"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -361,10 +641,9 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -409,10 +688,9 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -422,6 +700,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/controllers.taskType"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -438,7 +722,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Gets all users from database directly. Use it to test whether cache is updated correctly.",
+                "description": "Gets all users from database directly. Use it to test whether cache is updated correctly. Admins get every field, others only the public ones.",
                 "produces": [
                     "application/json"
                 ],
@@ -449,10 +733,9 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -461,7 +744,7 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/controllers.userType"
+                                "$ref": "#/definitions/controllers.publicUser"
                             }
                         }
                     },
@@ -474,36 +757,47 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/login": {
+        "/users/2fa/confirm": {
             "post": {
-                "description": "Responds with user details, including OAuth2 tokens.",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Enables two factor authentication once a code generated from the enrolled secret is given.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "authentication"
                 ],
-                "summary": "User log in",
+                "summary": "Confirm two factor enrollment",
                 "parameters": [
                     {
-                        "description": "Sign in credentials",
+                        "description": "Code from the authenticator app",
                         "name": "data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/controllers.userLogin"
+                            "$ref": "#/definitions/controllers.twoFactorCode"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controllers.userType"
+                            "type": "string"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/controllers.errorResult"
                         }
@@ -511,53 +805,47 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/modules/{id}": {
-            "put": {
+        "/users/2fa/disable": {
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Updates the module import link of the userId specified.",
+                "description": "Turns off two factor authentication after checking a current code or a recovery code.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "authentication"
                 ],
-                "summary": "Update the module import link of a user",
+                "summary": "Disable two factor authentication",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "userId",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "linkToAdd",
-                        "name": "linktoadd",
-                        "in": "query",
-                        "required": true
+                        "description": "Code from the authenticator app or a recovery code",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.twoFactorCode"
+                        }
                     },
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controllers.userType"
+                            "type": "string"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/controllers.errorResult"
                         }
@@ -565,32 +853,34 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/signup": {
+        "/users/2fa/enroll": {
             "post": {
-                "description": "Responds with userId",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Generates a TOTP secret and recovery codes. Two factor authentication is only enabled once a code from the authenticator app is confirmed.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "authentication"
                 ],
-                "summary": "User sign up",
+                "summary": "Start two factor enrollment",
                 "parameters": [
                     {
-                        "description": "New user credentials",
-                        "name": "data",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/controllers.userSignUp"
-                        }
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controllers.signUpResult"
+                            "$ref": "#/definitions/controllers.twoFactorEnrollment"
                         }
                     },
                     "400": {
@@ -602,70 +892,87 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/update/{id}": {
-            "put": {
+        "/users/apikeys": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Change user particulars",
+                "description": "Lists the API keys of the logged in user, with when each was last used. Keys themselves are not returned.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "authentication"
                 ],
-                "summary": "Modify user particulars",
+                "summary": "List API keys",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "userId",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "First name",
-                        "name": "first_name",
-                        "in": "query"
-                    },
-                    {
-                        "type": "string",
-                        "description": "Last name",
-                        "name": "last_name",
-                        "in": "query"
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/controllers.apiKeyType"
+                            }
+                        }
                     },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
                     {
-                        "type": "string",
-                        "description": "Email",
-                        "name": "email",
-                        "in": "query"
-                    },
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Creates a named API key limited to the given scopes (tasks:read, tasks:write, stats:read, users:read, users:write). The key is only shown in this response. Send it like an access token.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Create an API key",
+                "parameters": [
                     {
-                        "type": "string",
-                        "description": "Password",
-                        "name": "password",
-                        "in": "query"
+                        "description": "Name and scopes of the key",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.createApiKeyRequest"
+                        }
                     },
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controllers.userType"
+                            "$ref": "#/definitions/controllers.createdApiKey"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/controllers.errorResult"
                         }
@@ -673,42 +980,41 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/{id}": {
-            "get": {
+        "/users/apikeys/{keyId}": {
+            "delete": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Gets a user from database. Use this to check if the cache is updated compared to the database.",
+                "description": "Deletes an API key of the logged in user. Requests using it are refused from then on.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "authentication"
                 ],
-                "summary": "Get a User by id from database",
+                "summary": "Revoke an API key",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "userId",
-                        "name": "id",
+                        "description": "Key id",
+                        "name": "keyId",
                         "in": "path",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controllers.userType"
+                            "type": "string"
                         }
                     },
                     "404": {
@@ -718,114 +1024,1144 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "put": {
-                "security": [
+            }
+        },
+        "/users/login": {
+            "post": {
+                "description": "Responds with user details, including OAuth2 tokens. Users with two factor authentication get a challenge token to complete at /users/login/2fa instead.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "User log in",
+                "parameters": [
                     {
-                        "ApiKeyAuth": []
+                        "description": "Sign in credentials",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.userLogin"
+                        }
                     }
                 ],
-                "description": "Increase points of a user by specified amount.",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.loginResult"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/login/2fa": {
+            "post": {
+                "description": "Exchanges the challenge token from /users/login and a TOTP or recovery code for the user details, including OAuth2 tokens.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "authentication"
                 ],
-                "summary": "Increase points of a user",
+                "summary": "Complete a two factor log in",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "userId",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Challenge token and second factor",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.twoFactorLogin"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.loginResult"
+                        }
                     },
-                    {
-                        "type": "string",
-                        "description": "pointsToAdd",
-                        "name": "pointstoadd",
-                        "in": "query",
-                        "required": true
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
                     },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/logout": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Revokes the token used for this request and ends its session.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Log out",
+                "parameters": [
                     {
                         "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controllers.userType"
+                            "type": "string"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/controllers.errorResult"
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/users/logout/all": {
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Deletes a user via userId. Only admin access.",
+                "description": "Revokes every token issued to the user so far and ends every session, on every device.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "authentication"
                 ],
-                "summary": "Delete a user given a userId",
+                "summary": "Log out everywhere",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "userId",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "adminId",
-                        "name": "adminId",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Authorization token",
+                        "description": "Authorization token, when not sent as a Bearer token",
                         "name": "token",
-                        "in": "header",
-                        "required": true
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controllers.userType"
+                            "type": "string"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/controllers.errorResult"
                         }
                     }
                 }
             }
-        }
+        },
+        "/users/me/sessions": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Lists the active sessions of the logged in user, one per login, with the device, IP and last time each was used. The session of this request is marked as current.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "List my sessions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/controllers.sessionType"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/sessions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Logs out the device of a session of the logged in user. Its tokens stop working immediately.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "End one of my sessions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/modules/{id}": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Updates the module import link of the userId specified.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Update the module import link of a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "userId",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "linkToAdd",
+                        "name": "linktoadd",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.selfUser"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/oidc/{provider}/callback": {
+            "get": {
+                "description": "Responds with user details, including OAuth2 tokens. The provider account is linked to the user with the same email when the provider verified it, otherwise a new user is created. Users with two factor authentication get a challenge token to complete at /users/login/2fa instead.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Complete a sign in with an OpenID Connect provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization code",
+                        "name": "code",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "State sent to the provider",
+                        "name": "state",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.loginResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/oidc/{provider}/login": {
+            "get": {
+                "description": "Redirects to the sign in page of the provider, using the authorization code flow with PKCE. The provider sends the user back to the callback, which only completes in the browser that got the oidc_state cookie set here.",
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Sign in with an OpenID Connect provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/password/forgot": {
+            "post": {
+                "description": "Emails a single use password reset link to the user. Responds the same way whether or not the email exists.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Request a password reset",
+                "parameters": [
+                    {
+                        "description": "Account email",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.forgotPasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/password/reset": {
+            "post": {
+                "description": "Sets a new password using a token from a password reset email. Every existing session of the user is logged out.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Reset a password",
+                "parameters": [
+                    {
+                        "description": "Reset token and new password",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.resetPasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/refresh": {
+            "post": {
+                "description": "Responds with a new token and refresh token for the same session. The refresh token is rotated, and replaying an already used refresh token ends its session.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Exchange a refresh token",
+                "parameters": [
+                    {
+                        "description": "Refresh token",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.refreshRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.tokenResult"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/role/{id}": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Sets the role of a user to user, moderator or admin. Only admin access. Tokens issued with the previous role are revoked.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Change the role of a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "userId",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Role",
+                        "name": "role",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.adminUser"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/signup": {
+            "post": {
+                "description": "Responds with userId",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "User sign up",
+                "parameters": [
+                    {
+                        "description": "New user credentials",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.userSignUp"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.signUpResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/unlock": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Lifts the login lockout of an account, and optionally of a client IP. Only admin access.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Unlock an account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Email of the locked account",
+                        "name": "email",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client IP to unlock as well",
+                        "name": "ip",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/update/{id}": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Change user particulars",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Modify user particulars",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "userId",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "First name",
+                        "name": "first_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last name",
+                        "name": "last_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Email",
+                        "name": "email",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Password",
+                        "name": "password",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.selfUser"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/verify": {
+            "get": {
+                "description": "Marks the email of a user as verified using the token from a verification email.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Verify an email",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Verification token",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/verify/resend": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Sends a new verification email to the logged in user. Links from earlier emails stop working.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Resend the verification email",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Gets a user from database. Use this to check if the cache is updated compared to the database. Users get every field of their own account and the public fields of others, admins get every field.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get a User by id from database",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "userId",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.adminUser"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Increase points of a user by specified amount.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Increase points of a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "userId",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "pointsToAdd",
+                        "name": "pointstoadd",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.selfUser"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Deletes a user via userId. Only admin access.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Delete a user given a userId",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "userId",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization token, when not sent as a Bearer token",
+                        "name": "token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.errorResult"
+                        }
+                    }
+                }
+            }
+        }
     },
     "definitions": {
+        "controllers.adminUser": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "email_verified": {
+                    "type": "boolean"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "identities": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.LinkedIdentity"
+                    }
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "points": {
+                    "type": "integer"
+                },
+                "timetable": {
+                    "type": "string"
+                },
+                "two_factor_enabled": {
+                    "type": "boolean"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                },
+                "user_type": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.apiKeyType": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "key_id": {
+                    "type": "string"
+                },
+                "last_used_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.cacheFamilyResult": {
+            "type": "object",
+            "properties": {
+                "family": {
+                    "type": "string"
+                },
+                "keys": {
+                    "type": "integer"
+                }
+            }
+        },
+        "controllers.cacheKeyInfo": {
+            "type": "object",
+            "properties": {
+                "family": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "size_bytes": {
+                    "type": "integer"
+                },
+                "ttl_seconds": {
+                    "type": "number"
+                }
+            }
+        },
+        "controllers.createApiKeyRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "scopes"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "maxLength": 100
+                },
+                "scopes": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "controllers.createdApiKey": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "key_id": {
+                    "type": "string"
+                },
+                "last_used_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
         "controllers.errorResult": {
             "type": "object",
             "properties": {
@@ -834,8 +2170,200 @@ const docTemplate = `{
                 }
             }
         },
+        "controllers.forgotPasswordRequest": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.jsonWebKeySet": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.JSONWebKey"
+                    }
+                }
+            }
+        },
+        "controllers.loginResult": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "email_verified": {
+                    "type": "boolean"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "points": {
+                    "type": "integer"
+                },
+                "refresh_token": {
+                    "type": "string"
+                },
+                "timetable": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                },
+                "two_factor_enabled": {
+                    "type": "boolean"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                },
+                "user_type": {
+                    "type": "string"
+                }
+            }
+        },
         "controllers.popularModule": {
-            "type": "object"
+            "type": "object",
+            "properties": {
+                "_id": {
+                    "$ref": "#/definitions/models.ModuleKey"
+                },
+                "count": {
+                    "type": "integer"
+                }
+            }
+        },
+        "controllers.publicUser": {
+            "type": "object",
+            "properties": {
+                "first_name": {
+                    "type": "string"
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "points": {
+                    "type": "integer"
+                },
+                "timetable": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.refreshRequest": {
+            "type": "object",
+            "required": [
+                "refresh_token"
+            ],
+            "properties": {
+                "refresh_token": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.resetPasswordRequest": {
+            "type": "object",
+            "required": [
+                "password",
+                "token"
+            ],
+            "properties": {
+                "password": {
+                    "type": "string",
+                    "minLength": 6
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.selfUser": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "email_verified": {
+                    "type": "boolean"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "points": {
+                    "type": "integer"
+                },
+                "timetable": {
+                    "type": "string"
+                },
+                "two_factor_enabled": {
+                    "type": "boolean"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                },
+                "user_type": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.sessionType": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "current": {
+                    "description": "Current marks the session of the request listing the sessions",
+                    "type": "boolean"
+                },
+                "device": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "last_seen_at": {
+                    "type": "string"
+                },
+                "session_id": {
+                    "type": "string"
+                },
+                "user_agent": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
         },
         "controllers.signUpResult": {
             "type": "object",
@@ -922,6 +2450,65 @@ const docTemplate = `{
                 }
             }
         },
+        "controllers.tokenResult": {
+            "type": "object",
+            "properties": {
+                "refresh_token": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.twoFactorCode": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "recovery_code": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.twoFactorEnrollment": {
+            "type": "object",
+            "properties": {
+                "otpauth_uri": {
+                    "type": "string"
+                },
+                "recovery_codes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "secret": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.twoFactorLogin": {
+            "type": "object",
+            "required": [
+                "challenge_token"
+            ],
+            "properties": {
+                "challenge_token": {
+                    "type": "string"
+                },
+                "code": {
+                    "type": "string"
+                },
+                "device": {
+                    "type": "string"
+                },
+                "recovery_code": {
+                    "type": "string"
+                }
+            }
+        },
         "controllers.userLogin": {
             "type": "object",
             "required": [
@@ -929,6 +2516,9 @@ const docTemplate = `{
                 "password"
             ],
             "properties": {
+                "device": {
+                    "type": "string"
+                },
                 "email": {
                     "type": "string"
                 },
@@ -941,8 +2531,10 @@ const docTemplate = `{
         "controllers.userSignUp": {
             "type": "object",
             "required": [
+                "email",
                 "first_name",
-                "last_name"
+                "last_name",
+                "password"
             ],
             "properties": {
                 "email": {
@@ -959,61 +2551,66 @@ const docTemplate = `{
                     "minLength": 1
                 },
                 "password": {
-                    "type": "string"
+                    "type": "string",
+                    "minLength": 6
                 }
             }
         },
-        "controllers.userType": {
+        "models.JSONWebKey": {
             "type": "object",
-            "required": [
-                "Password",
-                "email",
-                "first_name",
-                "last_name"
-            ],
             "properties": {
-                "Password": {
-                    "type": "string",
-                    "minLength": 6
-                },
-                "created_at": {
+                "alg": {
                     "type": "string"
                 },
-                "email": {
+                "crv": {
                     "type": "string"
                 },
-                "first_name": {
-                    "type": "string",
-                    "maxLength": 100,
-                    "minLength": 1
-                },
-                "id": {
+                "e": {
                     "type": "string"
                 },
-                "last_name": {
-                    "type": "string",
-                    "maxLength": 100,
-                    "minLength": 1
-                },
-                "points": {
-                    "type": "integer"
+                "kid": {
+                    "type": "string"
                 },
-                "refresh_token": {
+                "kty": {
                     "type": "string"
                 },
-                "timetable": {
+                "n": {
                     "type": "string"
                 },
-                "token": {
+                "use": {
                     "type": "string"
                 },
-                "updated_at": {
+                "x": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.LinkedIdentity": {
+            "type": "object",
+            "properties": {
+                "linked_at": {
                     "type": "string"
                 },
-                "user_id": {
+                "provider": {
                     "type": "string"
                 }
             }
+        },
+        "models.ModuleKey": {
+            "type": "object",
+            "properties": {
+                "module_code": {
+                    "type": "string"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "description": "Access token as \"Bearer \u003ctoken\u003e\". The token header is still accepted.",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
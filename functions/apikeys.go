@@ -0,0 +1,25 @@
+package functions
+
+import "strings"
+
+// ApiKeyPrefix starts every API key, which tells them apart from JWTs
+const ApiKeyPrefix = "splat_"
+
+// apiKeyDisplayLength is how much of a key is kept in clear so that users can recognise it
+const apiKeyDisplayLength = len(ApiKeyPrefix) + 6
+
+// GenerateApiKey returns a new API key and the part of it that may be shown after creation
+func GenerateApiKey() (key string, displayPrefix string, err error) {
+	token, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	key = ApiKeyPrefix + token
+	return key, key[:apiKeyDisplayLength], nil
+}
+
+// IsApiKey reports whether a credential is an API key rather than a JWT
+func IsApiKey(credential string) bool {
+	return strings.HasPrefix(credential, ApiKeyPrefix)
+}
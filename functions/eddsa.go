@@ -0,0 +1,51 @@
+package functions
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEd25519 implements the EdDSA algorithm of RFC 8037 with Ed25519 keys,
+// which jwt-go does not provide itself
+type SigningMethodEd25519 struct{}
+
+var SigningMethodEdDSA = &SigningMethodEd25519{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *SigningMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *SigningMethodEd25519) Verify(signingString string, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errors.New("EdDSA verification failed")
+	}
+
+	return nil
+}
+
+func (m *SigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
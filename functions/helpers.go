@@ -20,3 +20,8 @@ func MongoPipeline(str string) mongo.Pipeline {
 	}
 	return pipeline
 }
+
+// NormalizeEmail makes emails comparable regardless of case and surrounding spaces. Stored emails are normalized.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
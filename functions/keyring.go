@@ -0,0 +1,258 @@
+package functions
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    interface{}
+	jwk    models.JSONWebKey
+}
+
+// Keyring signs tokens with one private key and verifies them with any of its public keys, picked by the kid header.
+//
+// To rotate keys, make the new key the signing key and keep the previous one as a verification key
+// until the refresh tokens it signed have expired.
+type Keyring struct {
+	signingKid    string
+	signingMethod jwt.SigningMethod
+	signingKey    interface{}
+	keys          map[string]verificationKey
+	// legacySecret verifies HS256 tokens without a kid, and signs tokens when no signing key is configured
+	legacySecret []byte
+}
+
+// NewKeyring builds a keyring from PEM files. The signing key file holds a private key, verification key files
+// hold public or private keys. Without a signing key, tokens are signed with HS256 and the legacy secret.
+func NewKeyring(signingKeyFile string, verificationKeyFiles []string, legacySecret string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string]verificationKey)}
+	if legacySecret != "" {
+		keyring.legacySecret = []byte(legacySecret)
+	}
+
+	if signingKeyFile != "" {
+		privateKey, err := readPEMKey(signingKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		switch privateKey.(type) {
+		case *rsa.PrivateKey, ed25519.PrivateKey:
+		default:
+			return nil, fmt.Errorf("%s: signing key must be an RSA or Ed25519 private key", signingKeyFile)
+		}
+
+		key, err := keyring.addVerificationKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", signingKeyFile, err)
+		}
+
+		keyring.signingKid = key.jwk.Kid
+		keyring.signingMethod = key.method
+		keyring.signingKey = privateKey
+	} else if keyring.legacySecret == nil {
+		return nil, errors.New("no signing key file and no SECRET_KEY configured")
+	}
+
+	for _, file := range verificationKeyFiles {
+		publicKey, err := readPEMKey(file)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = keyring.addVerificationKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return keyring, nil
+}
+
+// KeyConfig tells which keys sign and verify tokens
+type KeyConfig struct {
+	SigningKeyFile       string
+	VerificationKeyFiles []string
+	// SecretKey signs HS256 tokens when there is no signing key file, and keeps verifying them when there is one
+	SecretKey string
+	// RejectSecretKey stops accepting tokens signed with SecretKey once there is a signing key file
+	RejectSecretKey bool
+}
+
+// KeyConfigFromEnv reads JWT_SIGNING_KEY_FILE, the comma separated JWT_VERIFICATION_KEY_FILES and SECRET_KEY.
+// HS256 tokens signed with SECRET_KEY keep being accepted unless JWT_ACCEPT_SECRET_KEY is false.
+func KeyConfigFromEnv() KeyConfig {
+	var verificationKeyFiles []string
+	for _, file := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		if strings.TrimSpace(file) != "" {
+			verificationKeyFiles = append(verificationKeyFiles, strings.TrimSpace(file))
+		}
+	}
+
+	return KeyConfig{
+		SigningKeyFile:       os.Getenv("JWT_SIGNING_KEY_FILE"),
+		VerificationKeyFiles: verificationKeyFiles,
+		SecretKey:            os.Getenv("SECRET_KEY"),
+		RejectSecretKey:      os.Getenv("JWT_ACCEPT_SECRET_KEY") == "false",
+	}
+}
+
+// KeyringFromConfig loads the keyring described by config
+func KeyringFromConfig(config KeyConfig) (*Keyring, error) {
+	legacySecret := config.SecretKey
+	if config.SigningKeyFile != "" && config.RejectSecretKey {
+		legacySecret = ""
+	}
+
+	return NewKeyring(config.SigningKeyFile, config.VerificationKeyFiles, legacySecret)
+}
+
+func readPEMKey(file string) (interface{}, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", file)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	return nil, fmt.Errorf("%s: unsupported PEM block %q", file, block.Type)
+}
+
+func (k *Keyring) addVerificationKey(key interface{}) (verificationKey, error) {
+	var entry verificationKey
+
+	switch typed := key.(type) {
+	case *rsa.PrivateKey:
+		return k.addVerificationKey(&typed.PublicKey)
+	case ed25519.PrivateKey:
+		return k.addVerificationKey(typed.Public())
+	case *rsa.PublicKey:
+		entry = verificationKey{
+			method: jwt.SigningMethodRS256,
+			key:    typed,
+			jwk: models.JSONWebKey{
+				Kty: "RSA",
+				Use: "sig",
+				Alg: jwt.SigningMethodRS256.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(typed.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(typed.E)).Bytes()),
+			},
+		}
+	case ed25519.PublicKey:
+		entry = verificationKey{
+			method: SigningMethodEdDSA,
+			key:    typed,
+			jwk: models.JSONWebKey{
+				Kty: "OKP",
+				Use: "sig",
+				Alg: SigningMethodEdDSA.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(typed),
+			},
+		}
+	default:
+		return entry, errors.New("only RSA and Ed25519 keys are supported")
+	}
+
+	entry.jwk.Kid = thumbprint(entry.jwk)
+	k.keys[entry.jwk.Kid] = entry
+
+	return entry, nil
+}
+
+// thumbprint is the RFC 7638 thumbprint of a key, used as its kid
+func thumbprint(jwk models.JSONWebKey) string {
+	members := map[string]string{"kty": jwk.Kty}
+	if jwk.Kty == "RSA" {
+		members["n"] = jwk.N
+		members["e"] = jwk.E
+	} else {
+		members["crv"] = jwk.Crv
+		members["x"] = jwk.X
+	}
+
+	// encoding/json sorts map keys, which is the canonical member order
+	canonical, _ := json.Marshal(members)
+	digest := sha256.Sum256(canonical)
+
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// Sign signs claims with the signing key, or with HS256 and the legacy secret when there is none
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	if k.signingKey == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.legacySecret)
+	}
+
+	token := jwt.NewWithClaims(k.signingMethod, claims)
+	token.Header["kid"] = k.signingKid
+
+	return token.SignedString(k.signingKey)
+}
+
+// Keyfunc picks the key that verifies a token and refuses tokens whose algorithm does not match that key
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" {
+		if k.legacySecret != nil && token.Method == jwt.SigningMethodHS256 {
+			return k.legacySecret, nil
+		}
+		return nil, errors.New("token has no key id")
+	}
+
+	key, found := k.keys[kid]
+	if !found {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+
+	return key.key, nil
+}
+
+// JWKS returns the public verification keys. The legacy secret is never published.
+func (k *Keyring) JWKS() models.JSONWebKeySet {
+	keySet := models.JSONWebKeySet{Keys: make([]models.JSONWebKey, 0, len(k.keys))}
+	for _, key := range k.keys {
+		keySet.Keys = append(keySet.Keys, key.jwk)
+	}
+
+	sort.Slice(keySet.Keys, func(i, j int) bool {
+		return keySet.Keys[i].Kid < keySet.Keys[j].Kid
+	})
+
+	return keySet
+}
@@ -0,0 +1,147 @@
+package functions
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/rediscache"
+)
+
+// ThrottlePolicy decides how long a key has to wait after a number of failed attempts
+type ThrottlePolicy struct {
+	// FreeAttempts failures are allowed before any delay applies
+	FreeAttempts int64
+	// BaseDelay is the delay after the first failure past the free ones, doubled by every further failure
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAttempts failures lock the key for LockoutDuration
+	LockoutAttempts int64
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered, counted from the first one
+	Window time.Duration
+}
+
+// Delay returns how long to wait after the given number of failures
+func (p ThrottlePolicy) Delay(failures int64) time.Duration {
+	if failures >= p.LockoutAttempts {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+
+	return delay
+}
+
+// LoginThrottle slows down password guessing with exponential backoff and temporary lockouts,
+// tracked both per account and per client IP
+type LoginThrottle struct {
+	store   rediscache.AttemptStore
+	clock   clock.Clock
+	Account ThrottlePolicy
+	IP      ThrottlePolicy
+}
+
+func NewLoginThrottle(store rediscache.AttemptStore, clock clock.Clock) *LoginThrottle {
+	return &LoginThrottle{
+		store: store,
+		clock: clock,
+		Account: ThrottlePolicy{
+			FreeAttempts:    3,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute * 5,
+			LockoutAttempts: 10,
+			LockoutDuration: time.Minute * 15,
+			Window:          time.Hour,
+		},
+		IP: ThrottlePolicy{
+			FreeAttempts:    20,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute * 5,
+			LockoutAttempts: 100,
+			LockoutDuration: time.Hour,
+			Window:          time.Hour,
+		},
+	}
+}
+
+func accountKey(email string) string {
+	return "login:account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+// Check returns how long the caller has to wait before trying to log in again, or zero if it may try now
+func (t *LoginThrottle) Check(ctx context.Context, email string, ip string) time.Duration {
+	now := t.clock.Now()
+	var wait time.Duration
+
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		until, _ := t.store.BlockedUntil(ctx, key)
+		if until.Sub(now) > wait {
+			wait = until.Sub(now)
+		}
+	}
+
+	return wait
+}
+
+// Fail records a failed login and returns how long the caller now has to wait
+func (t *LoginThrottle) Fail(ctx context.Context, email string, ip string) time.Duration {
+	now := t.clock.Now()
+	var wait time.Duration
+
+	keys := []struct {
+		key    string
+		policy ThrottlePolicy
+	}{
+		{accountKey(email), t.Account},
+		{ipKey(ip), t.IP},
+	}
+
+	for _, entry := range keys {
+		failures, err := t.store.Fail(ctx, entry.key, entry.policy.Window)
+		if err != nil {
+			continue
+		}
+
+		delay := entry.policy.Delay(failures)
+		if delay == 0 {
+			continue
+		}
+
+		t.store.Block(ctx, entry.key, now.Add(delay))
+		if delay > wait {
+			wait = delay
+		}
+	}
+
+	return wait
+}
+
+// Succeed forgets the failed logins of the account
+func (t *LoginThrottle) Succeed(ctx context.Context, email string) error {
+	return t.store.Reset(ctx, accountKey(email))
+}
+
+// Unlock lifts the lockout of an account
+func (t *LoginThrottle) Unlock(ctx context.Context, email string) error {
+	return t.store.Reset(ctx, accountKey(email))
+}
+
+// UnlockIP lifts the lockout of a client IP
+func (t *LoginThrottle) UnlockIP(ctx context.Context, ip string) error {
+	return t.store.Reset(ctx, ipKey(ip))
+}
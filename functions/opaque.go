@@ -0,0 +1,25 @@
+package functions
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateOpaqueToken returns a random url safe token for links sent to users
+func GenerateOpaqueToken() (string, error) {
+	buffer := make([]byte, 32)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// HashOpaqueToken returns the digest under which an opaque token is stored
+func HashOpaqueToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,50 @@
+package functions
+
+import (
+	"context"
+	"time"
+)
+
+// RevokeToken revokes a single token until it expires
+func (t *TokenIssuer) RevokeToken(tokenId string, expiresAt int64) error {
+	if tokenId == "" {
+		return nil
+	}
+
+	return t.revocations.RevokeToken(context.Background(), tokenId, time.Unix(expiresAt, 0))
+}
+
+// RevokeAllUserTokens revokes every token that has been issued to the user so far, and ends all of their sessions
+func (t *TokenIssuer) RevokeAllUserTokens(userId string) error {
+	now := t.clock.Now()
+	err := t.revocations.RevokeUser(context.Background(), userId, now, now.Add(RefreshTokenLifetime))
+	if err != nil {
+		return err
+	}
+
+	return t.sessions.RevokeAllByUser(context.Background(), userId)
+}
+
+// IsRevoked reports whether the token was revoked on its own, with its session or together with all tokens of its user.
+// Callers refuse the token when the revocations cannot be read.
+func (t *TokenIssuer) IsRevoked(claims *SignedDetails) (bool, error) {
+	ctx := context.Background()
+
+	for _, id := range []string{claims.Id, claims.Family} {
+		if id == "" {
+			continue
+		}
+
+		revoked, err := t.revocations.IsTokenRevoked(ctx, id)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	revokedBefore, err := t.revocations.UserRevokedBefore(ctx, claims.Uid)
+	if err != nil || revokedBefore.IsZero() {
+		return false, err
+	}
+
+	return !claims.issuedAt().After(revokedBefore), nil
+}
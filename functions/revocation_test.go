@@ -0,0 +1,96 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/rediscache"
+	"github.com/hauchongtang/splatbackend/repository"
+)
+
+// failingRevocations cannot be read, like a revocation store whose server is down
+type failingRevocations struct {
+	rediscache.RevocationStore
+}
+
+var errRevocationsDown = errors.New("revocations are unreachable")
+
+func (failingRevocations) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	return false, errRevocationsDown
+}
+
+func (failingRevocations) UserRevokedBefore(ctx context.Context, userId string) (time.Time, error) {
+	return time.Time{}, errRevocationsDown
+}
+
+func newTestIssuer(t *testing.T, revocations rediscache.RevocationStore, clock clock.Clock) *TokenIssuer {
+	keys, err := NewKeyring("", nil, "test secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewTokenIssuer(keys, revocations, repository.NewMemorySessionStore(clock), clock)
+}
+
+// claimsOf issues an access token to user at the time of the clock, and reads it back
+func claimsOf(t *testing.T, issuer *TokenIssuer, user string) *SignedDetails {
+	t.Helper()
+
+	token, _, err := issuer.GenerateFamilyTokens("user@example.com", "Test", "User", user, "", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, msg := issuer.ValidateToken(token)
+	if msg != "" {
+		t.Fatal(msg)
+	}
+	return claims
+}
+
+func TestRevocationWithinASecond(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 2, 3, 4, 5, 100*int(time.Millisecond), time.UTC))
+	issuer := newTestIssuer(t, rediscache.NewMemoryRevocationStore(fake), fake)
+
+	before := claimsOf(t, issuer, "user")
+	fake.Advance(100 * time.Millisecond)
+	err := issuer.RevokeAllUserTokens("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The login following a revocation is in the same second, and must keep working
+	fake.Advance(100 * time.Millisecond)
+	after := claimsOf(t, issuer, "user")
+	if before.IssuedAt != after.IssuedAt {
+		t.Fatal("the tokens are not issued in the same second")
+	}
+
+	for _, test := range []struct {
+		name   string
+		claims *SignedDetails
+		want   bool
+	}{{"issued before", before, true}, {"issued after", after, false}} {
+		revoked, err := issuer.IsRevoked(test.claims)
+		if err != nil || revoked != test.want {
+			t.Errorf("the token %s the revocation got %v, %v, want revoked %v", test.name, revoked, err, test.want)
+		}
+	}
+
+	// Tokens from before Issued_at_ns existed only tell their second, and are revoked with everything in it
+	after.Issued_at_ns = 0
+	if revoked, _ := issuer.IsRevoked(after); !revoked {
+		t.Error("a token only telling the second of the revocation was not revoked")
+	}
+}
+
+func TestRevocationStoreErrorsRefuseTokens(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	issuer := newTestIssuer(t, failingRevocations{}, fake)
+
+	revoked, err := issuer.IsRevoked(claimsOf(t, issuer, "user"))
+	if !errors.Is(err, errRevocationsDown) {
+		t.Errorf("got revoked %v and error %v, want the error of the store", revoked, err)
+	}
+}
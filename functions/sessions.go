@@ -0,0 +1,59 @@
+package functions
+
+import (
+	"context"
+	"log"
+
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StartSession opens a new session for a user whose credentials have all been checked and returns its token pair.
+// The session id is the Family claim of both tokens.
+func (t *TokenIssuer) StartSession(ctx context.Context, user models.User, device string, ip string, userAgent string) (signedToken string, signedRefreshToken string, err error) {
+	sessionId := primitive.NewObjectID().Hex()
+
+	signedToken, signedRefreshToken, err = t.GenerateFamilyTokens(*user.Email, *user.First_name, *user.Last_name, user.User_id, user.Role(), sessionId)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := t.clock.Now()
+	err = t.sessions.Create(ctx, models.Session{
+		ID:                 primitive.NewObjectID(),
+		Session_id:         sessionId,
+		User_id:            user.User_id,
+		Refresh_token_hash: HashOpaqueToken(signedRefreshToken),
+		Device:             device,
+		Ip:                 ip,
+		User_agent:         userAgent,
+		Created_at:         now,
+		Last_seen_at:       now,
+		Expires_at:         now.Add(RefreshTokenLifetime),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return signedToken, signedRefreshToken, nil
+}
+
+// RevokeSession ends a session of the user together with the tokens issued for it.
+// It returns repository.ErrNotFound when the user has no such active session.
+func (t *TokenIssuer) RevokeSession(ctx context.Context, userId string, sessionId string) error {
+	err := t.sessions.Revoke(ctx, userId, sessionId)
+	if err != nil {
+		return err
+	}
+
+	// Access tokens are not stored, so they are revoked through the session id they carry
+	return t.RevokeToken(sessionId, t.clock.Now().Add(AccessTokenLifetime).Unix())
+}
+
+// TouchSession records that a session was just used
+func (t *TokenIssuer) TouchSession(ctx context.Context, sessionId string) {
+	err := t.sessions.Touch(ctx, sessionId, t.clock.Now())
+	if err != nil {
+		log.Default().Println(err, "Unable to record session use")
+	}
+}
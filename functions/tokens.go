@@ -1,18 +1,16 @@
 package functions
 
 import (
-	"context"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/rediscache"
 	"github.com/hauchongtang/splatbackend/repository"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // SignedDetails
@@ -22,34 +20,107 @@ type SignedDetails struct {
 	Last_name  string
 	Uid        string
 	User_type  string
+	Token_type string
+	Family     string // session the token belongs to
+	Tenant     string // tenant the token was issued by, empty without tenants
+	// Issued_at_ns is when the token was issued to the nanosecond, as it may be in the same second as a revocation
+	Issued_at_ns int64
 	jwt.StandardClaims
 }
 
-var userCollection *mongo.Collection = repository.OpenCollection(repository.Client, "users")
+// issuedAt is when the token was issued. Tokens from before Issued_at_ns existed only tell the second.
+func (c *SignedDetails) issuedAt() time.Time {
+	if c.Issued_at_ns != 0 {
+		return time.Unix(0, c.Issued_at_ns)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// Token types carried in the Token_type claim
+const (
+	AccessToken    = "access"
+	RefreshToken   = "refresh"
+	ChallengeToken = "challenge"
+)
 
-var SECRET_KEY string = os.Getenv("SECRET_KEY")
+// Lifetimes of the issued tokens
+const (
+	AccessTokenLifetime    = time.Hour * time.Duration(24)
+	RefreshTokenLifetime   = time.Hour * time.Duration(168)
+	ChallengeTokenLifetime = time.Minute * time.Duration(5)
+)
 
-// GenerateAllTokens generates both teh detailed token and refresh token
-func GenerateAllTokens(email string, firstName string, lastName string, uid string) (signedToken string, signedRefreshToken string, err error) {
+// TokenIssuer signs and validates the tokens of users, and revokes them together with the sessions they belong to
+type TokenIssuer struct {
+	keys        *Keyring
+	revocations rediscache.RevocationStore
+	sessions    repository.SessionStore
+	clock       clock.Clock
+	tenant      string
+}
+
+func NewTokenIssuer(keys *Keyring, revocations rediscache.RevocationStore, sessions repository.SessionStore, clock clock.Clock) *TokenIssuer {
+	return &TokenIssuer{
+		keys:        keys,
+		revocations: revocations,
+		sessions:    sessions,
+		clock:       clock,
+	}
+}
+
+// ForTenant returns an issuer whose tokens are only accepted by issuers of the same tenant
+func (t *TokenIssuer) ForTenant(tenant string) *TokenIssuer {
+	scoped := *t
+	scoped.tenant = tenant
+	return &scoped
+}
+
+// JWKS returns the public keys that verify the issued tokens
+func (t *TokenIssuer) JWKS() models.JSONWebKeySet {
+	return t.keys.JWKS()
+}
+
+// GenerateFamilyTokens generates a token pair for the given session.
+// Every login starts a new session and every refresh rotates the refresh token within it.
+func (t *TokenIssuer) GenerateFamilyTokens(email string, firstName string, lastName string, uid string, userType string, family string) (signedToken string, signedRefreshToken string, err error) {
+	now := t.clock.Now()
 	claims := &SignedDetails{
-		Email:      email,
-		First_name: firstName,
-		Last_name:  lastName,
-		Uid:        uid,
+		Email:        email,
+		First_name:   firstName,
+		Last_name:    lastName,
+		Uid:          uid,
+		User_type:    userType,
+		Token_type:   AccessToken,
+		Family:       family,
+		Tenant:       t.tenant,
+		Issued_at_ns: now.UnixNano(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			ExpiresAt: now.Local().Add(AccessTokenLifetime).Unix(),
+			IssuedAt:  now.Unix(),
+			Id:        primitive.NewObjectID().Hex(),
 		},
 	}
 
 	refreshClaims := &SignedDetails{
+		Uid:          uid,
+		Token_type:   RefreshToken,
+		Family:       family,
+		Tenant:       t.tenant,
+		Issued_at_ns: now.UnixNano(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			ExpiresAt: now.Local().Add(RefreshTokenLifetime).Unix(),
+			IssuedAt:  now.Unix(),
+			Id:        primitive.NewObjectID().Hex(),
 		},
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+	token, err := t.keys.Sign(claims)
+	if err != nil {
+		log.Panic(err)
+		return
+	}
 
+	refreshToken, err := t.keys.Sign(refreshClaims)
 	if err != nil {
 		log.Panic(err)
 		return
@@ -58,14 +129,32 @@ func GenerateAllTokens(email string, firstName string, lastName string, uid stri
 	return token, refreshToken, err
 }
 
-//ValidateToken validates the jwt token
-func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
-	token, err := jwt.ParseWithClaims(
+// GenerateChallengeToken generates the short lived token handed out after the password step of a two factor login.
+// It can only be exchanged for real tokens together with a second factor.
+func (t *TokenIssuer) GenerateChallengeToken(uid string) (signedToken string, err error) {
+	now := t.clock.Now()
+	claims := &SignedDetails{
+		Uid:          uid,
+		Token_type:   ChallengeToken,
+		Tenant:       t.tenant,
+		Issued_at_ns: now.UnixNano(),
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: now.Local().Add(ChallengeTokenLifetime).Unix(),
+			IssuedAt:  now.Unix(),
+			Id:        primitive.NewObjectID().Hex(),
+		},
+	}
+
+	return t.keys.Sign(claims)
+}
+
+//ValidateToken validates the jwt token. Its lifetime is checked against the clock of the issuer rather than by jwt-go.
+func (t *TokenIssuer) ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(
 		signedToken,
 		&SignedDetails{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(SECRET_KEY), nil
-		},
+		t.keys.Keyfunc,
 	)
 
 	if err != nil {
@@ -74,47 +163,25 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	}
 
 	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
+	if !ok || !token.Valid {
 		msg = fmt.Sprintf("the token is invalid")
-		msg = err.Error()
 		return
 	}
 
-	return claims, msg
-}
-
-//UpdateAllTokens renews the user tokens when they login
-func UpdateAllTokens(signedToken string, signedRefreshToken string, userId string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-	var updateObj primitive.D
-
-	updateObj = append(updateObj, bson.E{"token", signedToken})
-	updateObj = append(updateObj, bson.E{"refresh_token", signedRefreshToken})
-
-	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateObj = append(updateObj, bson.E{"updated_at", Updated_at})
-
-	upsert := true
-	filter := bson.M{"user_id": userId}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
+	now := t.clock.Now().Unix()
+	switch {
+	case !claims.VerifyExpiresAt(now, false):
+		return nil, "token is expired"
+	case !claims.VerifyIssuedAt(now, false):
+		return nil, "Token used before issued"
+	case !claims.VerifyNotBefore(now, false):
+		return nil, "token is not valid yet"
 	}
 
-	_, err := userCollection.UpdateOne(
-		ctx,
-		filter,
-		bson.D{
-			{"$set", updateObj},
-		},
-		&opt,
-	)
-	defer cancel()
-
-	if err != nil {
-		log.Panic(err)
-		return
+	// Tenants share signing keys, so a token of one tenant must not open another
+	if claims.Tenant != t.tenant {
+		return nil, "the token belongs to another tenant"
 	}
 
-	return
+	return claims, msg
 }
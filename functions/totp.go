@@ -0,0 +1,115 @@
+package functions
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters shared with authenticator apps through the otpauth URI
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is the number of periods before and after the current one that are still accepted
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random base32 encoded 160 bit secret
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPStep returns the RFC 6238 time step containing t
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// TOTPCode computes the code of a base32 secret for a time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation from RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// VerifyTOTP checks a code against the steps around now and returns the step it matched
+func VerifyTOTP(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	current := TOTPStep(now)
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// TOTPURI returns the otpauth URI that authenticator apps enroll from, usually shown as a QR code
+func TOTPURI(issuer string, account string, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// GenerateRecoveryCodes returns count random single use codes formatted as xxxxx-xxxxx
+func GenerateRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, 0, count)
+
+	for i := 0; i < count; i++ {
+		buffer := make([]byte, 7)
+		_, err := rand.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(buffer))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode makes recovery codes comparable regardless of case and dashes
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
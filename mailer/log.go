@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer appends emails to a file, or to the log when no file is given, instead of delivering them.
+// Use it to exercise email flows locally without a mail server.
+type LogMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	entry := fmt.Sprintf("Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC3339), message.To, message.Subject, message.Body)
+
+	if m.Path == "" {
+		log.Default().Print("Email not delivered, no mail server configured\n", entry)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(entry)
+	return err
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"context"
+	"os"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// FromEnv picks the mailer configured by MAIL_DRIVER. Without a driver, SMTP is used when SMTP_HOST is set,
+// otherwise emails are only written to the log so that they can be read locally.
+func FromEnv() Mailer {
+	driver := os.Getenv("MAIL_DRIVER")
+	if driver == "" && os.Getenv("SMTP_HOST") != "" {
+		driver = "smtp"
+	}
+
+	if driver == "smtp" {
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	}
+
+	return &LogMailer{Path: os.Getenv("MAIL_LOG_FILE")}
+}
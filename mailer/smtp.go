@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPMailer sends emails through an SMTP relay
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	port := m.Port
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(m.Host, port), auth, m.From, []string{message.To}, m.format(message))
+}
+
+func (m *SMTPMailer) format(message Message) []byte {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "From: %s\r\n", m.From)
+	fmt.Fprintf(&builder, "To: %s\r\n", message.To)
+	fmt.Fprintf(&builder, "Subject: %s\r\n", message.Subject)
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	return []byte(builder.String())
+}
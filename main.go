@@ -1,36 +1,14 @@
 package main
 
 import (
-	"os"
+	"context"
+	"flag"
+	"log"
 
-	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/app"
 	_ "github.com/hauchongtang/splatbackend/docs"
-	"github.com/hauchongtang/splatbackend/middleware"
-	"github.com/hauchongtang/splatbackend/routes"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Headers", "*")
-		c.Header("Access-Control-Allow-Methods", "PUT")
-		/*
-		   c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		   c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		   c.Writer.Header().Set("Access-Control-Allow-Headers", "access-control-allow-origin, access-control-allow-headers")
-		   c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS, PATCH")
-		*/
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	}
-}
-
 // @title     SplatApp Backend API
 // @version 1.0
 // @description This is the backend service for splatapp at https://github.com/hauchongtang/splatbackend
@@ -39,29 +17,46 @@ func CORSMiddleware() gin.HandlerFunc {
 // @license.url https://opensource.org/licenses/MIT
 
 // @BasePath /
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name Authorization
+// @description Access token as "Bearer <token>". The token header is still accepted.
 // @query.collection.format multi
 func main() {
-	port := os.Getenv("PORT")
+	dataDir := flag.String("data-dir", "", "keep all data in SQLite in this directory, instead of using MongoDB and Redis")
+	flag.Parse()
 
-	if port == "" {
-		port = "8000"
+	config, err := app.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dataDir != "" {
+		config = config.SelfContained(*dataDir)
+	}
+
+	// "splatbackend migrate" only applies the pending schema migrations
+	if flag.Arg(0) == "migrate" {
+		err := app.Migrate(context.Background(), config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	router := gin.Default()
-	router.Use(CORSMiddleware())
-	router.Use(gin.Logger())
-	routes.AuthRoutes(router)
-	routes.UserRoutes(router)
-	routes.TaskRoutes(router)
-	routes.StatsRoutes(router)
-	routes.DocsRoutes(router)
+	if len(config.Tenants) > 0 {
+		tenants, err := app.ConnectTenants(context.Background(), config)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Fatal(app.NewTenants(config, tenants).Run())
+	}
 
-	router.GET("/splat/api", middleware.Authentication(), func(c *gin.Context) {
-		c.JSON(
-			200,
-			gin.H{"success": "Access granted"},
-		)
-	})
+	deps, err := app.Connect(context.Background(), config)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	router.Run(":" + port)
+	log.Fatal(app.New(config, deps).Run())
 }
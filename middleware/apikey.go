@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	functions "github.com/hauchongtang/splatbackend/functions"
+)
+
+func hasAnyScope(granted []string, accepted []string) bool {
+	for _, scope := range accepted {
+		for _, grant := range granted {
+			if grant == scope {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// authenticateApiKey checks an API key against the scopes accepted by the route and stores its owner in the context.
+// Routes that accept no scope can only be used with an access token.
+func (a *Auth) authenticateApiKey(c *gin.Context, key string, scopes []string) bool {
+	if len(scopes) == 0 {
+		unauthorized(c, "invalid_token", "API keys cannot be used for this resource")
+		return false
+	}
+
+	ctx := c.Request.Context()
+
+	apiKey, err := a.apiKeys.FindByHash(ctx, functions.HashOpaqueToken(key))
+	if err != nil {
+		unauthorized(c, "invalid_token", "invalid API key")
+		return false
+	}
+
+	if !hasAnyScope(apiKey.Scopes, scopes) {
+		c.Header("WWW-Authenticate", `Bearer realm="`+authRealm+`", error="insufficient_scope"`)
+		c.JSON(http.StatusForbidden, gin.H{"error": "the API key is missing a scope for this resource"})
+		c.Abort()
+		return false
+	}
+
+	// The role is read from the user so that role changes apply to existing keys
+	user, err := a.users.FindUserById(ctx, apiKey.User_id)
+	if err != nil {
+		unauthorized(c, "invalid_token", "invalid API key")
+		return false
+	}
+
+	err = a.apiKeys.Touch(ctx, apiKey.Key_id, a.clock.Now())
+	if err != nil {
+		log.Default().Println(err, "Unable to record API key use")
+	}
+
+	if user.Email != nil {
+		c.Set("email", *user.Email)
+	}
+	if user.First_name != nil {
+		c.Set("first_name", *user.First_name)
+	}
+	if user.Last_name != nil {
+		c.Set("last_name", *user.Last_name)
+	}
+	c.Set("uid", user.User_id)
+	c.Set("user_type", user.Role())
+	c.Set("api_key_id", apiKey.Key_id)
+	c.Set("scopes", apiKey.Scopes)
+
+	return true
+}
@@ -1,35 +1,147 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
+	"strings"
 
+	"github.com/hauchongtang/splatbackend/clock"
 	functions "github.com/hauchongtang/splatbackend/functions"
+	"github.com/hauchongtang/splatbackend/models"
+	"github.com/hauchongtang/splatbackend/repository"
 
 	"github.com/gin-gonic/gin"
 )
 
-// validate token and gives permission to users
-func Authentication() gin.HandlerFunc {
+const authRealm = "splat"
+
+// Auth authenticates requests with access tokens or API keys, and checks who owns the resources they target
+type Auth struct {
+	tokens  *functions.TokenIssuer
+	users   repository.UserStore
+	apiKeys repository.ApiKeyStore
+	tasks   repository.TaskStore
+	clock   clock.Clock
+}
+
+func NewAuth(tokens *functions.TokenIssuer, users repository.UserStore, apiKeys repository.ApiKeyStore, tasks repository.TaskStore, clock clock.Clock) *Auth {
+	return &Auth{
+		tokens:  tokens,
+		users:   users,
+		apiKeys: apiKeys,
+		tasks:   tasks,
+		clock:   clock,
+	}
+}
+
+// bearerToken reads the token from "Authorization: Bearer <jwt>", falling back to the older token header
+func bearerToken(c *gin.Context) string {
+	authorization := c.GetHeader("Authorization")
+	if scheme, token, found := strings.Cut(authorization, " "); found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return c.GetHeader("token")
+}
+
+// unauthorized responds with 401 and a RFC 6750 challenge. A request without credentials gets no error code.
+func unauthorized(c *gin.Context, errorCode string, description string) {
+	challenge := `Bearer realm="` + authRealm + `"`
+	if errorCode != "" {
+		challenge += `, error="` + errorCode + `", error_description="` + strings.ReplaceAll(description, `"`, `'`) + `"`
+	}
+
+	c.Header("WWW-Authenticate", challenge)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": description})
+	c.Abort()
+}
+
+// authenticate checks the access token or API key of the request and stores who made it in the context.
+// It returns false after responding with 401 when the credential is not acceptable.
+func (a *Auth) authenticate(c *gin.Context, token string, scopes []string) bool {
+	if functions.IsApiKey(token) {
+		return a.authenticateApiKey(c, token, scopes)
+	}
+
+	claims, err := a.tokens.ValidateToken(token)
+	if err != "" {
+		unauthorized(c, "invalid_token", err)
+		return false
+	}
+
+	if claims.Token_type != "" && claims.Token_type != functions.AccessToken { // Tokens issued before token types existed are access tokens
+		unauthorized(c, "invalid_token", "only access tokens can be used for authentication")
+		return false
+	}
+
+	revoked, revocationErr := a.tokens.IsRevoked(claims)
+	if revocationErr != nil {
+		log.Default().Println(revocationErr, "Unable to check token revocation")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "unable to check whether the token was revoked"})
+		return false
+	}
+	if revoked {
+		unauthorized(c, "invalid_token", "token has been revoked")
+		return false
+	}
+
+	c.Set("email", claims.Email)
+	c.Set("first_name", claims.First_name)
+	c.Set("last_name", claims.Last_name)
+	c.Set("uid", claims.Uid)
+	c.Set("user_type", userType(claims))
+	c.Set("token_id", claims.Id)
+	c.Set("token_expires_at", claims.ExpiresAt)
+
+	if claims.Family != "" { // Tokens issued before sessions existed belong to none
+		c.Set("session_id", claims.Family)
+		a.tokens.TouchSession(c.Request.Context(), claims.Family)
+	}
+
+	return true
+}
+
+// validate token and gives permission to users.
+// API keys holding one of the given scopes are accepted too, and without scopes only access tokens are.
+func (a *Auth) Authentication(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.Request.Header.Get("token")
+		token := bearerToken(c)
 		if token == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No auth header provided"})
-			c.Abort()
+			unauthorized(c, "", "No auth header provided")
 			return
 		}
 
-		claims, err := functions.ValidateToken(token)
-		if err != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			c.Abort()
+		if !a.authenticate(c, token, scopes) {
 			return
 		}
 
-		c.Set("email", claims.Email)
-		c.Set("first_name", claims.First_name)
-		c.Set("last_name", claims.Last_name)
-		c.Set("uid", claims.Uid)
+		c.Next()
+	}
+}
+
+// OptionalAuthentication lets anonymous requests through, and authenticates the others like Authentication.
+// A token that is sent but invalid is still refused, so that clients notice expired tokens.
+func (a *Auth) OptionalAuthentication(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token != "" && !a.authenticate(c, token, scopes) {
+			return
+		}
 
 		c.Next()
 	}
 }
+
+// IsAuthenticated tells whether the request carried a valid access token or API key
+func IsAuthenticated(c *gin.Context) bool {
+	return c.GetString("uid") != ""
+}
+
+// tokens issued before roles existed carry no role
+func userType(claims *functions.SignedDetails) string {
+	if claims.User_type == "" {
+		return models.RoleUser
+	}
+
+	return claims.User_type
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheControl sets the Cache-Control header of reads, from the policy of their route, such as "/cached/users/:id".
+// Routes without a policy get no header.
+func CacheControl(policies map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			if policy, found := policies[c.FullPath()]; found {
+				c.Header("Cache-Control", policy)
+			}
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func CORSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		c.Header("Access-Control-Allow-Origin", "*")
+		// The wildcard does not cover Authorization, so it is listed explicitly
+		c.Header("Access-Control-Allow-Headers", "*, Authorization")
+		c.Header("Access-Control-Expose-Headers", "WWW-Authenticate, Retry-After, ETag, Last-Modified")
+		c.Header("Access-Control-Allow-Methods", "PUT")
+		/*
+		   c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		   c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		   c.Writer.Header().Set("Access-Control-Allow-Headers", "access-control-allow-origin, access-control-allow-headers")
+		   c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS, PATCH")
+		*/
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// OwnerResolver finds the id of the user that owns the resource targeted by the request
+type OwnerResolver func(c *gin.Context) (string, error)
+
+// UserParamOwner treats the user in the :id path parameter as the owner
+func UserParamOwner(c *gin.Context) (string, error) {
+	return c.Param("id"), nil
+}
+
+// TaskParamOwner looks up the user owning the task in the :id path parameter
+func (a *Auth) TaskParamOwner(c *gin.Context) (string, error) {
+	task, err := a.tasks.FindTaskById(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return "", err
+	}
+
+	return task.User_id, nil
+}
+
+// RequireOwnership only lets through the owner of the targeted resource, or an admin.
+// It must run after Authentication.
+func RequireOwnership(resolve OwnerResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_type") == models.RoleAdmin {
+			c.Next()
+			return
+		}
+
+		ownerId, err := resolve(c)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "resource not found"})
+			c.Abort()
+			return
+		}
+
+		if ownerId == "" || ownerId != c.GetString("uid") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized to access this resource"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
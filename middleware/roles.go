@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	functions "github.com/hauchongtang/splatbackend/functions"
+)
+
+// RequireRole only lets through users whose token carries one of the given roles.
+// It must run after Authentication.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, role := range roles {
+			if functions.CheckUserType(c, role) == nil {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized to access this resource"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scopes that can be granted to an API key
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+	ScopeStatsRead  = "stats:read"
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+// IsValidScope reports whether scope is one of the known scopes
+func IsValidScope(scope string) bool {
+	switch scope {
+	case ScopeTasksRead, ScopeTasksWrite, ScopeStatsRead, ScopeUsersRead, ScopeUsersWrite:
+		return true
+	}
+
+	return false
+}
+
+// ApiKey is a named credential for scripts. Only the hash of the key is stored.
+type ApiKey struct {
+	ID           primitive.ObjectID `bson:"_id" json:"-"`
+	Key_id       string             `json:"key_id"`
+	User_id      string             `json:"user_id"`
+	Name         string             `json:"name"`
+	Scopes       []string           `json:"scopes"`
+	Prefix       string             `json:"prefix"`
+	Key_hash     string             `json:"-"`
+	Created_at   time.Time          `json:"created_at"`
+	Last_used_at *time.Time         `json:"last_used_at"`
+}
+
+type CreateApiKeyModel struct {
+	Name   *string  `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+}
+
+// CreatedApiKey holds the plain key, which is only shown once
+type CreatedApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
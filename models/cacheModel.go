@@ -0,0 +1,15 @@
+package models
+
+// CacheKeyInfo describes a key of the cache
+type CacheKeyInfo struct {
+	Key         string  `json:"key"`
+	Family      string  `json:"family"`
+	Ttl_seconds float64 `json:"ttl_seconds"`
+	Size_bytes  int     `json:"size_bytes"`
+}
+
+// CacheFamilyResult tells how many keys of a family of cache keys were purged or warmed
+type CacheFamilyResult struct {
+	Family string `json:"family"`
+	Keys   int    `json:"keys"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// Kinds of events, telling what changed
+const (
+	UserCreated = "user.created"
+	UserUpdated = "user.updated"
+	UserDeleted = "user.deleted"
+	TaskCreated = "task.created"
+	TaskUpdated = "task.updated"
+)
+
+// Event tells that a user or a task of a user changed
+type Event struct {
+	Kind    string
+	User_id string
+}
@@ -3,4 +3,5 @@ package models
 type LoginModel struct {
 	Email    *string `json:"email" validate:"email,required"`
 	Password *string `json:"password" validate:"required,min=6"`
+	Device   *string `json:"device"`
 }
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Identity links a user to their account at an OpenID Connect provider
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	Linked_at time.Time `json:"linked_at"`
+}
+
+// OIDCLoginState is kept between sending a user to a provider and the provider sending them back
+type OIDCLoginState struct {
+	Provider      string `json:"provider"`
+	Code_verifier string `json:"code_verifier"`
+	Nonce         string `json:"nonce"`
+}
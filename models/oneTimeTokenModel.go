@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purposes of single use tokens
+const (
+	PasswordResetPurpose     = "password_reset"
+	EmailVerificationPurpose = "email_verification"
+)
+
+// OneTimeToken is a hashed single use token emailed to a user, such as a password reset token
+type OneTimeToken struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Token_hash string             `json:"token_hash"`
+	User_id    string             `json:"user_id"`
+	Purpose    string             `json:"purpose"`
+	Expires_at time.Time          `json:"expires_at"`
+	Used_at    *time.Time         `json:"used_at"`
+	Created_at time.Time          `json:"created_at"`
+}
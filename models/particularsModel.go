@@ -0,0 +1,11 @@
+package models
+
+// UserParticulars holds the changes to the particulars of a user. Nil fields are left as they are.
+type UserParticulars struct {
+	First_name     *string
+	Last_name      *string
+	Email          *string
+	Email_verified *bool
+	// Password is the hash of the new password
+	Password *string
+}
@@ -0,0 +1,10 @@
+package models
+
+type ForgotPasswordModel struct {
+	Email *string `json:"email" validate:"email,required"`
+}
+
+type ResetPasswordModel struct {
+	Token    *string `json:"token" validate:"required"`
+	Password *string `json:"password" validate:"required,min=6"`
+}
@@ -0,0 +1,5 @@
+package models
+
+type RefreshModel struct {
+	Refresh_token *string `json:"refresh_token" validate:"required"`
+}
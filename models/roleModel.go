@@ -0,0 +1,13 @@
+package models
+
+// Roles that can be held by a user, carried in the User_type claim of their tokens
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
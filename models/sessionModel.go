@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is one login of a user on one device. Each session has its own refresh token, of which only the hash is stored.
+type Session struct {
+	ID                 primitive.ObjectID `bson:"_id" json:"-"`
+	Session_id         string             `json:"session_id"`
+	User_id            string             `json:"user_id"`
+	Refresh_token_hash string             `json:"-"`
+	Device             string             `json:"device"`
+	Ip                 string             `json:"ip"`
+	User_agent         string             `json:"user_agent"`
+	Created_at         time.Time          `json:"created_at"`
+	Last_seen_at       time.Time          `json:"last_seen_at"`
+	Expires_at         time.Time          `json:"expires_at"`
+	Revoked_at         *time.Time         `json:"-"`
+
+	// Current marks the session of the request listing the sessions
+	Current bool `bson:"-" json:"current"`
+}
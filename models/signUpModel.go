@@ -3,6 +3,6 @@ package models
 type SignUp struct {
 	First_name *string `json:"first_name" validate:"required,min=1,max=100"`
 	Last_name  *string `json:"last_name" validate:"required,min=1,max=100"`
-	Email      *string `json:"email"`
-	Password   *string `json:"password"`
+	Email      *string `json:"email" validate:"email,required"`
+	Password   *string `json:"password" validate:"required,min=6"`
 }
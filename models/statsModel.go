@@ -0,0 +1,32 @@
+package models
+
+import "sort"
+
+// ModuleKey groups tasks by module
+type ModuleKey struct {
+	Module_code *string `json:"module_code"`
+}
+
+// ModulePopularity counts the tasks done on a module
+type ModulePopularity struct {
+	ID    ModuleKey `bson:"_id" json:"_id"`
+	Count int       `json:"count"`
+}
+
+// SortModulePopularity puts the most popular modules first, and modules as popular in the order of their codes, so that
+// the same counts always read the same
+func SortModulePopularity(results []ModulePopularity) {
+	code := func(i int) string {
+		if results[i].ID.Module_code == nil {
+			return ""
+		}
+		return *results[i].ID.Module_code
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Count != results[j].Count {
+			return results[i].Count > results[j].Count
+		}
+		return code(i) < code(j)
+	})
+}
@@ -0,0 +1,6 @@
+package models
+
+type TokenResult struct {
+	Token         string `json:"token"`
+	Refresh_token string `json:"refresh_token"`
+}
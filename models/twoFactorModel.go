@@ -0,0 +1,24 @@
+package models
+
+type TwoFactorEnrollment struct {
+	Otpauth_uri    string   `json:"otpauth_uri"`
+	Secret         string   `json:"secret"`
+	Recovery_codes []string `json:"recovery_codes"`
+}
+
+type TwoFactorCodeModel struct {
+	Code          *string `json:"code"`
+	Recovery_code *string `json:"recovery_code"`
+}
+
+type TwoFactorChallenge struct {
+	Two_factor_required bool   `json:"two_factor_required"`
+	Challenge_token     string `json:"challenge_token"`
+}
+
+type TwoFactorLoginModel struct {
+	Challenge_token *string `json:"challenge_token" validate:"required"`
+	Code            *string `json:"code"`
+	Recovery_code   *string `json:"recovery_code"`
+	Device          *string `json:"device"`
+}
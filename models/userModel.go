@@ -8,16 +8,41 @@ import (
 
 //User is the model that governs all notes objects retrived or inserted into the DB
 type User struct {
-	ID            primitive.ObjectID `bson:"_id"`
-	First_name    *string            `json:"first_name" validate:"required,min=1,max=100"`
-	Last_name     *string            `json:"last_name" validate:"required,min=1,max=100"`
-	Password      *string            `json:"Password" validate:"required,min=6"`
-	Email         *string            `json:"email" validate:"email,required"`
-	Token         *string            `json:"token"`
-	Refresh_token *string            `json:"refresh_token"`
-	Created_at    time.Time          `json:"created_at"`
-	Updated_at    time.Time          `json:"updated_at"`
-	User_id       string             `json:"user_id"`
-	Points        int                `json:"points"`
-	Timetable     string             `json:"timetable"`
+	ID             primitive.ObjectID `bson:"_id"`
+	First_name     *string            `json:"first_name" validate:"required,min=1,max=100"`
+	Last_name      *string            `json:"last_name" validate:"required,min=1,max=100"`
+	Password       *string            `json:"-" validate:"required,min=6"`
+	Email          *string            `json:"email" validate:"email,required"`
+	Email_verified *bool              `json:"email_verified"`
+	Token          *string            `json:"-"`
+	Refresh_token  *string            `json:"-"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+	User_id        string             `json:"user_id"`
+	Points         int                `json:"points"`
+	Timetable      string             `json:"timetable"`
+	User_type      string             `json:"user_type"`
+
+	Two_factor_enabled     bool     `json:"two_factor_enabled"`
+	Totp_secret            *string  `json:"-"`
+	Totp_last_step         int64    `json:"-"`
+	Recovery_codes         []string `json:"-"`
+	Totp_pending_secret    *string  `json:"-"`
+	Pending_recovery_codes []string `json:"-"`
+
+	Identities []Identity `json:"-"`
+}
+
+// Role returns the role of the user. Users created before roles existed are plain users.
+func (u *User) Role() string {
+	if u.User_type == "" {
+		return RoleUser
+	}
+
+	return u.User_type
+}
+
+// IsEmailVerified reports whether the user verified their email. Users created before verification existed count as verified.
+func (u *User) IsEmailVerified() bool {
+	return u.Email_verified == nil || *u.Email_verified
 }
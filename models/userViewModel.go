@@ -0,0 +1,96 @@
+package models
+
+import "time"
+
+// PublicUser is what any logged in user may see of another user, such as on the leaderboard
+type PublicUser struct {
+	User_id    string  `json:"user_id"`
+	First_name *string `json:"first_name"`
+	Last_name  *string `json:"last_name"`
+	Points     int     `json:"points"`
+	Timetable  string  `json:"timetable"`
+}
+
+// SelfUser is what users see of their own account
+type SelfUser struct {
+	PublicUser
+	Email              *string   `json:"email"`
+	Email_verified     bool      `json:"email_verified"`
+	User_type          string    `json:"user_type"`
+	Two_factor_enabled bool      `json:"two_factor_enabled"`
+	Created_at         time.Time `json:"created_at"`
+	Updated_at         time.Time `json:"updated_at"`
+}
+
+// LinkedIdentity names a provider account linked to a user, without its subject
+type LinkedIdentity struct {
+	Provider  string    `json:"provider"`
+	Linked_at time.Time `json:"linked_at"`
+}
+
+// AdminUser is what admins see of any account. It is also the shape of users kept in the cache.
+type AdminUser struct {
+	SelfUser
+	Identities []LinkedIdentity `json:"identities"`
+}
+
+// LoginResult is the response to a successful login
+type LoginResult struct {
+	SelfUser
+	Token         string `json:"token"`
+	Refresh_token string `json:"refresh_token"`
+}
+
+func (u *User) PublicView() PublicUser {
+	return PublicUser{
+		User_id:    u.User_id,
+		First_name: u.First_name,
+		Last_name:  u.Last_name,
+		Points:     u.Points,
+		Timetable:  u.Timetable,
+	}
+}
+
+func (u *User) SelfView() SelfUser {
+	return SelfUser{
+		PublicUser:         u.PublicView(),
+		Email:              u.Email,
+		Email_verified:     u.IsEmailVerified(),
+		User_type:          u.Role(),
+		Two_factor_enabled: u.Two_factor_enabled,
+		Created_at:         u.Created_at,
+		Updated_at:         u.Updated_at,
+	}
+}
+
+func (u *User) AdminView() AdminUser {
+	identities := make([]LinkedIdentity, 0, len(u.Identities))
+	for _, identity := range u.Identities {
+		identities = append(identities, LinkedIdentity{Provider: identity.Provider, Linked_at: identity.Linked_at})
+	}
+
+	return AdminUser{
+		SelfUser:   u.SelfView(),
+		Identities: identities,
+	}
+}
+
+// PublicViews maps a list of users, such as the leaderboard, to their public view
+func PublicViews(users []User) []PublicUser {
+	views := make([]PublicUser, 0, len(users))
+	for i := range users {
+		views = append(views, users[i].PublicView())
+	}
+
+	return views
+}
+
+// AdminViews maps a list of users to the view of admins
+func AdminViews(users []User) []AdminUser {
+	views := make([]AdminUser, 0, len(users))
+	for i := range users {
+		views = append(views, users[i].AdminView())
+	}
+
+	return views
+}
@@ -0,0 +1,57 @@
+package oidc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hauchongtang/splatbackend/clock"
+)
+
+// Config describes a client registered with an OpenID Connect provider
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+var defaultScopes = []string{"openid", "email", "profile"}
+
+// ProvidersFromEnv loads the providers listed in the comma separated OIDC_PROVIDERS. A provider named google
+// is configured by OIDC_GOOGLE_ISSUER, OIDC_GOOGLE_CLIENT_ID, OIDC_GOOGLE_CLIENT_SECRET, OIDC_GOOGLE_REDIRECT_URL
+// and optionally OIDC_GOOGLE_SCOPES. The issuer can be any URL, such as a mock issuer running locally.
+func ProvidersFromEnv(clock clock.Clock) (map[string]*Provider, error) {
+	providers := make(map[string]*Provider)
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       defaultScopes,
+		}
+
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			config.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+		}
+
+		if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("OIDC provider %s needs %sISSUER, %sCLIENT_ID and %sREDIRECT_URL", name, prefix, prefix, prefix)
+		}
+
+		providers[name] = NewProvider(config, clock)
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,276 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// clockSkew is how far the clocks of the provider and the API may drift apart
+const clockSkew = time.Minute
+
+// audience accepts both forms of the aud claim, a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	err := json.Unmarshal(data, &many)
+	*a = many
+	return err
+}
+
+func (a audience) contains(value string) bool {
+	for _, entry := range a {
+		if entry == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flexibleBool accepts true and "true", as some providers send email_verified as a string
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	*b = flexibleBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// IDToken holds the claims of a verified ID token
+type IDToken struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      audience     `json:"aud"`
+	AuthorizedBy  string       `json:"azp"`
+	ExpiresAt     int64        `json:"exp"`
+	IssuedAt      int64        `json:"iat"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	GivenName     string       `json:"given_name"`
+	FamilyName    string       `json:"family_name"`
+	Name          string       `json:"name"`
+}
+
+// Valid checks the lifetime of the token against the wall clock, as jwt-go claims do. Verify checks it against the
+// clock of the provider instead.
+func (t *IDToken) Valid() error {
+	return t.validAt(time.Now())
+}
+
+func (t *IDToken) validAt(now time.Time) error {
+	if t.ExpiresAt == 0 || now.After(time.Unix(t.ExpiresAt, 0).Add(clockSkew)) {
+		return errors.New("ID token has expired")
+	}
+	if t.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(t.IssuedAt, 0)) {
+		return errors.New("ID token is issued in the future")
+	}
+
+	return nil
+}
+
+// Verify checks the signature, issuer, audience and nonce of an ID token
+func (p *Provider) Verify(ctx context.Context, rawToken string, nonce string) (*IDToken, error) {
+	claims := &IDToken{}
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return p.verificationKey(ctx, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = claims.validAt(p.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != p.Issuer {
+		return nil, fmt.Errorf("ID token is issued by %s instead of %s", claims.Issuer, p.Issuer)
+	}
+	if !claims.Audience.contains(p.ClientID) {
+		return nil, errors.New("ID token is not meant for this client")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.ClientID {
+		return nil, errors.New("ID token is authorized for another client")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	return claims, nil
+}
+
+// verificationKey finds the provider key for a token. Keys are fetched again when the kid is unknown,
+// which is how providers rotate them.
+func (p *Provider) verificationKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if !strings.HasPrefix(alg, "RS") && !strings.HasPrefix(alg, "ES") {
+		return nil, fmt.Errorf("unexpected signing method %s", alg)
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	key, err := p.lookupKey(ctx, kid, false)
+	if err == nil && key == nil {
+		key, err = p.lookupKey(ctx, kid, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return nil, fmt.Errorf("unexpected signing method %s", alg)
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return nil, fmt.Errorf("unexpected signing method %s", alg)
+		}
+	}
+
+	return key, nil
+}
+
+func (p *Provider) lookupKey(ctx context.Context, kid string, refresh bool) (interface{}, error) {
+	document, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stale := refresh && p.clock.Now().Sub(p.keysFetchedAt) > keyRefreshInterval
+	if p.keys == nil || stale {
+		keys, err := p.fetchKeys(ctx, document.JwksURI)
+		if err != nil {
+			return nil, err
+		}
+		p.keys = keys
+		p.keysFetchedAt = p.clock.Now()
+	}
+
+	// A provider with a single key may leave out the kid
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+
+	return p.keys[kid], nil
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, jwksURI string) (map[string]interface{}, error) {
+	keySet := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+
+	err := p.getJSON(ctx, jwksURI, &keySet)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := parseKey(jwk)
+		if err != nil { // Keys of unsupported types are skipped, others may still verify the token
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+func parseKey(jwk jsonWebKey) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC key is not on its curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
@@ -0,0 +1,170 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+)
+
+// keyRefreshInterval limits how often an unknown kid makes the provider keys be fetched again
+const keyRefreshInterval = time.Minute
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Provider runs the authorization code flow with PKCE against one OpenID Connect provider.
+// Its discovery document and keys are fetched on first use, so that an unreachable provider does not stop the API.
+type Provider struct {
+	Config
+	httpClient *http.Client
+	clock      clock.Clock
+
+	mu            sync.Mutex
+	discovery     *discovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+func NewProvider(config Config, clock clock.Clock) *Provider {
+	return &Provider{
+		Config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		clock:      clock,
+	}
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, value interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := p.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", endpoint, response.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(value)
+}
+
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	document := discovery{}
+	err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &document)
+	if err != nil {
+		return nil, err
+	}
+
+	if document.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %s instead of %s", document.Issuer, p.Issuer)
+	}
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JwksURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	p.discovery = &document
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns where to send the user to sign in with the provider
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, codeVerifier string) (string, error) {
+	document, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(document.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return document.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for an ID token and returns its verified claims
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*IDToken, error) {
+	document, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, document.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	response, err := p.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	tokens := tokenResponse{}
+	err = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token response: %w", err)
+	}
+
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("token request refused: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if response.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint responded with %s and no ID token", response.Status)
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
@@ -0,0 +1,247 @@
+package rediscache
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/hauchongtang/splatbackend/clock"
+)
+
+// AttemptStore counts failed attempts per key and remembers until when a key is blocked
+type AttemptStore interface {
+	// Fail records a failed attempt and returns the number of failures within the window
+	Fail(ctx context.Context, key string, window time.Duration) (int64, error)
+	Block(ctx context.Context, key string, until time.Time) error
+	BlockedUntil(ctx context.Context, key string) (time.Time, error)
+	Reset(ctx context.Context, key string) error
+}
+
+func failedAttemptsKey(key string) string {
+	return "attempts:failed:" + key
+}
+
+func blockedAttemptsKey(key string) string {
+	return "attempts:blocked:" + key
+}
+
+// RedisAttemptStore shares attempt counters between every instance of the API
+type RedisAttemptStore struct {
+	client *redis.Client
+	clock  clock.Clock
+}
+
+func NewRedisAttemptStore(client *redis.Client, clock clock.Clock) *RedisAttemptStore {
+	return &RedisAttemptStore{client: client, clock: clock}
+}
+
+func (r *RedisAttemptStore) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	count, err := r.client.Incr(ctx, failedAttemptsKey(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if count == 1 { // The window starts with the first failure
+		err = r.client.Expire(ctx, failedAttemptsKey(key), window).Err()
+	}
+
+	return count, err
+}
+
+func (r *RedisAttemptStore) Block(ctx context.Context, key string, until time.Time) error {
+	return r.client.Set(ctx, blockedAttemptsKey(key), until.UnixNano(), until.Sub(r.clock.Now())).Err()
+}
+
+func (r *RedisAttemptStore) BlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	value, err := r.client.Get(ctx, blockedAttemptsKey(key)).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, nanos), nil
+}
+
+func (r *RedisAttemptStore) Reset(ctx context.Context, key string) error {
+	return r.client.Del(ctx, failedAttemptsKey(key), blockedAttemptsKey(key)).Err()
+}
+
+type attemptEntry struct {
+	failures     int64
+	windowEnds   time.Time
+	blockedUntil time.Time
+}
+
+// MemoryAttemptStore counts attempts in process. It is only visible to the instance that wrote it.
+type MemoryAttemptStore struct {
+	mu      sync.Mutex
+	clock   clock.Clock
+	entries map[string]*attemptEntry
+}
+
+func NewMemoryAttemptStore(clock clock.Clock) *MemoryAttemptStore {
+	return &MemoryAttemptStore{clock: clock, entries: make(map[string]*attemptEntry)}
+}
+
+// entry returns the live entry for key, dropping it once both its window and its block are over
+func (m *MemoryAttemptStore) entry(key string) *attemptEntry {
+	now := m.clock.Now()
+	entry, found := m.entries[key]
+	if !found {
+		return nil
+	}
+
+	if now.After(entry.windowEnds) {
+		entry.failures = 0
+	}
+	if entry.failures == 0 && now.After(entry.blockedUntil) {
+		delete(m.entries, key)
+		return nil
+	}
+
+	return entry
+}
+
+func (m *MemoryAttemptStore) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entry(key)
+	if entry == nil {
+		entry = &attemptEntry{}
+		m.entries[key] = entry
+	}
+	if entry.failures == 0 {
+		entry.windowEnds = m.clock.Now().Add(window)
+	}
+
+	entry.failures++
+	return entry.failures, nil
+}
+
+func (m *MemoryAttemptStore) Block(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entry(key)
+	if entry == nil {
+		entry = &attemptEntry{}
+		m.entries[key] = entry
+	}
+
+	entry.blockedUntil = until
+	return nil
+}
+
+func (m *MemoryAttemptStore) BlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entry(key)
+	if entry == nil {
+		return time.Time{}, nil
+	}
+
+	return entry.blockedUntil, nil
+}
+
+func (m *MemoryAttemptStore) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// FallbackAttemptStore counts attempts in both stores so that throttling keeps working on this instance
+// while the primary store is unreachable
+type FallbackAttemptStore struct {
+	primary  AttemptStore
+	fallback AttemptStore
+}
+
+func NewFallbackAttemptStore(primary AttemptStore, fallback AttemptStore) *FallbackAttemptStore {
+	return &FallbackAttemptStore{primary: primary, fallback: fallback}
+}
+
+func (f *FallbackAttemptStore) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	local, _ := f.fallback.Fail(ctx, key, window)
+
+	shared, err := f.primary.Fail(ctx, key, window)
+	if err != nil {
+		log.Default().Println(err, "Unable to count failed attempt, using in-memory counters")
+		return local, nil
+	}
+
+	if local > shared {
+		return local, nil
+	}
+	return shared, nil
+}
+
+func (f *FallbackAttemptStore) Block(ctx context.Context, key string, until time.Time) error {
+	f.fallback.Block(ctx, key, until)
+
+	err := f.primary.Block(ctx, key, until)
+	if err != nil {
+		log.Default().Println(err, "Unable to store block, using in-memory counters")
+	}
+
+	return nil
+}
+
+func (f *FallbackAttemptStore) BlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	local, _ := f.fallback.BlockedUntil(ctx, key)
+
+	shared, err := f.primary.BlockedUntil(ctx, key)
+	if err != nil {
+		log.Default().Println(err, "Unable to read block, using in-memory counters")
+		return local, nil
+	}
+
+	if local.After(shared) {
+		return local, nil
+	}
+	return shared, nil
+}
+
+func (f *FallbackAttemptStore) Reset(ctx context.Context, key string) error {
+	f.fallback.Reset(ctx, key)
+	return f.primary.Reset(ctx, key)
+}
+
+// ScopedAttemptStore keeps the attempts of one tenant apart from those of the others sharing a store
+type ScopedAttemptStore struct {
+	store  AttemptStore
+	prefix string
+}
+
+func NewScopedAttemptStore(store AttemptStore, scope string) *ScopedAttemptStore {
+	return &ScopedAttemptStore{store: store, prefix: scope + ":"}
+}
+
+func (s *ScopedAttemptStore) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return s.store.Fail(ctx, s.prefix+key, window)
+}
+
+func (s *ScopedAttemptStore) Block(ctx context.Context, key string, until time.Time) error {
+	return s.store.Block(ctx, s.prefix+key, until)
+}
+
+func (s *ScopedAttemptStore) BlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	return s.store.BlockedUntil(ctx, s.prefix+key)
+}
+
+func (s *ScopedAttemptStore) Reset(ctx context.Context, key string) error {
+	return s.store.Reset(ctx, s.prefix+key)
+}
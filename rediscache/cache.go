@@ -0,0 +1,353 @@
+package rediscache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-redis/cache/v9"
+	"github.com/go-redis/redis/v9"
+	"github.com/hauchongtang/splatbackend/clock"
+)
+
+var (
+	// ErrCacheMiss is returned by Get when the key is not cached
+	ErrCacheMiss = cache.ErrCacheMiss
+	// ErrCacheUnavailable is returned while the circuit breaker of a cache is open
+	ErrCacheUnavailable = errors.New("cache: unavailable")
+	// ErrInspectUnsupported is returned by Inspect when the cache cannot describe its keys
+	ErrInspectUnsupported = errors.New("cache: keys cannot be inspected")
+)
+
+// Cache keeps copies of what was read from the database. Callers read from the database when Get fails,
+// whatever the error, so a cache may always fail.
+type Cache interface {
+	Get(ctx context.Context, key string, value interface{}) error
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// KeyInfo describes a cached key
+type KeyInfo struct {
+	Key string
+	// TTL is how long the key is kept, stale period included
+	TTL time.Duration
+	// Size is the number of bytes of the encoded value
+	Size int
+}
+
+// Inspector is a cache that can describe its keys
+type Inspector interface {
+	Inspect(ctx context.Context, key string) (KeyInfo, error)
+}
+
+// Inspect describes a key of c, failing with ErrCacheMiss when it is not cached
+func Inspect(ctx context.Context, c Cache, key string) (KeyInfo, error) {
+	inspector, ok := c.(Inspector)
+	if !ok {
+		return KeyInfo{}, ErrInspectUnsupported
+	}
+	return inspector.Inspect(ctx, key)
+}
+
+// codec encodes values the same way for every cache
+var codec = cache.New(&cache.Options{})
+
+// RedisCache shares cached values between every instance of the API
+type RedisCache struct {
+	client *redis.Client
+	cache  *cache.Cache
+}
+
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{client: client, cache: cache.New(&cache.Options{Redis: client})}
+}
+
+func (r *RedisCache) Get(ctx context.Context, key string, value interface{}) error {
+	return r.cache.Get(ctx, key, value)
+}
+
+func (r *RedisCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return r.cache.Set(&cache.Item{Ctx: ctx, Key: key, Value: value, TTL: ttl})
+}
+
+func (r *RedisCache) Delete(ctx context.Context, key string) error {
+	err := r.cache.Delete(ctx, key)
+	if err == ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+func (r *RedisCache) Inspect(ctx context.Context, key string) (KeyInfo, error) {
+	pipe := r.client.Pipeline()
+	ttl := pipe.PTTL(ctx, key)
+	size := pipe.StrLen(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	// PTTL is negative when the key does not exist, or never expires, which the cache never does
+	if ttl.Val() < 0 {
+		return KeyInfo{}, ErrCacheMiss
+	}
+	return KeyInfo{Key: key, TTL: ttl.Val(), Size: int(size.Val())}, nil
+}
+
+// LRUCache keeps cached values in process, evicting the least recently used once it holds size values
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	clock   clock.Clock
+	recency *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewLRUCache(size int, clock clock.Clock) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		clock:   clock,
+		recency: list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (l *LRUCache) Get(ctx context.Context, key string, value interface{}) error {
+	l.mu.Lock()
+	element, found := l.entries[key]
+	if !found {
+		l.mu.Unlock()
+		return ErrCacheMiss
+	}
+
+	entry := element.Value.(*lruEntry)
+	if !l.clock.Now().Before(entry.expiresAt) {
+		l.recency.Remove(element)
+		delete(l.entries, key)
+		l.mu.Unlock()
+		return ErrCacheMiss
+	}
+
+	l.recency.MoveToFront(element)
+	data := entry.value
+	l.mu.Unlock()
+
+	return codec.Unmarshal(data, value)
+}
+
+func (l *LRUCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	// Values are stored encoded, so that callers never share what they read
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := &lruEntry{key: key, value: data, expiresAt: l.clock.Now().Add(ttl)}
+	if element, found := l.entries[key]; found {
+		element.Value = entry
+		l.recency.MoveToFront(element)
+		return nil
+	}
+
+	l.entries[key] = l.recency.PushFront(entry)
+	for l.recency.Len() > l.size {
+		oldest := l.recency.Back()
+		l.recency.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry).key)
+	}
+
+	return nil
+}
+
+func (l *LRUCache) Delete(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, found := l.entries[key]; found {
+		l.recency.Remove(element)
+		delete(l.entries, key)
+	}
+
+	return nil
+}
+
+func (l *LRUCache) Inspect(ctx context.Context, key string) (KeyInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, found := l.entries[key]
+	if !found {
+		return KeyInfo{}, ErrCacheMiss
+	}
+
+	entry := element.Value.(*lruEntry)
+	ttl := entry.expiresAt.Sub(l.clock.Now())
+	if ttl <= 0 {
+		return KeyInfo{}, ErrCacheMiss
+	}
+	return KeyInfo{Key: key, TTL: ttl, Size: len(entry.value)}, nil
+}
+
+// NoopCache caches nothing, so that every read goes to the database
+type NoopCache struct{}
+
+func (NoopCache) Get(ctx context.Context, key string, value interface{}) error {
+	return ErrCacheMiss
+}
+
+func (NoopCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return nil
+}
+
+func (NoopCache) Delete(ctx context.Context, key string) error {
+	return nil
+}
+
+const (
+	// breakerThreshold is how many transport errors in a row open the circuit breaker
+	breakerThreshold = 5
+	// breakerRetry is how often an open circuit breaker checks whether the cache answers again
+	breakerRetry = 5 * time.Second
+)
+
+// BreakerCache stops using a cache after repeated errors reaching it, so that requests do not wait on a cache that is
+// down. A value that cannot be decoded is a miss, and is deleted so that it is read from the database again.
+// While it is open every call fails with ErrCacheUnavailable, and the cache is pinged in the background until it
+// answers again. Deletes made while it is open are lost, so entries can be stale for up to their TTL afterwards.
+type BreakerCache struct {
+	cache Cache
+	ping  func(ctx context.Context) error
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+}
+
+func NewBreakerCache(cache Cache, ping func(ctx context.Context) error) *BreakerCache {
+	return &BreakerCache{cache: cache, ping: ping}
+}
+
+// NewRedisBreakerCache caches in Redis, behind a circuit breaker that pings the client
+func NewRedisBreakerCache(client *redis.Client) *BreakerCache {
+	return NewBreakerCache(NewRedisCache(client), func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+}
+
+func (b *BreakerCache) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// record counts the transport errors in a row, and opens the breaker once there are too many.
+// Any other outcome means that the cache answered.
+func (b *BreakerCache) record(err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isTransportError(err) {
+		b.failures = 0
+		return err
+	}
+
+	b.failures++
+	if b.failures >= breakerThreshold && !b.open {
+		log.Default().Println(err, "Cache keeps failing, reading from the database until it answers again")
+		b.open = true
+		go b.reconnect()
+	}
+
+	return err
+}
+
+// isTransportError tells whether err comes from reaching the cache, rather than from what it answered
+func isTransportError(err error) bool {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, redis.ErrClosed), errors.As(err, &netErr):
+		return true
+	}
+	// go-redis does not export the error of a pool that ran out of connections
+	return err.Error() == "redis: connection pool timeout"
+}
+
+// isDecodeError tells whether the cache answered Get with a value that cannot be decoded
+func isDecodeError(err error) bool {
+	var reply redis.Error
+	return err != nil && err != ErrCacheMiss && !isTransportError(err) && !errors.Is(err, context.Canceled) &&
+		!errors.As(err, &reply)
+}
+
+func (b *BreakerCache) reconnect() {
+	ticker := time.NewTicker(breakerRetry)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), breakerRetry)
+		err := b.ping(ctx)
+		cancel()
+
+		if err == nil {
+			b.mu.Lock()
+			b.open = false
+			b.failures = 0
+			b.mu.Unlock()
+
+			log.Default().Println("Cache answers again")
+			return
+		}
+	}
+}
+
+func (b *BreakerCache) Get(ctx context.Context, key string, value interface{}) error {
+	if b.isOpen() {
+		return ErrCacheUnavailable
+	}
+	err := b.record(b.cache.Get(ctx, key, value))
+	if isDecodeError(err) { // As when the type of the value changed since it was cached
+		log.Default().Println(err, "Unable to decode", key, "deleting it")
+		b.Delete(ctx, key)
+		return ErrCacheMiss
+	}
+	return err
+}
+
+func (b *BreakerCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if b.isOpen() {
+		return ErrCacheUnavailable
+	}
+	return b.record(b.cache.Set(ctx, key, value, ttl))
+}
+
+func (b *BreakerCache) Delete(ctx context.Context, key string) error {
+	if b.isOpen() {
+		return ErrCacheUnavailable
+	}
+	return b.record(b.cache.Delete(ctx, key))
+}
+
+func (b *BreakerCache) Inspect(ctx context.Context, key string) (KeyInfo, error) {
+	if b.isOpen() {
+		return KeyInfo{}, ErrCacheUnavailable
+	}
+	return Inspect(ctx, b.cache, key)
+}
@@ -0,0 +1,90 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// failingCache fails every Get with err, and records the keys deleted
+type failingCache struct {
+	NoopCache
+	err     error
+	deleted []string
+}
+
+func (f *failingCache) Get(ctx context.Context, key string, value interface{}) error {
+	return f.err
+}
+
+func (f *failingCache) Delete(ctx context.Context, key string) error {
+	f.deleted = append(f.deleted, key)
+	return nil
+}
+
+// replyError is an error answered by Redis, such as a command refused by a replica
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+func (replyError) RedisError()     {}
+
+func TestBreakerOpensOnTransportErrorsOnly(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		err    error
+		opens  bool
+		miss   bool
+		delete bool
+	}{
+		{"timeouts", context.DeadlineExceeded, true, false, false},
+		{"refused connections", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true, false, false},
+		{"values that cannot be decoded", errors.New("msgpack: invalid code=c1 decoding string/bytes length"), false, true, true},
+		{"errors answered by Redis", replyError("READONLY You can't write against a read only replica."), false, false, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			failing := &failingCache{err: test.err}
+			breaker := NewBreakerCache(failing, func(ctx context.Context) error { return test.err })
+
+			var value string
+			for i := 0; i < 2*breakerThreshold; i++ {
+				err := breaker.Get(context.Background(), "key", &value)
+				if err == ErrCacheUnavailable {
+					break
+				}
+				if test.miss && err != ErrCacheMiss {
+					t.Fatalf("got %v, want a miss", err)
+				}
+				if !test.miss && !errors.Is(err, test.err) {
+					t.Fatalf("got %v, want %v", err, test.err)
+				}
+			}
+
+			if breaker.isOpen() != test.opens {
+				t.Errorf("the breaker is open %v, want %v", breaker.isOpen(), test.opens)
+			}
+			if (len(failing.deleted) > 0) != test.delete {
+				t.Errorf("the key was deleted %d times", len(failing.deleted))
+			}
+		})
+	}
+}
+
+func TestBreakerClosesAfterAnAnswer(t *testing.T) {
+	failing := &failingCache{err: context.DeadlineExceeded}
+	breaker := NewBreakerCache(failing, func(ctx context.Context) error { return nil })
+
+	var value string
+	for i := 0; i < 2*breakerThreshold; i++ {
+		// Values that cannot be decoded show the cache answering, between timeouts that are then not in a row
+		failing.err = context.DeadlineExceeded
+		if i%2 == 1 {
+			failing.err = errors.New("msgpack: invalid code")
+		}
+		breaker.Get(context.Background(), "key", &value)
+	}
+
+	if breaker.isOpen() {
+		t.Error("the breaker opened on errors that were not in a row")
+	}
+}
@@ -0,0 +1,111 @@
+package rediscache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// Invalidations evicts stale keys from the cache of every instance of the API
+type Invalidations interface {
+	Invalidate(ctx context.Context, keys ...string) error
+}
+
+// evict deletes keys from a cache, returning the last error. The loads of keys are told first, so that they do not
+// cache what they read before.
+func evict(ctx context.Context, cache Cache, generations *Generations, keys []string) error {
+	generations.Invalidated(keys...)
+
+	var failed error
+	for _, key := range keys {
+		err := cache.Delete(ctx, key)
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// LocalInvalidations evicts keys from a cache that no other instance reads
+type LocalInvalidations struct {
+	cache       Cache
+	generations *Generations
+}
+
+func NewLocalInvalidations(cache Cache, generations *Generations) *LocalInvalidations {
+	return &LocalInvalidations{cache: cache, generations: generations}
+}
+
+func (l *LocalInvalidations) Invalidate(ctx context.Context, keys ...string) error {
+	return evict(ctx, l.cache, l.generations, keys)
+}
+
+// invalidationsChannel is the Redis channel evicted keys are published on
+const invalidationsChannel = "cache-invalidations"
+
+// invalidation is a message on the invalidations channel
+type invalidation struct {
+	Origin string
+	Keys   []string
+}
+
+// RedisInvalidations evicts keys from the cache, and publishes them over Redis so that the instances that Listen
+// evict them from their own caches too
+type RedisInvalidations struct {
+	client      *redis.Client
+	cache       Cache
+	generations *Generations
+	origin      string // tells the messages of this instance apart
+}
+
+func NewRedisInvalidations(client *redis.Client, cache Cache, generations *Generations) *RedisInvalidations {
+	origin := make([]byte, 8)
+	rand.Read(origin)
+
+	return &RedisInvalidations{client: client, cache: cache, generations: generations, origin: hex.EncodeToString(origin)}
+}
+
+func (r *RedisInvalidations) Invalidate(ctx context.Context, keys ...string) error {
+	err := evict(ctx, r.cache, r.generations, keys)
+
+	message, _ := json.Marshal(invalidation{Origin: r.origin, Keys: keys})
+	publishErr := r.client.Publish(ctx, invalidationsChannel, message).Err()
+	if publishErr != nil {
+		return publishErr
+	}
+
+	return err
+}
+
+// Listen evicts the keys published by other instances from the cache, until ctx ends. Instances caching in process
+// listen to evict them, and instances sharing the cache in Redis to stop their loads of them from caching stale
+// values. Keys published while Redis is unreachable are missed.
+func (r *RedisInvalidations) Listen(ctx context.Context) {
+	subscription := r.client.Subscribe(ctx, invalidationsChannel)
+	go func() {
+		<-ctx.Done()
+		subscription.Close()
+	}()
+
+	for message := range subscription.Channel() {
+		var received invalidation
+		err := json.Unmarshal([]byte(message.Payload), &received)
+		if err != nil {
+			log.Default().Println(err, "Unable to read cache invalidation")
+			continue
+		}
+
+		if received.Origin == r.origin {
+			continue
+		}
+
+		err = evict(ctx, r.cache, r.generations, received.Keys)
+		if err != nil {
+			log.Default().Println(err, "Unable to evict invalidated keys")
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/repository"
+	"golang.org/x/sync/singleflight"
+)
+
+// LoadOptions tell GetOrLoad how long what it loads is kept
+type LoadOptions struct {
+	// TTL is how long a loaded value is served without loading it again
+	TTL time.Duration
+	// Stale is how long after its TTL a value is still served, while it is loaded again in the background
+	Stale time.Duration
+	// Missing is how long it is remembered that the loader found nothing. Nothing is remembered when zero.
+	Missing time.Duration
+}
+
+// loaded is what GetOrLoad keeps in the cache
+type loaded[T any] struct {
+	Value       T
+	Missing     bool
+	Fresh_until time.Time
+}
+
+// loadTimeout bounds a load, which runs apart from the requests waiting for it
+const loadTimeout = 10 * time.Second
+
+// Generations count the invalidations of the keys being loaded, so that a load can tell that what it read went
+// stale before it was cached. Keys are only tracked while they are loaded.
+type Generations struct {
+	mu   sync.Mutex
+	keys map[string]*generation
+}
+
+// generation is how many loads of a key are running, and how many times the key was invalidated meanwhile
+type generation struct {
+	loads         int
+	invalidations uint64
+}
+
+func NewGenerations() *Generations {
+	return &Generations{keys: make(map[string]*generation)}
+}
+
+// Invalidated tells the loads of keys that what they read may be stale
+func (g *Generations) Invalidated(keys ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, key := range keys {
+		if tracked, found := g.keys[key]; found {
+			tracked.invalidations++
+		}
+	}
+}
+
+// begin tracks a load of key, and returns its generation
+func (g *Generations) begin(key string) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tracked, found := g.keys[key]
+	if !found {
+		tracked = &generation{}
+		g.keys[key] = tracked
+	}
+	tracked.loads++
+	return tracked.invalidations
+}
+
+// end stops tracking a load of key that began at started, and tells whether key was invalidated since
+func (g *Generations) end(key string, started uint64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tracked := g.keys[key]
+	tracked.loads--
+	if tracked.loads == 0 {
+		delete(g.keys, key)
+	}
+	return tracked.invalidations != started
+}
+
+// Loader loads values into a cache for GetOrLoad, and tells with its clock when they need loading again
+type Loader struct {
+	cache       Cache
+	generations *Generations
+	clock       clock.Clock
+	// loads lets only one load of a key run at a time through this loader, the other callers waiting for its result
+	loads singleflight.Group
+}
+
+// NewLoader loads into cache. generations must hear of every invalidation of the keys of cache.
+func NewLoader(cache Cache, generations *Generations, clock clock.Clock) *Loader {
+	return &Loader{cache: cache, generations: generations, clock: clock}
+}
+
+// jitter spreads a TTL by up to a tenth either way, so that entries cached together do not expire together
+func jitter(ttl time.Duration) time.Duration {
+	if ttl < 10 {
+		return ttl
+	}
+	return ttl - ttl/10 + time.Duration(rand.Int63n(int64(ttl/5)))
+}
+
+// store caches what was loaded, or that nothing was found
+func store[T any](ctx context.Context, l *Loader, key string, options LoadOptions, entry loaded[T]) error {
+	ttl := jitter(options.TTL)
+	if entry.Missing {
+		ttl = jitter(options.Missing)
+	}
+
+	entry.Fresh_until = l.clock.Now().Add(ttl)
+	return l.cache.Set(ctx, key, entry, ttl+options.Stale)
+}
+
+// load runs loader once for every caller waiting for key. The load does not end with the caller that started it,
+// while each caller stops waiting when its own ctx is done.
+func load[T any](ctx context.Context, l *Loader, key string, options LoadOptions, loader func(ctx context.Context) (T, error)) (loaded[T], error) {
+	results := l.loads.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+		defer cancel()
+
+		started := l.generations.begin(key)
+		value, err := loader(ctx)
+		entry := loaded[T]{Value: value}
+
+		if errors.Is(err, repository.ErrNotFound) && options.Missing > 0 {
+			entry.Missing = true
+		} else if err != nil {
+			l.generations.end(key, started)
+			return entry, err
+		}
+
+		err = store(ctx, l, key, options, entry)
+		if err != nil { // Not fatal, the next read loads it again
+			log.Default().Println(err, "Unable to set cache")
+		}
+
+		// What was read may predate the invalidation, whose delete may have come before the store
+		if l.generations.end(key, started) {
+			err = l.cache.Delete(ctx, key)
+			if err != nil {
+				log.Default().Println(err, "Unable to evict", key, "invalidated while it loaded")
+			}
+		}
+
+		return entry, nil
+	})
+
+	select {
+	case result := <-results:
+		return result.Val.(loaded[T]), result.Err
+	case <-ctx.Done():
+		return loaded[T]{}, ctx.Err()
+	}
+}
+
+// GetOrLoad reads key from the cache of l, or loads it with loader and caches it when it is not there.
+// Concurrent misses of a key share a single load. A value past its TTL is still served during options.Stale while it
+// is loaded again in the background. The loader finding nothing is signalled with repository.ErrNotFound.
+func GetOrLoad[T any](ctx context.Context, l *Loader, key string, options LoadOptions, loader func(ctx context.Context) (T, error)) (T, error) {
+	var entry loaded[T]
+	err := l.cache.Get(ctx, key, &entry)
+
+	if err == nil && l.clock.Now().After(entry.Fresh_until) {
+		// Nobody waits for the refresh
+		go load(context.Background(), l, key, options, loader)
+	}
+
+	if err != nil {
+		if err != ErrCacheMiss {
+			log.Default().Println(err, "Unable to fetch from cache")
+		}
+
+		entry, err = load(ctx, l, key, options, loader)
+		if err != nil {
+			return entry.Value, err
+		}
+	}
+
+	if entry.Missing {
+		return entry.Value, repository.ErrNotFound
+	}
+	return entry.Value, nil
+}
@@ -0,0 +1,145 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+)
+
+var testLoadOptions = LoadOptions{TTL: time.Minute}
+
+// blockedLoad is a loader that waits to be released, and reports the context it ran with
+type blockedLoad struct {
+	started  chan context.Context
+	released chan struct{}
+}
+
+func newBlockedLoad() *blockedLoad {
+	return &blockedLoad{started: make(chan context.Context, 1), released: make(chan struct{})}
+}
+
+func (b *blockedLoad) load(ctx context.Context) (string, error) {
+	b.started <- ctx
+	<-b.released
+	return "loaded", nil
+}
+
+// result is what GetOrLoad returned to a caller
+type result struct {
+	value string
+	err   error
+}
+
+func getOrLoad(ctx context.Context, l *Loader, key string, loader func(ctx context.Context) (string, error)) chan result {
+	results := make(chan result, 1)
+	go func() {
+		value, err := GetOrLoad(ctx, l, key, testLoadOptions, loader)
+		results <- result{value, err}
+	}()
+	return results
+}
+
+func waitFor[T any](t *testing.T, values chan T, what string) T {
+	t.Helper()
+
+	select {
+	case value := <-values:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		panic("unreachable")
+	}
+}
+
+func TestGetOrLoadOutlivesTheCallerStartingIt(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	loader := NewLoader(NewLRUCache(10, fake), NewGenerations(), fake)
+	blocked := newBlockedLoad()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := getOrLoad(ctx, loader, "key", blocked.load)
+	loadCtx := waitFor(t, blocked.started, "the load to start")
+
+	// The second caller waits for the load in flight, and would otherwise load the same value
+	second := getOrLoad(context.Background(), loader, "key", func(ctx context.Context) (string, error) {
+		return "loaded", nil
+	})
+
+	// The first caller gives up, which ends neither the load nor the wait of the second
+	cancel()
+	if got := waitFor(t, first, "the first caller"); !errors.Is(got.err, context.Canceled) {
+		t.Errorf("the cancelled caller got %v, want context.Canceled", got)
+	}
+	if loadCtx.Err() != nil {
+		t.Fatalf("the load was cancelled with its first caller: %v", loadCtx.Err())
+	}
+	if _, hasDeadline := loadCtx.Deadline(); !hasDeadline {
+		t.Error("the load has no timeout of its own")
+	}
+
+	close(blocked.released)
+	if got := waitFor(t, second, "the second caller"); got.err != nil || got.value != "loaded" {
+		t.Errorf("the second caller got %+v, want the loaded value", got)
+	}
+}
+
+func TestLoadersDoNotShareLoads(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	blocked := newBlockedLoad()
+	defer close(blocked.released)
+
+	// Two tenants, or two tests, using the same key each load it for themselves
+	first := NewLoader(NewLRUCache(10, fake), NewGenerations(), fake)
+	getOrLoad(context.Background(), first, "key", blocked.load)
+	waitFor(t, blocked.started, "the load of the first loader")
+
+	second := NewLoader(NewLRUCache(10, fake), NewGenerations(), fake)
+	got := waitFor(t, getOrLoad(context.Background(), second, "key", func(ctx context.Context) (string, error) {
+		return "second", nil
+	}), "the second loader")
+	if got.err != nil || got.value != "second" {
+		t.Errorf("the second loader got %+v, want its own value", got)
+	}
+}
+
+func TestInvalidationDuringALoad(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	cache := NewLRUCache(10, fake)
+	generations := NewGenerations()
+	loader := NewLoader(cache, generations, fake)
+	invalidations := NewLocalInvalidations(cache, generations)
+	blocked := newBlockedLoad()
+
+	loading := getOrLoad(context.Background(), loader, "key", blocked.load)
+	waitFor(t, blocked.started, "the load to start")
+
+	// The value changes after the load read it, and before it stores it
+	err := invalidations.Invalidate(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(blocked.released)
+	waitFor(t, loading, "the load")
+
+	var entry loaded[string]
+	if err := cache.Get(context.Background(), "key", &entry); err != ErrCacheMiss {
+		t.Fatalf("the value read before the invalidation is cached: %+v, %v", entry, err)
+	}
+
+	// Loads that begin after the invalidation cache what they read
+	got := waitFor(t, getOrLoad(context.Background(), loader, "key", func(ctx context.Context) (string, error) {
+		return "current", nil
+	}), "the next load")
+	if got.value != "current" {
+		t.Fatalf("got %+v", got)
+	}
+	if err := cache.Get(context.Background(), "key", &entry); err != nil || entry.Value != "current" {
+		t.Errorf("the cache holds %+v, %v, want the current value", entry, err)
+	}
+	if len(generations.keys) != 0 {
+		t.Errorf("%d keys are still tracked after their loads", len(generations.keys))
+	}
+}
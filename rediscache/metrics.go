@@ -0,0 +1,144 @@
+package rediscache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CacheStats count how a family of cache keys was used
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Errors uint64
+	// Calls and Latency are the number and total duration of every Get, Set and Delete
+	Calls   uint64
+	Latency time.Duration
+}
+
+// CacheMetrics count the use of a cache, by family of keys
+type CacheMetrics struct {
+	mu       sync.Mutex
+	families map[string]*CacheStats
+}
+
+func NewCacheMetrics() *CacheMetrics {
+	return &CacheMetrics{families: make(map[string]*CacheStats)}
+}
+
+// observe counts a call on a key of family. Only a Get has a hit or a miss.
+func (m *CacheMetrics) observe(family string, get bool, err error, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, found := m.families[family]
+	if !found {
+		stats = &CacheStats{}
+		m.families[family] = stats
+	}
+
+	stats.Calls++
+	stats.Latency += latency
+
+	switch {
+	case err == nil && get:
+		stats.Hits++
+	case err == ErrCacheMiss:
+		stats.Misses++
+	case err != nil:
+		stats.Errors++
+	}
+}
+
+// Snapshot copies the counts of every family
+func (m *CacheMetrics) Snapshot() map[string]CacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]CacheStats, len(m.families))
+	for family, stats := range m.families {
+		snapshot[family] = *stats
+	}
+	return snapshot
+}
+
+// WritePrometheus writes the counts in the Prometheus text format
+func (m *CacheMetrics) WritePrometheus(w io.Writer) error {
+	snapshot := m.Snapshot()
+	families := make([]string, 0, len(snapshot))
+	for family := range snapshot {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+
+	write := func(name string, value func(stats CacheStats) interface{}) error {
+		for _, family := range families {
+			_, err := fmt.Fprintf(w, "%s{family=%q} %v\n", name, family, value(snapshot[family]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(stats CacheStats) interface{}
+	}{
+		{"splat_cache_hits_total", "Reads found in the cache.", func(stats CacheStats) interface{} { return stats.Hits }},
+		{"splat_cache_misses_total", "Reads not found in the cache.", func(stats CacheStats) interface{} { return stats.Misses }},
+		{"splat_cache_errors_total", "Cache calls that failed.", func(stats CacheStats) interface{} { return stats.Errors }},
+	}
+	for _, counter := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", counter.name, counter.help, counter.name)
+		if err := write(counter.name, counter.value); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprint(w, "# HELP splat_cache_latency_seconds Duration of cache calls.\n# TYPE splat_cache_latency_seconds summary\n")
+	err := write("splat_cache_latency_seconds_sum", func(stats CacheStats) interface{} { return stats.Latency.Seconds() })
+	if err != nil {
+		return err
+	}
+	return write("splat_cache_latency_seconds_count", func(stats CacheStats) interface{} { return stats.Calls })
+}
+
+// MeteredCache counts the calls made on a cache in metrics, by the family its keys belong to
+type MeteredCache struct {
+	cache   Cache
+	metrics *CacheMetrics
+	family  func(key string) string
+}
+
+func NewMeteredCache(cache Cache, metrics *CacheMetrics, family func(key string) string) *MeteredCache {
+	return &MeteredCache{cache: cache, metrics: metrics, family: family}
+}
+
+func (m *MeteredCache) Get(ctx context.Context, key string, value interface{}) error {
+	start := time.Now()
+	err := m.cache.Get(ctx, key, value)
+	m.metrics.observe(m.family(key), true, err, time.Since(start))
+	return err
+}
+
+func (m *MeteredCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	start := time.Now()
+	err := m.cache.Set(ctx, key, value, ttl)
+	m.metrics.observe(m.family(key), false, err, time.Since(start))
+	return err
+}
+
+func (m *MeteredCache) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := m.cache.Delete(ctx, key)
+	m.metrics.observe(m.family(key), false, err, time.Since(start))
+	return err
+}
+
+func (m *MeteredCache) Inspect(ctx context.Context, key string) (KeyInfo, error) {
+	return Inspect(ctx, m.cache, key)
+}
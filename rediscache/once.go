@@ -0,0 +1,99 @@
+package rediscache
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/hauchongtang/splatbackend/clock"
+)
+
+// OnceStore keeps values that can be taken back a single time, such as the state of a login in progress
+type OnceStore interface {
+	PutOnce(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	// TakeOnce reads and deletes a value stored by PutOnce. Of concurrent callers only one gets the value,
+	// the others get redis.Nil.
+	TakeOnce(ctx context.Context, key string, value interface{}) error
+}
+
+// RedisOnceStore shares values between every instance of the API
+type RedisOnceStore struct {
+	client *redis.Client
+}
+
+func NewRedisOnceStore(client *redis.Client) *RedisOnceStore {
+	return &RedisOnceStore{client: client}
+}
+
+func (r *RedisOnceStore) PutOnce(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return r.client.Set(ctx, key, data, ttl).Err()
+}
+
+func (r *RedisOnceStore) TakeOnce(ctx context.Context, key string, value interface{}) error {
+	var get *redis.StringCmd
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := get.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+type onceEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// MemoryOnceStore keeps values in process. It is only visible to the instance that wrote it.
+type MemoryOnceStore struct {
+	mu      sync.Mutex
+	clock   clock.Clock
+	entries map[string]onceEntry
+}
+
+func NewMemoryOnceStore(clock clock.Clock) *MemoryOnceStore {
+	return &MemoryOnceStore{clock: clock, entries: make(map[string]onceEntry)}
+}
+
+func (m *MemoryOnceStore) PutOnce(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = onceEntry{data: data, expiresAt: m.clock.Now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryOnceStore) TakeOnce(ctx context.Context, key string, value interface{}) error {
+	m.mu.Lock()
+	entry, found := m.entries[key]
+	delete(m.entries, key)
+	m.mu.Unlock()
+
+	if !found || !m.clock.Now().Before(entry.expiresAt) {
+		return redis.Nil
+	}
+
+	return json.Unmarshal(entry.data, value)
+}
@@ -2,60 +2,35 @@ package rediscache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 
-	"github.com/go-redis/cache/v9"
 	"github.com/go-redis/redis/v9"
 )
 
-var (
-	redisCtx   = context.Background()
-	redisCache *cache.Cache
-)
-
-func getRedisClient() *redis.Client {
+// NewClient connects to the Redis at uri and checks that it answers.
+// A Redis that does not answer is not an error: the client keeps reconnecting, and its users fall back meanwhile.
+func NewClient(ctx context.Context, uri string) (*redis.Client, error) {
 	fmt.Println("Connecting to Railway Redis Database...")
 
-	// err := godotenv.Load(".env")
-
-	// if err != nil {
-	// 	log.Println("Unable to load .env file!")
-	// }
-
-	redis_uri := os.Getenv("REDIS_URI")
-
-	if len(redis_uri) == 0 {
-		log.Fatalln("No URI provided for redis client, check .env!")
+	if len(uri) == 0 {
+		return nil, errors.New("no URI provided for redis client, check .env")
 	}
 
-	opt, err := redis.ParseURL(redis_uri)
-
-	client := redis.NewClient(opt)
-
+	opt, err := redis.ParseURL(uri)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 
-	response, err := client.Ping(context.Background()).Result()
+	client := redis.NewClient(opt)
 
-	if err == nil {
-		fmt.Println("Connected to Railway Redis! Respnse:", response)
-	} else {
-		log.Fatalln("Unable to connect to Railway Redis! Response:", response, err)
+	response, err := client.Ping(ctx).Result()
+	if err != nil {
+		log.Default().Println(err, "Unable to connect to Railway Redis, carrying on without it until it answers")
+		return client, nil
 	}
 
-	return client
+	fmt.Println("Connected to Railway Redis! Respnse:", response)
+	return client, nil
 }
-
-func getRedisCache() *cache.Cache {
-	taskcache := cache.New(&cache.Options{
-		Redis: getRedisClient(),
-	})
-
-	return taskcache
-}
-
-// Redis client cache instance
-var Cache *cache.Cache = getRedisCache()
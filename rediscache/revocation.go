@@ -0,0 +1,234 @@
+package rediscache
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/hauchongtang/splatbackend/clock"
+)
+
+// RevocationStore remembers revoked tokens until they would have expired anyway.
+// Single tokens are revoked by their id, and a user can have every token issued up to a point in time revoked.
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, tokenId string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenId string) (bool, error)
+	RevokeUser(ctx context.Context, userId string, issuedBefore time.Time, expiresAt time.Time) error
+	UserRevokedBefore(ctx context.Context, userId string) (time.Time, error)
+}
+
+func revokedTokenKey(tokenId string) string {
+	return "revoked:token:" + tokenId
+}
+
+func revokedUserKey(userId string) string {
+	return "revoked:user:" + userId
+}
+
+// RedisRevocationStore shares revocations between every instance of the API
+type RedisRevocationStore struct {
+	client *redis.Client
+}
+
+func NewRedisRevocationStore(client *redis.Client) *RedisRevocationStore {
+	return &RedisRevocationStore{client: client}
+}
+
+func (r *RedisRevocationStore) RevokeToken(ctx context.Context, tokenId string, expiresAt time.Time) error {
+	return r.client.Set(ctx, revokedTokenKey(tokenId), 1, time.Until(expiresAt)).Err()
+}
+
+func (r *RedisRevocationStore) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	count, err := r.client.Exists(ctx, revokedTokenKey(tokenId)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (r *RedisRevocationStore) RevokeUser(ctx context.Context, userId string, issuedBefore time.Time, expiresAt time.Time) error {
+	return r.client.Set(ctx, revokedUserKey(userId), issuedBefore.UTC().Format(time.RFC3339Nano), time.Until(expiresAt)).Err()
+}
+
+func (r *RedisRevocationStore) UserRevokedBefore(ctx context.Context, userId string) (time.Time, error) {
+	value, err := r.client.Get(ctx, revokedUserKey(userId)).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	issuedBefore, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return issuedBefore, nil
+	}
+
+	// Revocations stored before they were kept to the nanosecond are Unix seconds
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(seconds, 0), nil
+}
+
+type revocationEntry struct {
+	value     time.Time
+	expiresAt time.Time
+}
+
+// revocationPruneInterval is how often writes to a MemoryRevocationStore drop the revocations that expired
+const revocationPruneInterval = time.Minute
+
+// MemoryRevocationStore keeps revocations in process. It is only visible to the instance that wrote it.
+type MemoryRevocationStore struct {
+	mu       sync.Mutex
+	tokens   map[string]time.Time
+	users    map[string]revocationEntry
+	prunedAt time.Time
+	clock    clock.Clock
+}
+
+func NewMemoryRevocationStore(clock clock.Clock) *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]revocationEntry),
+		clock:  clock,
+	}
+}
+
+// prune drops the expired revocations, at most once per revocationPruneInterval, so that revocations of tokens that
+// are never seen again do not pile up. The caller holds the lock.
+func (m *MemoryRevocationStore) prune() {
+	now := m.clock.Now()
+	if now.Sub(m.prunedAt) < revocationPruneInterval {
+		return
+	}
+	m.prunedAt = now
+
+	for tokenId, expiresAt := range m.tokens {
+		if now.After(expiresAt) {
+			delete(m.tokens, tokenId)
+		}
+	}
+	for userId, entry := range m.users {
+		if now.After(entry.expiresAt) {
+			delete(m.users, userId)
+		}
+	}
+}
+
+func (m *MemoryRevocationStore) RevokeToken(ctx context.Context, tokenId string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+	m.tokens[tokenId] = expiresAt
+	return nil
+}
+
+func (m *MemoryRevocationStore) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt, found := m.tokens[tokenId]
+	if found && m.clock.Now().After(expiresAt) {
+		delete(m.tokens, tokenId)
+		return false, nil
+	}
+
+	return found, nil
+}
+
+func (m *MemoryRevocationStore) RevokeUser(ctx context.Context, userId string, issuedBefore time.Time, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+	m.users[userId] = revocationEntry{value: issuedBefore, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *MemoryRevocationStore) UserRevokedBefore(ctx context.Context, userId string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, found := m.users[userId]
+	if !found {
+		return time.Time{}, nil
+	}
+	if m.clock.Now().After(entry.expiresAt) {
+		delete(m.users, userId)
+		return time.Time{}, nil
+	}
+
+	return entry.value, nil
+}
+
+// FallbackRevocationStore writes every revocation to both stores and consults both when reading,
+// so revocations made on this instance keep working while the primary store is unreachable.
+type FallbackRevocationStore struct {
+	primary  RevocationStore
+	fallback RevocationStore
+}
+
+func NewFallbackRevocationStore(primary RevocationStore, fallback RevocationStore) *FallbackRevocationStore {
+	return &FallbackRevocationStore{primary: primary, fallback: fallback}
+}
+
+func (f *FallbackRevocationStore) RevokeToken(ctx context.Context, tokenId string, expiresAt time.Time) error {
+	f.fallback.RevokeToken(ctx, tokenId, expiresAt)
+
+	err := f.primary.RevokeToken(ctx, tokenId, expiresAt)
+	if err != nil { // Not fatal, this instance still honours the revocation
+		log.Default().Println(err, "Unable to store token revocation, using in-memory revocation list")
+	}
+
+	return nil
+}
+
+func (f *FallbackRevocationStore) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	revoked, _ := f.fallback.IsTokenRevoked(ctx, tokenId)
+	if revoked {
+		return true, nil
+	}
+
+	revoked, err := f.primary.IsTokenRevoked(ctx, tokenId)
+	if err != nil {
+		log.Default().Println(err, "Unable to read token revocation, using in-memory revocation list")
+		return false, nil
+	}
+
+	return revoked, nil
+}
+
+func (f *FallbackRevocationStore) RevokeUser(ctx context.Context, userId string, issuedBefore time.Time, expiresAt time.Time) error {
+	f.fallback.RevokeUser(ctx, userId, issuedBefore, expiresAt)
+
+	err := f.primary.RevokeUser(ctx, userId, issuedBefore, expiresAt)
+	if err != nil {
+		log.Default().Println(err, "Unable to store user revocation, using in-memory revocation list")
+	}
+
+	return nil
+}
+
+func (f *FallbackRevocationStore) UserRevokedBefore(ctx context.Context, userId string) (time.Time, error) {
+	before, _ := f.fallback.UserRevokedBefore(ctx, userId)
+
+	primaryBefore, err := f.primary.UserRevokedBefore(ctx, userId)
+	if err != nil {
+		log.Default().Println(err, "Unable to read user revocation, using in-memory revocation list")
+		return before, nil
+	}
+
+	if primaryBefore.After(before) {
+		return primaryBefore, nil
+	}
+	return before, nil
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lastUsedResolution limits how often the last use of a key is written
+const lastUsedResolution = time.Minute
+
+// ApiKeyRepository stores hashed API keys. Plain keys are never stored.
+type ApiKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewApiKeyRepository(namespace MongoNamespace) *ApiKeyRepository {
+	return &ApiKeyRepository{
+		collection: OpenCollection(namespace, "apikeys"),
+	}
+}
+
+func (r *ApiKeyRepository) Create(ctx context.Context, apiKey models.ApiKey) error {
+	_, err := r.collection.InsertOne(ctx, apiKey)
+	return err
+}
+
+// FindByUser lists the keys of a user, newest first
+func (r *ApiKeyRepository) FindByUser(ctx context.Context, userId string) ([]models.ApiKey, error) {
+	results := []models.ApiKey{}
+
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userId}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *ApiKeyRepository) FindByHash(ctx context.Context, keyHash string) (*models.ApiKey, error) {
+	result := models.ApiKey{}
+
+	err := r.collection.FindOne(ctx, bson.M{"key_hash": keyHash}).Decode(&result)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &result, nil
+}
+
+// Delete revokes a key of the user. It returns ErrNotFound when the user has no such key.
+func (r *ApiKeyRepository) Delete(ctx context.Context, userId string, keyId string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userId, "key_id": keyId})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *ApiKeyRepository) DeleteByUser(ctx context.Context, userId string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userId})
+	return err
+}
+
+// Touch records that a key was used. Writes are skipped when the recorded use is recent enough.
+func (r *ApiKeyRepository) Touch(ctx context.Context, keyId string, usedAt time.Time) error {
+	filter := bson.M{
+		"key_id": keyId,
+		"$or": bson.A{
+			bson.M{"last_used_at": nil},
+			bson.M{"last_used_at": bson.M{"$lt": usedAt.Add(-lastUsedResolution)}},
+		},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_used_at": usedAt}})
+	return err
+}
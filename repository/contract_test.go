@@ -0,0 +1,577 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// backend opens empty user and task stores of one kind, whose updates are dated by clock
+type backend struct {
+	name string
+	open func(t *testing.T, clock clock.Clock) (UserStore, TaskStore)
+}
+
+// backends are the stores the contract runs against. Memory and SQLite always run. PostgreSQL runs when
+// TEST_POSTGRES_URL is set, and MongoDB when TEST_MONGODB_URI is set, each in a database the tests may empty.
+func backends() []backend {
+	result := []backend{
+		{"memory", func(t *testing.T, clock clock.Clock) (UserStore, TaskStore) {
+			return NewMemoryUserStore(clock), NewMemoryTaskStore(clock)
+		}},
+		{"sqlite", func(t *testing.T, clock clock.Clock) (UserStore, TaskStore) {
+			return openTestSQL(t, SQLite, SQLiteFile(filepath.Join(t.TempDir(), "splat.db")), clock)
+		}},
+	}
+
+	if url := os.Getenv("TEST_POSTGRES_URL"); url != "" {
+		result = append(result, backend{"postgres", func(t *testing.T, clock clock.Clock) (UserStore, TaskStore) {
+			return openTestSQL(t, Postgres, url, clock)
+		}})
+	}
+
+	if uri := os.Getenv("TEST_MONGODB_URI"); uri != "" {
+		result = append(result, backend{"mongo", func(t *testing.T, clock clock.Clock) (UserStore, TaskStore) {
+			return openTestMongo(t, uri, clock)
+		}})
+	}
+
+	return result
+}
+
+func openTestSQL(t *testing.T, dialect SQLDialect, dataSource string, clock clock.Clock) (UserStore, TaskStore) {
+	ctx := context.Background()
+
+	database, err := OpenSQL(ctx, dialect, dataSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	err = database.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The PostgreSQL database is shared by the tests, which each start from empty tables
+	if dialect.Driver == Postgres.Driver {
+		_, err = database.exec(ctx, "TRUNCATE users, user_identities, tasks CASCADE")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewSQLUserRepository(database, clock), NewSQLTaskRepository(database, clock)
+}
+
+func openTestMongo(t *testing.T, uri string, clock clock.Clock) (UserStore, TaskStore) {
+	ctx := context.Background()
+
+	client, err := NewMongoClient(ctx, MongoConfig{URI: uri, MaxPoolSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every test gets a database of its own, dropped once it is over
+	namespace := MongoNamespace{Client: client, Database: "splat_test_" + primitive.NewObjectID().Hex()}
+	t.Cleanup(func() {
+		client.Database(namespace.Database).Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	err = MigrateMongo(ctx, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewUserRepository(namespace, ctx, clock), NewTaskRepository(namespace, ctx, clock)
+}
+
+// contractStart is when the stores of the contract are opened. Times are to the second, as every store keeps them.
+var contractStart = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// storeTest runs a test of the contract against every backend, each with stores of its own
+func storeTest(t *testing.T, test func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore)) {
+	for _, backend := range backends() {
+		t.Run(backend.name, func(t *testing.T) {
+			fake := clock.NewFake(contractStart)
+			users, tasks := backend.open(t, fake)
+			test(t, fake, users, tasks)
+		})
+	}
+}
+
+func newContractUser(email string, points int, verified bool) models.User {
+	id := primitive.NewObjectID()
+	firstName, lastName, password := "Test", "User", "password hash"
+
+	return models.User{
+		ID:             id,
+		User_id:        id.Hex(),
+		First_name:     &firstName,
+		Last_name:      &lastName,
+		Email:          &email,
+		Email_verified: &verified,
+		Password:       &password,
+		Points:         points,
+		User_type:      models.RoleUser,
+		Created_at:     contractStart,
+		Updated_at:     contractStart,
+	}
+}
+
+func insertUser(t *testing.T, users UserStore, user models.User) {
+	t.Helper()
+
+	err := users.InsertUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func findUser(t *testing.T, users UserStore, userId string) *models.User {
+	t.Helper()
+
+	user, err := users.FindUserById(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func expectErr(t *testing.T, err error, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
+
+func expectUpdatedAt(t *testing.T, user *models.User, want time.Time) {
+	t.Helper()
+
+	if !user.Updated_at.Equal(want) {
+		t.Errorf("Updated_at is %v, want %v", user.Updated_at, want)
+	}
+}
+
+func TestUserStoreFind(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		user := newContractUser("user@example.com", 5, true)
+		user.Timetable = "https://nusmods.com/timetable"
+		insertUser(t, users, user)
+
+		for name, find := range map[string]func() (*models.User, error){
+			"id":    func() (*models.User, error) { return users.FindUserById(ctx, user.User_id) },
+			"email": func() (*models.User, error) { return users.FindUserByEmail(ctx, "user@example.com") },
+		} {
+			found, err := find()
+			if err != nil {
+				t.Fatalf("by %s: %v", name, err)
+			}
+			if found.User_id != user.User_id || *found.Email != *user.Email || *found.First_name != "Test" ||
+				*found.Last_name != "User" || *found.Password != "password hash" || !found.IsEmailVerified() ||
+				found.Points != 5 || found.Timetable != user.Timetable || found.Role() != models.RoleUser ||
+				!found.Created_at.Equal(contractStart) {
+				t.Errorf("by %s, found %+v, want %+v", name, found, user)
+			}
+		}
+
+		unknown := primitive.NewObjectID().Hex()
+		_, err := users.FindUserById(ctx, unknown)
+		expectErr(t, err, ErrNotFound)
+		_, err = users.FindUserByEmail(ctx, "nobody@example.com")
+		expectErr(t, err, ErrNotFound)
+		_, err = users.FindUserByIdentity(ctx, "provider", "nobody")
+		expectErr(t, err, ErrNotFound)
+	})
+}
+
+func TestUserStoreDuplicateEmail(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		first := newContractUser("first@example.com", 0, true)
+		insertUser(t, users, first)
+		second := newContractUser("second@example.com", 0, true)
+		insertUser(t, users, second)
+
+		err := users.InsertUser(ctx, newContractUser("first@example.com", 0, true))
+		expectErr(t, err, ErrDuplicate)
+
+		taken := "first@example.com"
+		_, err = users.UpdateParticulars(ctx, second.User_id, models.UserParticulars{Email: &taken})
+		expectErr(t, err, ErrDuplicate)
+
+		// Keeping one's own email is no conflict
+		updated, err := users.UpdateParticulars(ctx, first.User_id, models.UserParticulars{Email: &taken})
+		if err != nil || *updated.Email != taken {
+			t.Errorf("got %v, %v keeping the same email", updated, err)
+		}
+	})
+}
+
+func TestUserStoreFindUsers(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		low := newContractUser("low@example.com", 1, true)
+		high := newContractUser("high@example.com", 10, true)
+		unverified := newContractUser("unverified@example.com", 5, false)
+		for _, user := range []models.User{low, high, unverified} {
+			insertUser(t, users, user)
+		}
+
+		for verifiedOnly, want := range map[bool][]string{
+			false: {high.User_id, unverified.User_id, low.User_id},
+			true:  {high.User_id, low.User_id},
+		} {
+			found, err := users.FindUsers(ctx, verifiedOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []string{}
+			for _, user := range *found {
+				ids = append(ids, user.User_id)
+			}
+			if !reflect.DeepEqual(ids, want) {
+				t.Errorf("verified only %v found %v, want %v", verifiedOnly, ids, want)
+			}
+		}
+	})
+}
+
+func TestUserStoreUpdates(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		user := newContractUser("user@example.com", 1, false)
+		insertUser(t, users, user)
+
+		clock.Advance(time.Minute)
+		now := clock.Now()
+
+		updated, err := users.AddPoints(ctx, user.User_id, 4)
+		if err != nil || updated.Points != 5 {
+			t.Fatalf("AddPoints returned %+v, %v", updated, err)
+		}
+		expectUpdatedAt(t, updated, now)
+
+		updated, err = users.SetTimetable(ctx, user.User_id, "https://nusmods.com/timetable")
+		if err != nil || updated.Timetable != "https://nusmods.com/timetable" {
+			t.Fatalf("SetTimetable returned %+v, %v", updated, err)
+		}
+
+		updated, err = users.SetRole(ctx, user.User_id, models.RoleModerator)
+		if err != nil || updated.Role() != models.RoleModerator {
+			t.Fatalf("SetRole returned %+v, %v", updated, err)
+		}
+
+		clock.Advance(time.Minute)
+		firstName, email := "Changed", "changed@example.com"
+		updated, err = users.UpdateParticulars(ctx, user.User_id, models.UserParticulars{First_name: &firstName, Email: &email})
+		if err != nil || *updated.First_name != firstName || *updated.Email != email || *updated.Last_name != "User" {
+			t.Fatalf("UpdateParticulars returned %+v, %v", updated, err)
+		}
+		expectUpdatedAt(t, updated, clock.Now())
+
+		err = users.SetPassword(ctx, user.User_id, "new hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = users.SetEmailVerified(ctx, user.User_id, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := findUser(t, users, user.User_id)
+		if *found.Password != "new hash" || !found.IsEmailVerified() || found.Points != 5 || *found.Email != email {
+			t.Errorf("found %+v after the updates", found)
+		}
+
+		// Updates of an unknown user find nothing
+		unknown := primitive.NewObjectID().Hex()
+		_, err = users.AddPoints(ctx, unknown, 1)
+		expectErr(t, err, ErrNotFound)
+		_, err = users.SetRole(ctx, unknown, models.RoleAdmin)
+		expectErr(t, err, ErrNotFound)
+		_, err = users.UpdateParticulars(ctx, unknown, models.UserParticulars{First_name: &firstName})
+		expectErr(t, err, ErrNotFound)
+		expectErr(t, users.SetPassword(ctx, unknown, "hash"), ErrNotFound)
+	})
+}
+
+func TestUserStoreRoles(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		user := newContractUser("user@example.com", 0, true)
+		insertUser(t, users, user)
+
+		hasAdmin, err := users.HasRole(ctx, models.RoleAdmin)
+		if err != nil || hasAdmin {
+			t.Fatalf("HasRole returned %v, %v without admins", hasAdmin, err)
+		}
+
+		_, err = users.SetRole(ctx, user.User_id, models.RoleAdmin)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hasAdmin, err = users.HasRole(ctx, models.RoleAdmin)
+		if err != nil || !hasAdmin {
+			t.Errorf("HasRole returned %v, %v with an admin", hasAdmin, err)
+		}
+	})
+}
+
+func TestUserStoreDelete(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		user := newContractUser("user@example.com", 0, true)
+		insertUser(t, users, user)
+
+		err := users.DeleteUser(ctx, user.User_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = users.FindUserById(ctx, user.User_id)
+		expectErr(t, err, ErrNotFound)
+		expectErr(t, users.DeleteUser(ctx, user.User_id), ErrNotFound)
+
+		// The email is free again
+		insertUser(t, users, newContractUser("user@example.com", 0, true))
+	})
+}
+
+func TestUserStoreLogins(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		user := newContractUser("user@example.com", 0, false)
+		token, refreshToken := "legacy token", "legacy refresh token"
+		user.Token, user.Refresh_token = &token, &refreshToken
+		insertUser(t, users, user)
+
+		at := contractStart.Add(time.Hour)
+		err := users.RecordLogin(ctx, user.User_id, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := findUser(t, users, user.User_id)
+		expectUpdatedAt(t, found, at)
+		if found.Token != nil || found.Refresh_token != nil {
+			t.Errorf("the login kept the stored tokens %v, %v", found.Token, found.Refresh_token)
+		}
+
+		identity := models.Identity{Provider: "google", Subject: "subject", Email: "user@example.com", Linked_at: contractStart}
+		err = users.LinkIdentity(ctx, user.User_id, identity, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		linked, err := users.FindUserByIdentity(ctx, "google", "subject")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if linked.User_id != user.User_id || !linked.IsEmailVerified() || linked.Password != nil {
+			t.Errorf("linking found %+v, want a verified user without password", linked)
+		}
+		if len(linked.Identities) != 1 || linked.Identities[0].Provider != "google" || linked.Identities[0].Subject != "subject" {
+			t.Errorf("linking kept the identities %+v", linked.Identities)
+		}
+	})
+}
+
+func TestUserStoreLinkDropsSecondFactor(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		user := newContractUser("user@example.com", 0, false)
+		insertUser(t, users, user)
+
+		// Whoever held the account enrolled once, and started enrolling again
+		err := users.SetPendingTwoFactor(ctx, user.User_id, "enrolled secret", []string{"a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = users.EnableTwoFactor(ctx, user.User_id, "enrolled secret", []string{"a"}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = users.SetPendingTwoFactor(ctx, user.User_id, "pending secret", []string{"b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		identity := models.Identity{Provider: "google", Subject: "subject", Email: "user@example.com", Linked_at: contractStart}
+		err = users.LinkIdentity(ctx, user.User_id, identity, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := findUser(t, users, user.User_id)
+		if found.Two_factor_enabled || found.Totp_secret != nil || found.Totp_last_step != 0 || len(found.Recovery_codes) != 0 ||
+			found.Totp_pending_secret != nil || len(found.Pending_recovery_codes) != 0 {
+			t.Errorf("linking without the password left the second factor %+v", found)
+		}
+	})
+}
+
+func TestUserStoreTwoFactor(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		user := newContractUser("user@example.com", 0, true)
+		insertUser(t, users, user)
+
+		err := users.SetPendingTwoFactor(ctx, user.User_id, "first secret", []string{"old"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = users.SetPendingTwoFactor(ctx, user.User_id, "second secret", []string{"a", "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the latest enrollment can be confirmed
+		err = users.EnableTwoFactor(ctx, user.User_id, "first secret", []string{"old"}, 10)
+		expectErr(t, err, ErrNotFound)
+
+		err = users.EnableTwoFactor(ctx, user.User_id, "second secret", []string{"a", "b"}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := findUser(t, users, user.User_id)
+		if !found.Two_factor_enabled || found.Totp_secret == nil || *found.Totp_secret != "second secret" ||
+			found.Totp_pending_secret != nil || len(found.Pending_recovery_codes) != 0 || len(found.Recovery_codes) != 2 {
+			t.Errorf("enabling two factor left %+v", found)
+		}
+
+		for _, step := range []struct {
+			step int64
+			want bool
+		}{{10, false}, {9, false}, {11, true}, {11, false}} {
+			used, err := users.UseTOTPStep(ctx, user.User_id, step.step)
+			if err != nil || used != step.want {
+				t.Errorf("UseTOTPStep(%d) returned %v, %v, want %v", step.step, used, err, step.want)
+			}
+		}
+
+		for _, code := range []struct {
+			code string
+			want bool
+		}{{"a", true}, {"a", false}, {"old", false}, {"b", true}} {
+			used, err := users.UseRecoveryCode(ctx, user.User_id, code.code)
+			if err != nil || used != code.want {
+				t.Errorf("UseRecoveryCode(%s) returned %v, %v, want %v", code.code, used, err, code.want)
+			}
+		}
+
+		err = users.DisableTwoFactor(ctx, user.User_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found = findUser(t, users, user.User_id)
+		if found.Two_factor_enabled || found.Totp_secret != nil || len(found.Recovery_codes) != 0 {
+			t.Errorf("disabling two factor left %+v", found)
+		}
+	})
+}
+
+func newContractTask(userId string, module string, created time.Time) models.Task {
+	name, duration := "Revision", "30"
+	return models.Task{
+		ID:          primitive.NewObjectID(),
+		Task_name:   &name,
+		Module_code: &module,
+		Duration:    &duration,
+		User_id:     userId,
+		Created_at:  created,
+		Updated_at:  created,
+	}
+}
+
+func taskIds(tasks []models.Task) []string {
+	ids := []string{}
+	for _, task := range tasks {
+		ids = append(ids, task.ID.Hex())
+	}
+	return ids
+}
+
+func TestTaskStore(t *testing.T) {
+	storeTest(t, func(t *testing.T, clock *clock.Fake, users UserStore, tasks TaskStore) {
+		ctx := context.Background()
+		owner, other := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+
+		// Tasks are added a second apart, so that every store tells the newest one
+		var added []models.Task
+		for i, task := range []struct{ userId, module string }{{owner, "CS1010"}, {other, "CS2040"}, {owner, "CS1010"}} {
+			added = append(added, newContractTask(task.userId, task.module, contractStart.Add(time.Duration(i)*time.Second)))
+			err := tasks.InsertTask(ctx, added[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		found, err := tasks.FindTaskById(ctx, added[1].ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.User_id != other || *found.Module_code != "CS2040" || *found.Task_name != "Revision" || found.Hidden {
+			t.Errorf("found %+v, want %+v", found, added[1])
+		}
+		_, err = tasks.FindTaskById(ctx, primitive.NewObjectID().Hex())
+		expectErr(t, err, ErrNotFound)
+
+		all, err := tasks.FindTasks(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{added[2].ID.Hex(), added[1].ID.Hex(), added[0].ID.Hex()}; !reflect.DeepEqual(taskIds(all), want) {
+			t.Errorf("found tasks %v, want the newest first %v", taskIds(all), want)
+		}
+
+		ofOwner, err := tasks.FindTasksByUser(ctx, owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{added[2].ID.Hex(), added[0].ID.Hex()}; !reflect.DeepEqual(taskIds(ofOwner), want) {
+			t.Errorf("found tasks %v of the owner, want %v", taskIds(ofOwner), want)
+		}
+
+		clock.Advance(time.Minute)
+		hidden, err := tasks.SetTaskHidden(ctx, added[0].ID.Hex(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hidden.Hidden || !hidden.Updated_at.Equal(clock.Now()) {
+			t.Errorf("hiding returned %+v, want hidden at %v", hidden, clock.Now())
+		}
+		if found, err := tasks.FindTaskById(ctx, added[0].ID.Hex()); err != nil || !found.Hidden {
+			t.Errorf("found %+v, %v after hiding", found, err)
+		}
+		_, err = tasks.SetTaskHidden(ctx, primitive.NewObjectID().Hex(), true)
+		expectErr(t, err, ErrNotFound)
+
+		popularity, err := tasks.ModulePopularity(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[string]int)
+		for _, module := range popularity {
+			counts[*module.ID.Module_code] = module.Count
+		}
+		if want := map[string]int{"CS1010": 2, "CS2040": 1}; !reflect.DeepEqual(counts, want) {
+			t.Errorf("module popularity is %v, want %v", counts, want)
+		}
+	})
+}
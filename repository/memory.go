@@ -0,0 +1,425 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// copyUser returns a user that shares no slices with the stored one
+func copyUser(user models.User) models.User {
+	user.Recovery_codes = append([]string(nil), user.Recovery_codes...)
+	user.Pending_recovery_codes = append([]string(nil), user.Pending_recovery_codes...)
+	user.Identities = append([]models.Identity(nil), user.Identities...)
+	return user
+}
+
+// MemoryUserStore keeps users in process. It is meant for tests and local development, and loses everything on restart.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]*models.User
+	clock clock.Clock
+}
+
+func NewMemoryUserStore(clock clock.Clock) *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[string]*models.User), clock: clock}
+}
+
+func (m *MemoryUserStore) find(match func(user *models.User) bool) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if match(user) {
+			found := copyUser(*user)
+			return &found, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// update applies change to a user, after setting Updated_at to now, and returns the user as it is afterwards
+func (m *MemoryUserStore) update(userId string, change func(user *models.User)) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, found := m.users[userId]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	user.Updated_at = updatedNow(m.clock)
+	change(user)
+	updated := copyUser(*user)
+	return &updated, nil
+}
+
+// emailTaken reports whether a user other than userId has the email
+func (m *MemoryUserStore) emailTaken(email *string, userId string) bool {
+	if email == nil {
+		return false
+	}
+
+	for _, user := range m.users {
+		if user.User_id != userId && user.Email != nil && *user.Email == *email {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *MemoryUserStore) InsertUser(ctx context.Context, user models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email, user.User_id) {
+		return ErrDuplicate
+	}
+
+	stored := copyUser(user)
+	m.users[user.User_id] = &stored
+	return nil
+}
+
+func (m *MemoryUserStore) FindUserById(ctx context.Context, targetId string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, found := m.users[targetId]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	result := copyUser(*user)
+	return &result, nil
+}
+
+func (m *MemoryUserStore) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return m.find(func(user *models.User) bool {
+		return user.Email != nil && *user.Email == email
+	})
+}
+
+func (m *MemoryUserStore) FindUserByIdentity(ctx context.Context, provider string, subject string) (*models.User, error) {
+	return m.find(func(user *models.User) bool {
+		for _, identity := range user.Identities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (m *MemoryUserStore) FindUsers(ctx context.Context, verifiedOnly bool) (*[]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]models.User, 0, len(m.users))
+	for _, user := range m.users {
+		if verifiedOnly && !user.IsEmailVerified() {
+			continue
+		}
+		result = append(result, copyUser(*user))
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Points > result[j].Points
+	})
+
+	return &result, nil
+}
+
+func (m *MemoryUserStore) DeleteUser(ctx context.Context, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.users[userId]; !found {
+		return ErrNotFound
+	}
+
+	delete(m.users, userId)
+	return nil
+}
+
+func (m *MemoryUserStore) UpdateParticulars(ctx context.Context, userId string, particulars models.UserParticulars) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, found := m.users[userId]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	if m.emailTaken(particulars.Email, userId) {
+		return nil, ErrDuplicate
+	}
+
+	user.Updated_at = updatedNow(m.clock)
+	if particulars.First_name != nil {
+		user.First_name = particulars.First_name
+	}
+	if particulars.Last_name != nil {
+		user.Last_name = particulars.Last_name
+	}
+	if particulars.Email != nil {
+		user.Email = particulars.Email
+	}
+	if particulars.Email_verified != nil {
+		user.Email_verified = particulars.Email_verified
+	}
+	if particulars.Password != nil {
+		user.Password = particulars.Password
+	}
+
+	updated := copyUser(*user)
+	return &updated, nil
+}
+
+func (m *MemoryUserStore) AddPoints(ctx context.Context, userId string, points int) (*models.User, error) {
+	return m.update(userId, func(user *models.User) {
+		user.Points += points
+	})
+}
+
+func (m *MemoryUserStore) SetTimetable(ctx context.Context, userId string, timetable string) (*models.User, error) {
+	return m.update(userId, func(user *models.User) {
+		user.Timetable = timetable
+	})
+}
+
+func (m *MemoryUserStore) SetRole(ctx context.Context, userId string, role string) (*models.User, error) {
+	return m.update(userId, func(user *models.User) {
+		user.User_type = role
+	})
+}
+
+func (m *MemoryUserStore) HasRole(ctx context.Context, role string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Role() == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryUserStore) SetPassword(ctx context.Context, userId string, passwordHash string) error {
+	_, err := m.update(userId, func(user *models.User) {
+		user.Password = &passwordHash
+	})
+	return err
+}
+
+func (m *MemoryUserStore) SetEmailVerified(ctx context.Context, userId string, verified bool) error {
+	_, err := m.update(userId, func(user *models.User) {
+		user.Email_verified = &verified
+	})
+	return err
+}
+
+func (m *MemoryUserStore) RecordLogin(ctx context.Context, userId string, at time.Time) error {
+	_, err := m.update(userId, func(user *models.User) {
+		user.Updated_at = at
+		user.Token = nil
+		user.Refresh_token = nil
+	})
+	return err
+}
+
+func (m *MemoryUserStore) LinkIdentity(ctx context.Context, userId string, identity models.Identity, dropPassword bool) error {
+	verified := true
+	_, err := m.update(userId, func(user *models.User) {
+		user.Identities = append(user.Identities, identity)
+		user.Email_verified = &verified
+		if dropPassword {
+			user.Password = nil
+			user.Two_factor_enabled = false
+			user.Totp_secret = nil
+			user.Totp_last_step = 0
+			user.Recovery_codes = nil
+			user.Totp_pending_secret = nil
+			user.Pending_recovery_codes = nil
+		}
+	})
+	return err
+}
+
+func (m *MemoryUserStore) SetPendingTwoFactor(ctx context.Context, userId string, secret string, recoveryCodeHashes []string) error {
+	_, err := m.update(userId, func(user *models.User) {
+		user.Totp_pending_secret = &secret
+		user.Pending_recovery_codes = append([]string(nil), recoveryCodeHashes...)
+	})
+	return err
+}
+
+func (m *MemoryUserStore) EnableTwoFactor(ctx context.Context, userId string, pendingSecret string, recoveryCodeHashes []string, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, found := m.users[userId]
+	if !found || user.Totp_pending_secret == nil || *user.Totp_pending_secret != pendingSecret {
+		return ErrNotFound
+	}
+
+	user.Two_factor_enabled = true
+	user.Totp_secret = &pendingSecret
+	user.Totp_last_step = step
+	user.Recovery_codes = append([]string(nil), recoveryCodeHashes...)
+	user.Totp_pending_secret = nil
+	user.Pending_recovery_codes = nil
+	user.Updated_at = updatedNow(m.clock)
+	return nil
+}
+
+func (m *MemoryUserStore) DisableTwoFactor(ctx context.Context, userId string) error {
+	_, err := m.update(userId, func(user *models.User) {
+		user.Two_factor_enabled = false
+		user.Totp_secret = nil
+		user.Totp_last_step = 0
+		user.Recovery_codes = nil
+	})
+	return err
+}
+
+func (m *MemoryUserStore) UseTOTPStep(ctx context.Context, userId string, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, found := m.users[userId]
+	if !found || user.Totp_last_step >= step {
+		return false, nil
+	}
+
+	user.Totp_last_step = step
+	return true, nil
+}
+
+func (m *MemoryUserStore) UseRecoveryCode(ctx context.Context, userId string, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, found := m.users[userId]
+	if !found {
+		return false, nil
+	}
+
+	for i, code := range user.Recovery_codes {
+		if code == codeHash {
+			user.Recovery_codes = append(user.Recovery_codes[:i:i], user.Recovery_codes[i+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// MemoryTaskStore keeps tasks in process, in the order they were added. Like MemoryUserStore it is not persisted.
+type MemoryTaskStore struct {
+	mu    sync.RWMutex
+	tasks []models.Task
+	clock clock.Clock
+}
+
+func NewMemoryTaskStore(clock clock.Clock) *MemoryTaskStore {
+	return &MemoryTaskStore{clock: clock}
+}
+
+func (m *MemoryTaskStore) InsertTask(ctx context.Context, task models.Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tasks = append(m.tasks, task)
+	return nil
+}
+
+func (m *MemoryTaskStore) FindTaskById(ctx context.Context, targetId string) (*models.Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, task := range m.tasks {
+		if task.ID.Hex() == targetId {
+			return &task, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// newestFirst lists the matching tasks, the most recently added first
+func (m *MemoryTaskStore) newestFirst(match func(task models.Task) bool) []models.Task {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]models.Task, 0)
+	for i := len(m.tasks) - 1; i >= 0; i-- {
+		if match(m.tasks[i]) {
+			result = append(result, m.tasks[i])
+		}
+	}
+
+	return result
+}
+
+func (m *MemoryTaskStore) FindTasks(ctx context.Context) ([]models.Task, error) {
+	return m.newestFirst(func(task models.Task) bool { return true }), nil
+}
+
+func (m *MemoryTaskStore) FindTasksByUser(ctx context.Context, userId string) ([]models.Task, error) {
+	return m.newestFirst(func(task models.Task) bool { return task.User_id == userId }), nil
+}
+
+func (m *MemoryTaskStore) SetTaskHidden(ctx context.Context, targetId string, hidden bool) (*models.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.tasks {
+		if m.tasks[i].ID.Hex() == targetId {
+			m.tasks[i].Hidden = hidden
+			m.tasks[i].Updated_at = m.clock.Now()
+			task := m.tasks[i]
+			return &task, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *MemoryTaskStore) ModulePopularity(ctx context.Context) ([]models.ModulePopularity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]models.ModulePopularity, 0)
+	counts := make(map[string]int)
+	for _, task := range m.tasks {
+		code := ""
+		if task.Module_code != nil {
+			code = *task.Module_code
+		}
+
+		index, seen := counts[code]
+		if !seen {
+			index = len(results)
+			counts[code] = index
+			results = append(results, models.ModulePopularity{ID: models.ModuleKey{Module_code: task.Module_code}})
+		}
+		results[index].Count++
+	}
+
+	return results, nil
+}
+
+var (
+	_ UserStore = (*UserRepository)(nil)
+	_ UserStore = (*MemoryUserStore)(nil)
+	_ TaskStore = (*TaskRepository)(nil)
+	_ TaskStore = (*MemoryTaskStore)(nil)
+)
@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// MemorySessionStore keeps sessions in process. Like the other memory stores it is not persisted.
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*models.Session
+	clock    clock.Clock
+}
+
+func NewMemorySessionStore(clock clock.Clock) *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]*models.Session), clock: clock}
+}
+
+func isActiveSession(session *models.Session, now time.Time) bool {
+	return session.Revoked_at == nil && session.Expires_at.After(now)
+}
+
+func (m *MemorySessionStore) Create(ctx context.Context, session models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[session.Session_id] = &session
+	return nil
+}
+
+func (m *MemorySessionStore) FindActiveById(ctx context.Context, sessionId string) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, found := m.sessions[sessionId]
+	if !found || !isActiveSession(session, m.clock.Now()) {
+		return nil, ErrNotFound
+	}
+
+	result := *session
+	return &result, nil
+}
+
+func (m *MemorySessionStore) FindActiveByUser(ctx context.Context, userId string) ([]models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.clock.Now()
+	results := []models.Session{}
+	for _, session := range m.sessions {
+		if session.User_id == userId && isActiveSession(session, now) {
+			results = append(results, *session)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Last_seen_at.After(results[j].Last_seen_at)
+	})
+
+	return results, nil
+}
+
+func (m *MemorySessionStore) Rotate(ctx context.Context, sessionId string, presentedHash string, session models.Session) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.sessions[sessionId]
+	if !found || !isActiveSession(stored, m.clock.Now()) || stored.Refresh_token_hash != presentedHash {
+		return false, nil
+	}
+
+	stored.Refresh_token_hash = session.Refresh_token_hash
+	stored.Ip = session.Ip
+	stored.User_agent = session.User_agent
+	stored.Last_seen_at = session.Last_seen_at
+	stored.Expires_at = session.Expires_at
+	return true, nil
+}
+
+func (m *MemorySessionStore) Touch(ctx context.Context, sessionId string, seenAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, found := m.sessions[sessionId]; found && session.Last_seen_at.Before(seenAt) {
+		session.Last_seen_at = seenAt
+	}
+
+	return nil
+}
+
+func (m *MemorySessionStore) Revoke(ctx context.Context, userId string, sessionId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	session, found := m.sessions[sessionId]
+	if !found || session.User_id != userId || !isActiveSession(session, now) {
+		return ErrNotFound
+	}
+
+	session.Revoked_at = &now
+	return nil
+}
+
+func (m *MemorySessionStore) RevokeAllByUser(ctx context.Context, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	for _, session := range m.sessions {
+		if session.User_id == userId && session.Revoked_at == nil {
+			session.Revoked_at = &now
+		}
+	}
+
+	return nil
+}
+
+// MemoryApiKeyStore keeps hashed API keys in process
+type MemoryApiKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*models.ApiKey
+}
+
+func NewMemoryApiKeyStore() *MemoryApiKeyStore {
+	return &MemoryApiKeyStore{keys: make(map[string]*models.ApiKey)}
+}
+
+func (m *MemoryApiKeyStore) Create(ctx context.Context, apiKey models.ApiKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	apiKey.Scopes = append([]string(nil), apiKey.Scopes...)
+	m.keys[apiKey.Key_id] = &apiKey
+	return nil
+}
+
+func (m *MemoryApiKeyStore) FindByUser(ctx context.Context, userId string) ([]models.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := []models.ApiKey{}
+	for _, apiKey := range m.keys {
+		if apiKey.User_id == userId {
+			results = append(results, *apiKey)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Created_at.After(results[j].Created_at)
+	})
+
+	return results, nil
+}
+
+func (m *MemoryApiKeyStore) FindByHash(ctx context.Context, keyHash string) (*models.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, apiKey := range m.keys {
+		if apiKey.Key_hash == keyHash {
+			result := *apiKey
+			return &result, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *MemoryApiKeyStore) Delete(ctx context.Context, userId string, keyId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	apiKey, found := m.keys[keyId]
+	if !found || apiKey.User_id != userId {
+		return ErrNotFound
+	}
+
+	delete(m.keys, keyId)
+	return nil
+}
+
+func (m *MemoryApiKeyStore) DeleteByUser(ctx context.Context, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for keyId, apiKey := range m.keys {
+		if apiKey.User_id == userId {
+			delete(m.keys, keyId)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryApiKeyStore) Touch(ctx context.Context, keyId string, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if apiKey, found := m.keys[keyId]; found {
+		apiKey.Last_used_at = &usedAt
+	}
+
+	return nil
+}
+
+// MemoryOneTimeTokenStore keeps hashed single use tokens in process
+type MemoryOneTimeTokenStore struct {
+	mu     sync.Mutex
+	tokens []*models.OneTimeToken
+	clock  clock.Clock
+}
+
+func NewMemoryOneTimeTokenStore(clock clock.Clock) *MemoryOneTimeTokenStore {
+	return &MemoryOneTimeTokenStore{clock: clock}
+}
+
+func (m *MemoryOneTimeTokenStore) Issue(ctx context.Context, userId string, purpose string, tokenHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	for _, token := range m.tokens {
+		if token.User_id == userId && token.Purpose == purpose && token.Used_at == nil {
+			token.Used_at = &now
+		}
+	}
+
+	m.tokens = append(m.tokens, &models.OneTimeToken{
+		Token_hash: tokenHash,
+		User_id:    userId,
+		Purpose:    purpose,
+		Expires_at: expiresAt,
+		Created_at: now,
+	})
+	return nil
+}
+
+func (m *MemoryOneTimeTokenStore) Consume(ctx context.Context, tokenHash string, purpose string) (*models.OneTimeToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	for _, token := range m.tokens {
+		if token.Token_hash == tokenHash && token.Purpose == purpose && token.Used_at == nil && token.Expires_at.After(now) {
+			token.Used_at = &now
+			result := *token
+			return &result, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+var (
+	_ SessionStore      = (*SessionRepository)(nil)
+	_ SessionStore      = (*MemorySessionStore)(nil)
+	_ ApiKeyStore       = (*ApiKeyRepository)(nil)
+	_ ApiKeyStore       = (*MemoryApiKeyStore)(nil)
+	_ OneTimeTokenStore = (*OneTimeTokenRepository)(nil)
+	_ OneTimeTokenStore = (*MemoryOneTimeTokenStore)(nil)
+)
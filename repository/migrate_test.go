@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// expectEmailConflicts fails unless err names the conflicting email and every user having it
+func expectEmailConflicts(t *testing.T, err error, email string, userIds ...string) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatal("the migration lowercased emails that differ only in case between users")
+	}
+	if !strings.Contains(err.Error(), email) {
+		t.Errorf("the error does not name the email %s: %v", email, err)
+	}
+	for _, userId := range userIds {
+		if !strings.Contains(err.Error(), userId) {
+			t.Errorf("the error does not name user %s: %v", userId, err)
+		}
+	}
+}
+
+func TestSQLMigrateRefusesEmailsDifferingInCase(t *testing.T) {
+	ctx := context.Background()
+
+	database, err := OpenSQL(ctx, SQLite, SQLiteFile(filepath.Join(t.TempDir(), "splat.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	err = database.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users from before emails were lowercased, and the migration to lowercase them left to apply again
+	insert := "INSERT INTO users (id, user_id, email, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
+	for _, user := range [][2]string{{"first", "User@example.com"}, {"second", "user@EXAMPLE.com"}, {"other", "Other@example.com"}} {
+		_, err = database.exec(ctx, insert, user[0], user[0], user[1], time.Now(), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = database.exec(ctx, "DELETE FROM schema_migrations WHERE version = ?", "0002_lowercase_emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectEmailConflicts(t, database.Migrate(ctx), "user@example.com", "first", "second")
+
+	// Once only one user has the email, the migration goes through
+	_, err = database.exec(ctx, "UPDATE users SET email = ? WHERE user_id = ?", "Second@example.com", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var email string
+	err = database.queryRow(ctx, "SELECT email FROM users WHERE user_id = ?", "other").Scan(&email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email != "other@example.com" {
+		t.Errorf("the email was migrated to %q, want it lowercased", email)
+	}
+}
+
+func TestMongoMigrateRefusesEmailsDifferingInCase(t *testing.T) {
+	uri := os.Getenv("TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGODB_URI is not set")
+	}
+	ctx := context.Background()
+
+	client, err := NewMongoClient(ctx, MongoConfig{URI: uri, MaxPoolSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespace := MongoNamespace{Client: client, Database: "splat_test_" + primitive.NewObjectID().Hex()}
+	defer func() {
+		client.Database(namespace.Database).Drop(ctx)
+		client.Disconnect(ctx)
+	}()
+
+	_, err = OpenCollection(namespace, "users").InsertMany(ctx, []interface{}{
+		bson.M{"user_id": "first", "email": "User@example.com"},
+		bson.M{"user_id": "second", "email": "user@EXAMPLE.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectEmailConflicts(t, MigrateMongo(ctx, namespace), "user@example.com", "first", "second")
+}
@@ -0,0 +1,93 @@
+CREATE TABLE users (
+    id                     TEXT NOT NULL,
+    user_id                TEXT PRIMARY KEY,
+    first_name             TEXT,
+    last_name              TEXT,
+    password               TEXT,
+    email                  TEXT UNIQUE,
+    email_verified         BOOLEAN,
+    created_at             TIMESTAMPTZ NOT NULL,
+    updated_at             TIMESTAMPTZ NOT NULL,
+    points                 INTEGER NOT NULL DEFAULT 0,
+    timetable              TEXT NOT NULL DEFAULT '',
+    user_type              TEXT NOT NULL DEFAULT '',
+    two_factor_enabled     BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_secret            TEXT,
+    totp_last_step         BIGINT NOT NULL DEFAULT 0,
+    recovery_codes         TEXT,
+    totp_pending_secret    TEXT,
+    pending_recovery_codes TEXT
+);
+
+-- The leaderboard lists users by points
+CREATE INDEX users_points_idx ON users (points DESC);
+
+CREATE TABLE user_identities (
+    provider  TEXT NOT NULL,
+    subject   TEXT NOT NULL,
+    user_id   TEXT NOT NULL REFERENCES users (user_id),
+    email     TEXT NOT NULL DEFAULT '',
+    linked_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+CREATE TABLE tasks (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL,
+    first_name  TEXT,
+    last_name   TEXT,
+    task_name   TEXT,
+    module_code TEXT,
+    duration    TEXT,
+    hidden      BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX tasks_user_id_idx ON tasks (user_id, id DESC);
+CREATE INDEX tasks_module_code_idx ON tasks (module_code);
+
+CREATE TABLE sessions (
+    id                 TEXT NOT NULL,
+    session_id         TEXT PRIMARY KEY,
+    user_id            TEXT NOT NULL,
+    refresh_token_hash TEXT NOT NULL,
+    device             TEXT NOT NULL DEFAULT '',
+    ip                 TEXT NOT NULL DEFAULT '',
+    user_agent         TEXT NOT NULL DEFAULT '',
+    created_at         TIMESTAMPTZ NOT NULL,
+    last_seen_at       TIMESTAMPTZ NOT NULL,
+    expires_at         TIMESTAMPTZ NOT NULL,
+    revoked_at         TIMESTAMPTZ
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+
+CREATE TABLE api_keys (
+    id           TEXT NOT NULL,
+    key_id       TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL,
+    name         TEXT NOT NULL,
+    scopes       TEXT,
+    prefix       TEXT NOT NULL,
+    key_hash     TEXT NOT NULL UNIQUE,
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
+
+CREATE TABLE one_time_tokens (
+    id         TEXT PRIMARY KEY,
+    token_hash TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    purpose    TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX one_time_tokens_token_hash_idx ON one_time_tokens (token_hash);
+CREATE INDEX one_time_tokens_user_id_idx ON one_time_tokens (user_id, purpose);
@@ -0,0 +1,2 @@
+-- Emails are compared in lowercase since they are normalized on sign up and log in
+UPDATE users SET email = LOWER(email) WHERE email <> LOWER(email);
@@ -3,68 +3,54 @@ package repository
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
-	"strconv"
 	"time"
 
-	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var (
-	mongoCtx    = context.Background()
-	mongoClient *mongo.Client
-)
+// MongoConfig tells how to reach MongoDB
+type MongoConfig struct {
+	URI string
+	// Database holds the collections, unless tenants are given databases of their own
+	Database    string
+	MinPoolSize uint64
+	MaxPoolSize uint64
+	MaxIdleTime time.Duration
+}
+
+// NewMongoClient connects to MongoDB and checks that it answers
+func NewMongoClient(ctx context.Context, config MongoConfig) (*mongo.Client, error) {
+	clientOptions := options.Client().ApplyURI(config.URI)
 
-func GetMongoClient() *mongo.Client {
-	err := godotenv.Load(".env")
+	clientOptions.SetMinPoolSize(config.MinPoolSize)
+	clientOptions.SetMaxPoolSize(config.MaxPoolSize)
+	clientOptions.SetMaxConnIdleTime(config.MaxIdleTime)
 
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		log.Println("error loading .env file")
+		return nil, err
 	}
 
-	mongoUri := os.Getenv("MONGODB_URI")
-	if mongoClient == nil {
-		mongoMinPoolSize, err := strconv.ParseUint(os.Getenv("MONGO_MIN_POOL_SIZE"), 10, 64)
-		if err != nil {
-			log.Default().Println(err)
-		}
-
-		mongoMaxPoolSize, err := strconv.ParseUint(os.Getenv("MONGO_MAX_POOL_SIZE"), 10, 64)
-		if err != nil {
-			log.Default().Println(err)
-		}
-
-		mongoMaxIdleTimeMS, err := strconv.ParseInt(os.Getenv("MONGO_MAX_IDLE_TIME_MS"), 10, 64)
-		if err != nil {
-			log.Default().Println(err)
-		}
-
-		clientOptions := options.Client().ApplyURI(mongoUri)
-
-		clientOptions.SetMinPoolSize(mongoMinPoolSize)
-		clientOptions.SetMaxPoolSize(mongoMaxPoolSize)
-		clientOptions.SetMaxConnIdleTime(time.Duration(mongoMaxIdleTimeMS) * time.Millisecond)
-
-		client, err := mongo.Connect(mongoCtx, clientOptions)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Println("Connected to MongoDB!")
-		return client
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, err
 	}
-	return mongoClient
-}
 
-//Client Database instance
-var Client *mongo.Client = GetMongoClient()
+	fmt.Println("Connected to MongoDB!")
+	return client, nil
+}
 
-//OpenCollection is a  function makes a connection with a collection in the database
-func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
+// MongoNamespace is where the collections of one tenant are: a database, and a prefix of the collection names
+type MongoNamespace struct {
+	Client           *mongo.Client
+	Database         string
+	CollectionPrefix string
+}
 
-	var collection *mongo.Collection = client.Database("splatbackend").Collection(collectionName)
+// OpenCollection opens a collection of the namespace
+func OpenCollection(namespace MongoNamespace, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = namespace.Client.Database(namespace.Database).Collection(namespace.CollectionPrefix + collectionName)
 
 	return collection
 }
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoMigration is one versioned change to the MongoDB collections.
+// A migration runs again when it fails to be recorded, so it must be safe to repeat.
+type MongoMigration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, namespace MongoNamespace) error
+}
+
+// appliedMigration is what the migrations collection records of a migration
+type appliedMigration struct {
+	Version     int `bson:"_id"`
+	Description string
+	Applied_at  time.Time
+}
+
+// createIndexes creates indexes on a collection. Indexes that already exist are left as they are.
+func createIndexes(collection string, indexes ...mongo.IndexModel) func(ctx context.Context, namespace MongoNamespace) error {
+	return func(ctx context.Context, namespace MongoNamespace) error {
+		_, err := OpenCollection(namespace, collection).Indexes().CreateMany(ctx, indexes)
+		return err
+	}
+}
+
+// checkMongoLowercaseEmails refuses to lowercase emails that several users have in different case, as they cannot
+// all keep it
+func checkMongoLowercaseEmails(ctx context.Context, namespace MongoNamespace) error {
+	cursor, err := OpenCollection(namespace, "users").Aggregate(ctx, mongo.Pipeline{
+		{{"$match", bson.M{"email": bson.M{"$type": "string"}}}},
+		{{"$group", bson.M{"_id": bson.M{"$toLower": "$email"}, "user_ids": bson.M{"$push": "$user_id"}, "count": bson.M{"$sum": 1}}}},
+		{{"$match", bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	var groups []struct {
+		Email    string   `bson:"_id"`
+		User_ids []string `bson:"user_ids"`
+	}
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return err
+	}
+
+	conflicts := make(map[string][]string)
+	for _, group := range groups {
+		conflicts[group.Email] = group.User_ids
+	}
+	return emailConflictsError(conflicts)
+}
+
+// MongoMigrations lists every migration, in the order they are applied
+var MongoMigrations = []MongoMigration{
+	{
+		Version:     1,
+		Description: "lowercase the emails of users",
+		Up: func(ctx context.Context, namespace MongoNamespace) error {
+			err := checkMongoLowercaseEmails(ctx, namespace)
+			if err != nil {
+				return err
+			}
+
+			filter := bson.M{"email": bson.M{"$type": "string"}}
+			update := mongo.Pipeline{{{"$set", bson.M{"email": bson.M{"$toLower": "$email"}}}}}
+
+			_, err = OpenCollection(namespace, "users").UpdateMany(ctx, filter, update)
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "index users by email, user_id, linked identity and points",
+		Up: createIndexes("users",
+			mongo.IndexModel{
+				Keys:    bson.D{{"email", 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string"}}),
+			},
+			mongo.IndexModel{Keys: bson.D{{"user_id", 1}}, Options: options.Index().SetUnique(true)},
+			mongo.IndexModel{
+				Keys:    bson.D{{"identities.provider", 1}, {"identities.subject", 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"identities.provider": bson.M{"$exists": true}}),
+			},
+			mongo.IndexModel{Keys: bson.D{{"points", -1}}},
+		),
+	},
+	{
+		Version:     3,
+		Description: "index tasks by user and by module",
+		Up: createIndexes("tasks",
+			mongo.IndexModel{Keys: bson.D{{"user_id", 1}, {"_id", -1}}},
+			mongo.IndexModel{Keys: bson.D{{"module_code", 1}}},
+		),
+	},
+	{
+		Version:     4,
+		Description: "index sessions by id and by user",
+		Up: createIndexes("sessions",
+			mongo.IndexModel{Keys: bson.D{{"session_id", 1}}, Options: options.Index().SetUnique(true)},
+			mongo.IndexModel{Keys: bson.D{{"user_id", 1}, {"last_seen_at", -1}}},
+		),
+	},
+	{
+		Version:     5,
+		Description: "index API keys by id, hash and user",
+		Up: createIndexes("apikeys",
+			mongo.IndexModel{Keys: bson.D{{"key_id", 1}}, Options: options.Index().SetUnique(true)},
+			mongo.IndexModel{Keys: bson.D{{"key_hash", 1}}, Options: options.Index().SetUnique(true)},
+			mongo.IndexModel{Keys: bson.D{{"user_id", 1}, {"created_at", -1}}},
+		),
+	},
+	{
+		Version:     6,
+		Description: "index single use tokens by hash and user",
+		Up: createIndexes("onetimetokens",
+			mongo.IndexModel{Keys: bson.D{{"token_hash", 1}}},
+			mongo.IndexModel{Keys: bson.D{{"user_id", 1}, {"purpose", 1}}},
+		),
+	},
+}
+
+// MigrateMongo applies the migrations that are not recorded as applied yet in the namespace, in order of version
+func MigrateMongo(ctx context.Context, namespace MongoNamespace) error {
+	applied := OpenCollection(namespace, "migrations")
+
+	cursor, err := applied.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+
+	records := []appliedMigration{}
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return err
+	}
+
+	done := make(map[int]bool)
+	for _, record := range records {
+		done[record.Version] = true
+	}
+
+	for _, migration := range MongoMigrations {
+		if done[migration.Version] {
+			continue
+		}
+
+		err = migration.Up(ctx, namespace)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+
+		_, err = applied.InsertOne(ctx, appliedMigration{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Applied_at:  time.Now(),
+		})
+		// Another instance starting at the same time may have applied it as well
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		log.Default().Println("Applied migration", migration.Version, migration.Description)
+	}
+
+	return nil
+}
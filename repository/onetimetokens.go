@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OneTimeTokenRepository stores hashed single use tokens. Plain tokens are never stored.
+type OneTimeTokenRepository struct {
+	collection *mongo.Collection
+	clock      clock.Clock
+}
+
+func NewOneTimeTokenRepository(namespace MongoNamespace, clock clock.Clock) *OneTimeTokenRepository {
+	return &OneTimeTokenRepository{
+		collection: OpenCollection(namespace, "onetimetokens"),
+		clock:      clock,
+	}
+}
+
+// Issue stores a new token for the user and invalidates the tokens previously issued for the same purpose
+func (r *OneTimeTokenRepository) Issue(ctx context.Context, userId string, purpose string, tokenHash string, expiresAt time.Time) error {
+	now := r.clock.Now()
+
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{"user_id": userId, "purpose": purpose, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.collection.InsertOne(ctx, models.OneTimeToken{
+		ID:         primitive.NewObjectID(),
+		Token_hash: tokenHash,
+		User_id:    userId,
+		Purpose:    purpose,
+		Expires_at: expiresAt,
+		Created_at: now,
+	})
+	return err
+}
+
+// Consume marks an unused and unexpired token as used and returns it. Only one caller can consume a token.
+func (r *OneTimeTokenRepository) Consume(ctx context.Context, tokenHash string, purpose string) (*models.OneTimeToken, error) {
+	now := r.clock.Now()
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"purpose":    purpose,
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"used_at": now}}
+	result := models.OneTimeToken{}
+
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&result)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &result, nil
+}
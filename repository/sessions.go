@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionRepository stores the sessions of users, one per login
+type SessionRepository struct {
+	collection *mongo.Collection
+	clock      clock.Clock
+}
+
+func NewSessionRepository(namespace MongoNamespace, clock clock.Clock) *SessionRepository {
+	return &SessionRepository{
+		collection: OpenCollection(namespace, "sessions"),
+		clock:      clock,
+	}
+}
+
+func activeSessionFilter(now time.Time) bson.M {
+	return bson.M{"revoked_at": nil, "expires_at": bson.M{"$gt": now}}
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session models.Session) error {
+	_, err := r.collection.InsertOne(ctx, session)
+	return err
+}
+
+// FindActiveById returns a session that is neither revoked nor expired
+func (r *SessionRepository) FindActiveById(ctx context.Context, sessionId string) (*models.Session, error) {
+	filter := activeSessionFilter(r.clock.Now())
+	filter["session_id"] = sessionId
+	result := models.Session{}
+
+	err := r.collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &result, nil
+}
+
+// FindActiveByUser lists the sessions of a user, most recently seen first
+func (r *SessionRepository) FindActiveByUser(ctx context.Context, userId string) ([]models.Session, error) {
+	filter := activeSessionFilter(r.clock.Now())
+	filter["user_id"] = userId
+	results := []models.Session{}
+
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"last_seen_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Rotate replaces the refresh token of an active session only if it is still the presented one.
+// Returns false when another request has already rotated it.
+func (r *SessionRepository) Rotate(ctx context.Context, sessionId string, presentedHash string, session models.Session) (bool, error) {
+	filter := activeSessionFilter(r.clock.Now())
+	filter["session_id"] = sessionId
+	filter["refresh_token_hash"] = presentedHash
+	update := bson.M{
+		"$set": bson.M{
+			"refresh_token_hash": session.Refresh_token_hash,
+			"ip":                 session.Ip,
+			"user_agent":         session.User_agent,
+			"last_seen_at":       session.Last_seen_at,
+			"expires_at":         session.Expires_at,
+		},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// Touch records that a session was used. Writes are skipped when the recorded use is recent enough.
+func (r *SessionRepository) Touch(ctx context.Context, sessionId string, seenAt time.Time) error {
+	filter := bson.M{"session_id": sessionId, "last_seen_at": bson.M{"$lt": seenAt.Add(-lastUsedResolution)}}
+
+	_, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_seen_at": seenAt}})
+	return err
+}
+
+// Revoke ends a session of the user. It returns ErrNotFound when the user has no such active session.
+func (r *SessionRepository) Revoke(ctx context.Context, userId string, sessionId string) error {
+	now := r.clock.Now()
+	filter := activeSessionFilter(now)
+	filter["user_id"] = userId
+	filter["session_id"] = sessionId
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked_at": now}})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *SessionRepository) RevokeAllByUser(ctx context.Context, userId string) error {
+	filter := bson.M{"user_id": userId, "revoked_at": nil}
+
+	_, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": r.clock.Now()}})
+	return err
+}
@@ -0,0 +1,334 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+// SQLDialect describes how queries are written for one SQL database
+type SQLDialect struct {
+	// Driver is the name the database/sql driver is registered under
+	Driver string
+	// migrations is the directory of the schema migrations of the dialect
+	migrations string
+	// numberedParams marks dialects taking $1, $2... instead of ?
+	numberedParams bool
+	// isDuplicate reports whether an error is a violated unique constraint
+	isDuplicate func(err error) bool
+}
+
+// Postgres is the dialect of PostgreSQL, through the lib/pq driver
+var Postgres = SQLDialect{
+	Driver:         "postgres",
+	migrations:     "migrations/postgres",
+	numberedParams: true,
+	isDuplicate: func(err error) bool {
+		pqErr, ok := err.(*pq.Error)
+		return ok && pqErr.Code == "23505"
+	},
+}
+
+// SQLite is the dialect of SQLite, through the mattn/go-sqlite3 driver
+var SQLite = SQLDialect{
+	Driver:     "sqlite3",
+	migrations: "migrations/sqlite",
+	isDuplicate: func(err error) bool {
+		sqliteErr, ok := err.(sqlite3.Error)
+		return ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+	},
+}
+
+// SQLiteFile is the data source of an SQLite database file. Writers wait for each other instead of failing.
+func SQLiteFile(path string) string {
+	return "file:" + path + "?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on&_txlock=immediate"
+}
+
+// rebind rewrites the ? placeholders of a query into the placeholders of the dialect
+func (d SQLDialect) rebind(query string) string {
+	if !d.numberedParams {
+		return query
+	}
+
+	var rebound strings.Builder
+	param := 0
+	for _, r := range query {
+		if r == '?' {
+			param++
+			rebound.WriteString("$" + strconv.Itoa(param))
+			continue
+		}
+		rebound.WriteRune(r)
+	}
+
+	return rebound.String()
+}
+
+// bind prepares the arguments of a query. Times are stored in UTC, so that they also compare in order
+// in databases keeping them as text.
+func bind(args []interface{}) []interface{} {
+	for i, arg := range args {
+		switch value := arg.(type) {
+		case time.Time:
+			args[i] = value.UTC()
+		case *time.Time:
+			if value != nil {
+				args[i] = value.UTC()
+			}
+		}
+	}
+
+	return args
+}
+
+// SQLDatabase is a connection to a SQL database, holding users and tasks as well as sessions and keys
+type SQLDatabase struct {
+	db      *sql.DB
+	dialect SQLDialect
+}
+
+// OpenSQL connects to a SQL database. Migrate brings its schema up to date.
+func OpenSQL(ctx context.Context, dialect SQLDialect, dataSource string) (*SQLDatabase, error) {
+	db, err := sql.Open(dialect.Driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLDatabase{db: db, dialect: dialect}, nil
+}
+
+func (d *SQLDatabase) Close() error {
+	return d.db.Close()
+}
+
+// Migrate applies the migrations that were not applied yet, in the order of their file names
+func (d *SQLDatabase) Migrate(ctx context.Context) error {
+	_, err := d.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version TEXT PRIMARY KEY, applied_at TIMESTAMP NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[string]bool)
+	rows, err := d.db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	entries, err := fs.ReadDir(migrations, d.dialect.migrations)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		version := strings.TrimSuffix(entry.Name(), ".sql")
+		if applied[version] {
+			continue
+		}
+
+		script, err := migrations.ReadFile(d.dialect.migrations + "/" + entry.Name())
+		if err != nil {
+			return err
+		}
+
+		err = d.inTx(ctx, func(tx sqlTx) error {
+			if check, ok := migrationChecks[version]; ok {
+				if err := check(ctx, tx); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.tx.ExecContext(ctx, string(script)); err != nil {
+				return err
+			}
+
+			_, err := tx.exec(ctx, "INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)", version, time.Now())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Default().Println("Applied migration", version)
+	}
+
+	return nil
+}
+
+// migrationChecks run before the migration of the same version, and stop it when the data does not allow it
+var migrationChecks = map[string]func(ctx context.Context, tx sqlTx) error{
+	"0002_lowercase_emails": checkSQLLowercaseEmails,
+}
+
+// checkSQLLowercaseEmails refuses to lowercase emails that several users have in different case, as they cannot
+// all keep it
+func checkSQLLowercaseEmails(ctx context.Context, tx sqlTx) error {
+	rows, err := tx.query(ctx, `SELECT LOWER(email), user_id FROM users WHERE LOWER(email) IN
+		(SELECT LOWER(email) FROM users WHERE email IS NOT NULL GROUP BY LOWER(email) HAVING COUNT(*) > 1)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	conflicts := make(map[string][]string)
+	for rows.Next() {
+		var email, userId string
+		if err := rows.Scan(&email, &userId); err != nil {
+			return err
+		}
+		conflicts[email] = append(conflicts[email], userId)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return emailConflictsError(conflicts)
+}
+
+func (d *SQLDatabase) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.db.ExecContext(ctx, d.dialect.rebind(query), bind(args)...)
+}
+
+func (d *SQLDatabase) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return d.db.QueryContext(ctx, d.dialect.rebind(query), bind(args)...)
+}
+
+func (d *SQLDatabase) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return d.db.QueryRowContext(ctx, d.dialect.rebind(query), bind(args)...)
+}
+
+// sqlTx is a transaction taking queries written like those of SQLDatabase
+type sqlTx struct {
+	tx      *sql.Tx
+	dialect SQLDialect
+}
+
+func (t sqlTx) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.QueryContext(ctx, t.dialect.rebind(query), bind(args)...)
+}
+
+func (t sqlTx) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, t.dialect.rebind(query), bind(args)...)
+}
+
+// inTx runs change in a transaction, which is committed only when change succeeds
+func (d *SQLDatabase) inTx(ctx context.Context, change func(tx sqlTx) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := change(sqlTx{tx: tx, dialect: d.dialect}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// execAffected runs a statement and reports whether it changed any row
+func (d *SQLDatabase) execAffected(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	result, err := d.exec(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// expectAffected returns ErrNotFound when a statement changed no row
+func expectAffected(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// rowScanner is either a single row or the current row of a result set
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// sqlNotFound turns the error of a query matching no row into ErrNotFound
+func sqlNotFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// duplicate turns the error of a write breaking a unique constraint into ErrDuplicate
+func (d *SQLDatabase) duplicate(err error) error {
+	if err != nil && d.dialect.isDuplicate(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+// encodeStrings stores a list as JSON text. A nil list is stored as NULL.
+func encodeStrings(values []string) interface{} {
+	if values == nil {
+		return nil
+	}
+
+	encoded, _ := json.Marshal(values)
+	return string(encoded)
+}
+
+func decodeStrings(encoded sql.NullString) ([]string, error) {
+	if !encoded.Valid {
+		return nil, nil
+	}
+
+	var values []string
+	err := json.Unmarshal([]byte(encoded.String), &values)
+	return values, err
+}
@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const sessionColumns = "id, session_id, user_id, refresh_token_hash, device, ip, user_agent, created_at, last_seen_at, expires_at, revoked_at"
+
+// SQLSessionRepository stores the sessions of users in a SQL database
+type SQLSessionRepository struct {
+	database *SQLDatabase
+	clock    clock.Clock
+}
+
+func NewSQLSessionRepository(database *SQLDatabase, clock clock.Clock) *SQLSessionRepository {
+	return &SQLSessionRepository{database: database, clock: clock}
+}
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	var session models.Session
+	var id string
+
+	err := row.Scan(&id, &session.Session_id, &session.User_id, &session.Refresh_token_hash, &session.Device, &session.Ip,
+		&session.User_agent, &session.Created_at, &session.Last_seen_at, &session.Expires_at, &session.Revoked_at)
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+
+	session.ID, _ = primitive.ObjectIDFromHex(id)
+	return &session, nil
+}
+
+func (r *SQLSessionRepository) Create(ctx context.Context, session models.Session) error {
+	_, err := r.database.exec(ctx, "INSERT INTO sessions ("+sessionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		session.ID.Hex(), session.Session_id, session.User_id, session.Refresh_token_hash, session.Device, session.Ip,
+		session.User_agent, session.Created_at, session.Last_seen_at, session.Expires_at, session.Revoked_at)
+	return err
+}
+
+func (r *SQLSessionRepository) FindActiveById(ctx context.Context, sessionId string) (*models.Session, error) {
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE session_id = ? AND revoked_at IS NULL AND expires_at > ?"
+	return scanSession(r.database.queryRow(ctx, query, sessionId, r.clock.Now()))
+}
+
+func (r *SQLSessionRepository) FindActiveByUser(ctx context.Context, userId string) ([]models.Session, error) {
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY last_seen_at DESC"
+	rows, err := r.database.query(ctx, query, userId, r.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *session)
+	}
+
+	return results, rows.Err()
+}
+
+func (r *SQLSessionRepository) Rotate(ctx context.Context, sessionId string, presentedHash string, session models.Session) (bool, error) {
+	query := "UPDATE sessions SET refresh_token_hash = ?, ip = ?, user_agent = ?, last_seen_at = ?, expires_at = ? " +
+		"WHERE session_id = ? AND refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?"
+
+	return r.database.execAffected(ctx, query, session.Refresh_token_hash, session.Ip, session.User_agent, session.Last_seen_at,
+		session.Expires_at, sessionId, presentedHash, r.clock.Now())
+}
+
+// Touch records that a session was used. Writes are skipped when the recorded use is recent enough.
+func (r *SQLSessionRepository) Touch(ctx context.Context, sessionId string, seenAt time.Time) error {
+	_, err := r.database.exec(ctx, "UPDATE sessions SET last_seen_at = ? WHERE session_id = ? AND last_seen_at < ?",
+		seenAt, sessionId, seenAt.Add(-lastUsedResolution))
+	return err
+}
+
+func (r *SQLSessionRepository) Revoke(ctx context.Context, userId string, sessionId string) error {
+	now := r.clock.Now()
+	query := "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND session_id = ? AND revoked_at IS NULL AND expires_at > ?"
+
+	return expectAffected(r.database.exec(ctx, query, now, userId, sessionId, now))
+}
+
+func (r *SQLSessionRepository) RevokeAllByUser(ctx context.Context, userId string) error {
+	_, err := r.database.exec(ctx, "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", r.clock.Now(), userId)
+	return err
+}
+
+const apiKeyColumns = "id, key_id, user_id, name, scopes, prefix, key_hash, created_at, last_used_at"
+
+// SQLApiKeyRepository stores hashed API keys in a SQL database
+type SQLApiKeyRepository struct {
+	database *SQLDatabase
+}
+
+func NewSQLApiKeyRepository(database *SQLDatabase) *SQLApiKeyRepository {
+	return &SQLApiKeyRepository{database: database}
+}
+
+func scanApiKey(row rowScanner) (*models.ApiKey, error) {
+	var apiKey models.ApiKey
+	var id string
+	var scopes sql.NullString
+
+	err := row.Scan(&id, &apiKey.Key_id, &apiKey.User_id, &apiKey.Name, &scopes, &apiKey.Prefix, &apiKey.Key_hash,
+		&apiKey.Created_at, &apiKey.Last_used_at)
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+
+	apiKey.ID, _ = primitive.ObjectIDFromHex(id)
+	apiKey.Scopes, err = decodeStrings(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+func (r *SQLApiKeyRepository) Create(ctx context.Context, apiKey models.ApiKey) error {
+	_, err := r.database.exec(ctx, "INSERT INTO api_keys ("+apiKeyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		apiKey.ID.Hex(), apiKey.Key_id, apiKey.User_id, apiKey.Name, encodeStrings(apiKey.Scopes), apiKey.Prefix, apiKey.Key_hash,
+		apiKey.Created_at, apiKey.Last_used_at)
+	return err
+}
+
+func (r *SQLApiKeyRepository) FindByUser(ctx context.Context, userId string) ([]models.ApiKey, error) {
+	rows, err := r.database.query(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY created_at DESC", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.ApiKey{}
+	for rows.Next() {
+		apiKey, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *apiKey)
+	}
+
+	return results, rows.Err()
+}
+
+func (r *SQLApiKeyRepository) FindByHash(ctx context.Context, keyHash string) (*models.ApiKey, error) {
+	return scanApiKey(r.database.queryRow(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", keyHash))
+}
+
+func (r *SQLApiKeyRepository) Delete(ctx context.Context, userId string, keyId string) error {
+	return expectAffected(r.database.exec(ctx, "DELETE FROM api_keys WHERE user_id = ? AND key_id = ?", userId, keyId))
+}
+
+func (r *SQLApiKeyRepository) DeleteByUser(ctx context.Context, userId string) error {
+	_, err := r.database.exec(ctx, "DELETE FROM api_keys WHERE user_id = ?", userId)
+	return err
+}
+
+// Touch records that a key was used. Writes are skipped when the recorded use is recent enough.
+func (r *SQLApiKeyRepository) Touch(ctx context.Context, keyId string, usedAt time.Time) error {
+	_, err := r.database.exec(ctx, "UPDATE api_keys SET last_used_at = ? WHERE key_id = ? AND (last_used_at IS NULL OR last_used_at < ?)",
+		usedAt, keyId, usedAt.Add(-lastUsedResolution))
+	return err
+}
+
+const oneTimeTokenColumns = "id, token_hash, user_id, purpose, expires_at, used_at, created_at"
+
+// SQLOneTimeTokenRepository stores hashed single use tokens in a SQL database
+type SQLOneTimeTokenRepository struct {
+	database *SQLDatabase
+	clock    clock.Clock
+}
+
+func NewSQLOneTimeTokenRepository(database *SQLDatabase, clock clock.Clock) *SQLOneTimeTokenRepository {
+	return &SQLOneTimeTokenRepository{database: database, clock: clock}
+}
+
+func (r *SQLOneTimeTokenRepository) Issue(ctx context.Context, userId string, purpose string, tokenHash string, expiresAt time.Time) error {
+	now := r.clock.Now()
+
+	return r.database.inTx(ctx, func(tx sqlTx) error {
+		_, err := tx.exec(ctx, "UPDATE one_time_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL",
+			now, userId, purpose)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec(ctx, "INSERT INTO one_time_tokens ("+oneTimeTokenColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+			primitive.NewObjectID().Hex(), tokenHash, userId, purpose, expiresAt, nil, now)
+		return err
+	})
+}
+
+func (r *SQLOneTimeTokenRepository) Consume(ctx context.Context, tokenHash string, purpose string) (*models.OneTimeToken, error) {
+	now := r.clock.Now()
+	query := "UPDATE one_time_tokens SET used_at = ? WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ? " +
+		"RETURNING " + oneTimeTokenColumns
+
+	var result models.OneTimeToken
+	var id string
+	err := r.database.queryRow(ctx, query, now, tokenHash, purpose, now).Scan(&id, &result.Token_hash, &result.User_id,
+		&result.Purpose, &result.Expires_at, &result.Used_at, &result.Created_at)
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+
+	result.ID, _ = primitive.ObjectIDFromHex(id)
+	return &result, nil
+}
+
+var (
+	_ UserStore         = (*SQLUserRepository)(nil)
+	_ TaskStore         = (*SQLTaskRepository)(nil)
+	_ SessionStore      = (*SQLSessionRepository)(nil)
+	_ ApiKeyStore       = (*SQLApiKeyRepository)(nil)
+	_ OneTimeTokenStore = (*SQLOneTimeTokenRepository)(nil)
+)
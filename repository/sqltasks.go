@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const taskColumns = "id, user_id, first_name, last_name, task_name, module_code, duration, hidden, created_at, updated_at"
+
+// SQLTaskRepository stores tasks in a SQL database. Tasks keep their object ids, which sort in the order tasks were added.
+type SQLTaskRepository struct {
+	database *SQLDatabase
+	clock    clock.Clock
+}
+
+func NewSQLTaskRepository(database *SQLDatabase, clock clock.Clock) *SQLTaskRepository {
+	return &SQLTaskRepository{database: database, clock: clock}
+}
+
+func scanTask(row rowScanner) (*models.Task, error) {
+	var task models.Task
+	var id string
+
+	err := row.Scan(&id, &task.User_id, &task.First_name, &task.Last_name, &task.Task_name, &task.Module_code, &task.Duration,
+		&task.Hidden, &task.Created_at, &task.Updated_at)
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+
+	task.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+func (r *SQLTaskRepository) InsertTask(ctx context.Context, task models.Task) error {
+	_, err := r.database.exec(ctx, "INSERT INTO tasks ("+taskColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		task.ID.Hex(), task.User_id, task.First_name, task.Last_name, task.Task_name, task.Module_code, task.Duration,
+		task.Hidden, task.Created_at, task.Updated_at)
+	return err
+}
+
+func (r *SQLTaskRepository) FindTaskById(ctx context.Context, targetId string) (*models.Task, error) {
+	return scanTask(r.database.queryRow(ctx, "SELECT "+taskColumns+" FROM tasks WHERE id = ?", targetId))
+}
+
+func (r *SQLTaskRepository) findTasks(ctx context.Context, where string, args ...interface{}) ([]models.Task, error) {
+	rows, err := r.database.query(ctx, "SELECT "+taskColumns+" FROM tasks WHERE "+where+" ORDER BY id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Task, 0)
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *task)
+	}
+
+	return result, rows.Err()
+}
+
+func (r *SQLTaskRepository) FindTasks(ctx context.Context) ([]models.Task, error) {
+	return r.findTasks(ctx, "TRUE")
+}
+
+func (r *SQLTaskRepository) FindTasksByUser(ctx context.Context, userId string) ([]models.Task, error) {
+	return r.findTasks(ctx, "user_id = ?", userId)
+}
+
+func (r *SQLTaskRepository) SetTaskHidden(ctx context.Context, targetId string, hidden bool) (*models.Task, error) {
+	query := "UPDATE tasks SET hidden = ?, updated_at = ? WHERE id = ? RETURNING " + taskColumns
+	return scanTask(r.database.queryRow(ctx, query, hidden, r.clock.Now(), targetId))
+}
+
+func (r *SQLTaskRepository) ModulePopularity(ctx context.Context) ([]models.ModulePopularity, error) {
+	rows, err := r.database.query(ctx, "SELECT module_code, COUNT(*) FROM tasks GROUP BY module_code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]models.ModulePopularity, 0)
+	for rows.Next() {
+		var result models.ModulePopularity
+		err = rows.Scan(&result.ID.Module_code, &result.Count)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
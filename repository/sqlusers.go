@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const userColumns = "id, user_id, first_name, last_name, password, email, email_verified, created_at, updated_at, points, timetable, " +
+	"user_type, two_factor_enabled, totp_secret, totp_last_step, recovery_codes, totp_pending_secret, pending_recovery_codes"
+
+// SQLUserRepository stores users in a SQL database. Linked identities are kept in their own table.
+type SQLUserRepository struct {
+	database *SQLDatabase
+	clock    clock.Clock
+}
+
+func NewSQLUserRepository(database *SQLDatabase, clock clock.Clock) *SQLUserRepository {
+	return &SQLUserRepository{database: database, clock: clock}
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	var id string
+	var recoveryCodes, pendingRecoveryCodes sql.NullString
+
+	err := row.Scan(&id, &user.User_id, &user.First_name, &user.Last_name, &user.Password, &user.Email, &user.Email_verified,
+		&user.Created_at, &user.Updated_at, &user.Points, &user.Timetable, &user.User_type, &user.Two_factor_enabled,
+		&user.Totp_secret, &user.Totp_last_step, &recoveryCodes, &user.Totp_pending_secret, &pendingRecoveryCodes)
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+
+	user.ID, _ = primitive.ObjectIDFromHex(id)
+	if user.Recovery_codes, err = decodeStrings(recoveryCodes); err != nil {
+		return nil, err
+	}
+	if user.Pending_recovery_codes, err = decodeStrings(pendingRecoveryCodes); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// identities lists the linked identities of the users matched by where, by user
+func (r *SQLUserRepository) identities(ctx context.Context, where string, args ...interface{}) (map[string][]models.Identity, error) {
+	query := "SELECT user_id, provider, subject, email, linked_at FROM user_identities WHERE " + where
+
+	rows, err := r.database.query(ctx, query+" ORDER BY linked_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make(map[string][]models.Identity)
+	for rows.Next() {
+		var userId string
+		var identity models.Identity
+		err = rows.Scan(&userId, &identity.Provider, &identity.Subject, &identity.Email, &identity.Linked_at)
+		if err != nil {
+			return nil, err
+		}
+		results[userId] = append(results[userId], identity)
+	}
+
+	return results, rows.Err()
+}
+
+// withIdentities fills in the linked identities of a user that was just read
+func (r *SQLUserRepository) withIdentities(ctx context.Context, user *models.User, err error) (*models.User, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := r.identities(ctx, "user_id = ?", user.User_id)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Identities = identities[user.User_id]
+	return user, nil
+}
+
+func (r *SQLUserRepository) findOne(ctx context.Context, where string, args ...interface{}) (*models.User, error) {
+	user, err := scanUser(r.database.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE "+where, args...))
+	return r.withIdentities(ctx, user, err)
+}
+
+// update changes a user, setting Updated_at to now, and returns the user as it is afterwards
+func (r *SQLUserRepository) update(ctx context.Context, userId string, set string, args ...interface{}) (*models.User, error) {
+	set += ", updated_at = ?"
+	args = append(args, updatedNow(r.clock), userId)
+	query := "UPDATE users SET " + set + " WHERE user_id = ? RETURNING " + userColumns
+
+	user, err := scanUser(r.database.queryRow(ctx, query, args...))
+	return r.withIdentities(ctx, user, r.database.duplicate(err))
+}
+
+// change is update for callers that only need to know whether the user exists
+func (r *SQLUserRepository) change(ctx context.Context, userId string, set string, args ...interface{}) error {
+	set += ", updated_at = ?"
+	return expectAffected(r.database.exec(ctx, "UPDATE users SET "+set+" WHERE user_id = ?", append(args, updatedNow(r.clock), userId)...))
+}
+
+func (r *SQLUserRepository) InsertUser(ctx context.Context, user models.User) error {
+	return r.database.duplicate(r.database.inTx(ctx, func(tx sqlTx) error {
+		_, err := tx.exec(ctx, "INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			user.ID.Hex(), user.User_id, user.First_name, user.Last_name, user.Password, user.Email, user.Email_verified,
+			user.Created_at, user.Updated_at, user.Points, user.Timetable, user.User_type, user.Two_factor_enabled,
+			user.Totp_secret, user.Totp_last_step, encodeStrings(user.Recovery_codes), user.Totp_pending_secret,
+			encodeStrings(user.Pending_recovery_codes))
+		if err != nil {
+			return err
+		}
+
+		for _, identity := range user.Identities {
+			err = insertIdentity(ctx, tx, user.User_id, identity)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+}
+
+func insertIdentity(ctx context.Context, tx sqlTx, userId string, identity models.Identity) error {
+	_, err := tx.exec(ctx, "INSERT INTO user_identities (provider, subject, user_id, email, linked_at) VALUES (?, ?, ?, ?, ?)",
+		identity.Provider, identity.Subject, userId, identity.Email, identity.Linked_at)
+	return err
+}
+
+func (r *SQLUserRepository) FindUserById(ctx context.Context, targetId string) (*models.User, error) {
+	return r.findOne(ctx, "user_id = ?", targetId)
+}
+
+func (r *SQLUserRepository) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, "email = ?", email)
+}
+
+func (r *SQLUserRepository) FindUserByIdentity(ctx context.Context, provider string, subject string) (*models.User, error) {
+	return r.findOne(ctx, "user_id = (SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?)", provider, subject)
+}
+
+func (r *SQLUserRepository) FindUsers(ctx context.Context, verifiedOnly bool) (*[]models.User, error) {
+	query := "SELECT " + userColumns + " FROM users"
+	if verifiedOnly {
+		// Users created before verification existed count as verified
+		query += " WHERE email_verified IS NULL OR email_verified"
+	}
+
+	rows, err := r.database.query(ctx, query+" ORDER BY points DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	identities, err := r.identities(ctx, "TRUE")
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		result[i].Identities = identities[result[i].User_id]
+	}
+
+	return &result, nil
+}
+
+func (r *SQLUserRepository) DeleteUser(ctx context.Context, userId string) error {
+	return r.database.inTx(ctx, func(tx sqlTx) error {
+		_, err := tx.exec(ctx, "DELETE FROM user_identities WHERE user_id = ?", userId)
+		if err != nil {
+			return err
+		}
+
+		return expectAffected(tx.exec(ctx, "DELETE FROM users WHERE user_id = ?", userId))
+	})
+}
+
+func (r *SQLUserRepository) UpdateParticulars(ctx context.Context, userId string, particulars models.UserParticulars) (*models.User, error) {
+	set := make([]string, 0)
+	args := make([]interface{}, 0)
+	if particulars.First_name != nil {
+		set = append(set, "first_name = ?")
+		args = append(args, *particulars.First_name)
+	}
+	if particulars.Last_name != nil {
+		set = append(set, "last_name = ?")
+		args = append(args, *particulars.Last_name)
+	}
+	if particulars.Email != nil {
+		set = append(set, "email = ?")
+		args = append(args, *particulars.Email)
+	}
+	if particulars.Email_verified != nil {
+		set = append(set, "email_verified = ?")
+		args = append(args, *particulars.Email_verified)
+	}
+	if particulars.Password != nil {
+		set = append(set, "password = ?")
+		args = append(args, *particulars.Password)
+	}
+
+	if len(set) == 0 {
+		return r.FindUserById(ctx, userId)
+	}
+
+	return r.update(ctx, userId, strings.Join(set, ", "), args...)
+}
+
+func (r *SQLUserRepository) AddPoints(ctx context.Context, userId string, points int) (*models.User, error) {
+	return r.update(ctx, userId, "points = points + ?", points)
+}
+
+func (r *SQLUserRepository) SetTimetable(ctx context.Context, userId string, timetable string) (*models.User, error) {
+	return r.update(ctx, userId, "timetable = ?", timetable)
+}
+
+func (r *SQLUserRepository) SetRole(ctx context.Context, userId string, role string) (*models.User, error) {
+	return r.update(ctx, userId, "user_type = ?", role)
+}
+
+func (r *SQLUserRepository) HasRole(ctx context.Context, role string) (bool, error) {
+	var found int
+	err := r.database.queryRow(ctx, "SELECT COUNT(*) FROM users WHERE user_type = ?", role).Scan(&found)
+	return found > 0, err
+}
+
+func (r *SQLUserRepository) SetPassword(ctx context.Context, userId string, passwordHash string) error {
+	return r.change(ctx, userId, "password = ?", passwordHash)
+}
+
+func (r *SQLUserRepository) SetEmailVerified(ctx context.Context, userId string, verified bool) error {
+	return r.change(ctx, userId, "email_verified = ?", verified)
+}
+
+func (r *SQLUserRepository) RecordLogin(ctx context.Context, userId string, at time.Time) error {
+	return expectAffected(r.database.exec(ctx, "UPDATE users SET updated_at = ? WHERE user_id = ?", at, userId))
+}
+
+func (r *SQLUserRepository) LinkIdentity(ctx context.Context, userId string, identity models.Identity, dropPassword bool) error {
+	set := "email_verified = ?, updated_at = ?"
+	if dropPassword {
+		set += ", password = NULL, two_factor_enabled = FALSE, totp_secret = NULL, totp_last_step = 0, recovery_codes = NULL, " +
+			"totp_pending_secret = NULL, pending_recovery_codes = NULL"
+	}
+
+	return r.database.inTx(ctx, func(tx sqlTx) error {
+		err := expectAffected(tx.exec(ctx, "UPDATE users SET "+set+" WHERE user_id = ?", true, updatedNow(r.clock), userId))
+		if err != nil {
+			return err
+		}
+
+		return insertIdentity(ctx, tx, userId, identity)
+	})
+}
+
+func (r *SQLUserRepository) SetPendingTwoFactor(ctx context.Context, userId string, secret string, recoveryCodeHashes []string) error {
+	return r.change(ctx, userId, "totp_pending_secret = ?, pending_recovery_codes = ?", secret, encodeStrings(recoveryCodeHashes))
+}
+
+func (r *SQLUserRepository) EnableTwoFactor(ctx context.Context, userId string, pendingSecret string, recoveryCodeHashes []string, step int64) error {
+	set := "two_factor_enabled = ?, totp_secret = ?, totp_last_step = ?, recovery_codes = ?, totp_pending_secret = NULL, " +
+		"pending_recovery_codes = NULL, updated_at = ?"
+
+	return expectAffected(r.database.exec(ctx, "UPDATE users SET "+set+" WHERE user_id = ? AND totp_pending_secret = ?",
+		true, pendingSecret, step, encodeStrings(recoveryCodeHashes), updatedNow(r.clock), userId, pendingSecret))
+}
+
+func (r *SQLUserRepository) DisableTwoFactor(ctx context.Context, userId string) error {
+	return r.change(ctx, userId, "two_factor_enabled = ?, totp_secret = NULL, totp_last_step = 0, recovery_codes = NULL", false)
+}
+
+func (r *SQLUserRepository) UseTOTPStep(ctx context.Context, userId string, step int64) (bool, error) {
+	return r.database.execAffected(ctx, "UPDATE users SET totp_last_step = ? WHERE user_id = ? AND totp_last_step < ?", step, userId, step)
+}
+
+func (r *SQLUserRepository) UseRecoveryCode(ctx context.Context, userId string, codeHash string) (bool, error) {
+	for {
+		var stored sql.NullString
+		err := r.database.queryRow(ctx, "SELECT recovery_codes FROM users WHERE user_id = ?", userId).Scan(&stored)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		codes, err := decodeStrings(stored)
+		if err != nil {
+			return false, err
+		}
+
+		remaining := make([]string, 0, len(codes))
+		for _, code := range codes {
+			if code != codeHash {
+				remaining = append(remaining, code)
+			}
+		}
+		if len(remaining) == len(codes) {
+			return false, nil
+		}
+
+		// Only write over the codes that were read, so that a code cannot be used by two requests at once
+		used, err := r.database.execAffected(ctx, "UPDATE users SET recovery_codes = ? WHERE user_id = ? AND recovery_codes = ?",
+			encodeStrings(remaining), userId, stored.String)
+		if err != nil || used {
+			return used, err
+		}
+	}
+}
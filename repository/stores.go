@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// ErrNotFound is returned by stores when no document matches
+var ErrNotFound = errors.New("not found")
+
+// updatedNow is the time updates record in Updated_at, to the second like the controllers record it
+func updatedNow(clock clock.Clock) time.Time {
+	now, _ := time.Parse(time.RFC3339, clock.Now().Format(time.RFC3339))
+	return now
+}
+
+// ErrDuplicate is returned by stores when a write would give two users the same email or linked identity
+var ErrDuplicate = errors.New("already exists")
+
+// emailConflictsError lists the users sharing each email in different case, which stops emails from being
+// lowercased. It is nil when there are none.
+func emailConflictsError(conflicts map[string][]string) error {
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	emails := make([]string, 0, len(conflicts))
+	for email := range conflicts {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	descriptions := make([]string, 0, len(emails))
+	for _, email := range emails {
+		userIds := conflicts[email]
+		sort.Strings(userIds)
+		descriptions = append(descriptions, fmt.Sprintf("%s is used by users %s", email, strings.Join(userIds, ", ")))
+	}
+
+	return fmt.Errorf("emails differ only in case between users, change all but one of them before migrating: %s",
+		strings.Join(descriptions, "; "))
+}
+
+// UserStore covers every operation the API performs on users.
+// Every update sets Updated_at, which tells clients whether their copy of a user is current.
+type UserStore interface {
+	// InsertUser adds a user. It returns ErrDuplicate when another user has the same email.
+	InsertUser(ctx context.Context, user models.User) error
+	FindUserById(ctx context.Context, targetId string) (*models.User, error)
+	FindUserByEmail(ctx context.Context, email string) (*models.User, error)
+	FindUserByIdentity(ctx context.Context, provider string, subject string) (*models.User, error)
+	// FindUsers lists users by points, highest first
+	FindUsers(ctx context.Context, verifiedOnly bool) (*[]models.User, error)
+	DeleteUser(ctx context.Context, userId string) error
+
+	// UpdateParticulars returns ErrDuplicate when the new email belongs to another user
+	UpdateParticulars(ctx context.Context, userId string, particulars models.UserParticulars) (*models.User, error)
+	AddPoints(ctx context.Context, userId string, points int) (*models.User, error)
+	SetTimetable(ctx context.Context, userId string, timetable string) (*models.User, error)
+	SetRole(ctx context.Context, userId string, role string) (*models.User, error)
+	// HasRole tells whether any user holds role
+	HasRole(ctx context.Context, role string) (bool, error)
+	// SetPassword stores a password hash and returns ErrNotFound when the user does not exist
+	SetPassword(ctx context.Context, userId string, passwordHash string) error
+	SetEmailVerified(ctx context.Context, userId string, verified bool) error
+	// RecordLogin marks the user as updated at a login and drops the tokens that earlier versions stored on users
+	RecordLogin(ctx context.Context, userId string, at time.Time) error
+	// LinkIdentity links a provider account and marks the email as verified. dropPassword removes the password and
+	// the second factor, enrolled or pending, of whoever held the account before.
+	LinkIdentity(ctx context.Context, userId string, identity models.Identity, dropPassword bool) error
+
+	SetPendingTwoFactor(ctx context.Context, userId string, secret string, recoveryCodeHashes []string) error
+	// EnableTwoFactor activates the pending enrollment, as long as it is still the one with the given secret.
+	// It returns ErrNotFound when the user enrolled again in the meantime.
+	EnableTwoFactor(ctx context.Context, userId string, pendingSecret string, recoveryCodeHashes []string, step int64) error
+	DisableTwoFactor(ctx context.Context, userId string) error
+	// UseTOTPStep records a used TOTP step. It returns false when that step or a later one was already used.
+	UseTOTPStep(ctx context.Context, userId string, step int64) (bool, error)
+	// UseRecoveryCode removes a recovery code. It returns false when the user does not hold it.
+	UseRecoveryCode(ctx context.Context, userId string, codeHash string) (bool, error)
+}
+
+// TaskStore covers every operation the API performs on tasks
+type TaskStore interface {
+	InsertTask(ctx context.Context, task models.Task) error
+	FindTaskById(ctx context.Context, targetId string) (*models.Task, error)
+	// FindTasks lists every task, newest first
+	FindTasks(ctx context.Context) ([]models.Task, error)
+	// FindTasksByUser lists the tasks of a user, newest first
+	FindTasksByUser(ctx context.Context, userId string) ([]models.Task, error)
+	SetTaskHidden(ctx context.Context, targetId string, hidden bool) (*models.Task, error)
+	ModulePopularity(ctx context.Context) ([]models.ModulePopularity, error)
+}
+
+// SessionStore keeps the sessions of users, one per login
+type SessionStore interface {
+	Create(ctx context.Context, session models.Session) error
+	// FindActiveById returns a session that is neither revoked nor expired
+	FindActiveById(ctx context.Context, sessionId string) (*models.Session, error)
+	// FindActiveByUser lists the active sessions of a user, most recently seen first
+	FindActiveByUser(ctx context.Context, userId string) ([]models.Session, error)
+	// Rotate replaces the refresh token of an active session only if it is still the presented one.
+	// It returns false when another request has already rotated it.
+	Rotate(ctx context.Context, sessionId string, presentedHash string, session models.Session) (bool, error)
+	// Touch records that a session was used. Writes may be skipped when the recorded use is recent enough.
+	Touch(ctx context.Context, sessionId string, seenAt time.Time) error
+	// Revoke ends a session of the user. It returns ErrNotFound when the user has no such active session.
+	Revoke(ctx context.Context, userId string, sessionId string) error
+	RevokeAllByUser(ctx context.Context, userId string) error
+}
+
+// ApiKeyStore keeps hashed API keys
+type ApiKeyStore interface {
+	Create(ctx context.Context, apiKey models.ApiKey) error
+	// FindByUser lists the keys of a user, newest first
+	FindByUser(ctx context.Context, userId string) ([]models.ApiKey, error)
+	FindByHash(ctx context.Context, keyHash string) (*models.ApiKey, error)
+	// Delete revokes a key of the user. It returns ErrNotFound when the user has no such key.
+	Delete(ctx context.Context, userId string, keyId string) error
+	DeleteByUser(ctx context.Context, userId string) error
+	// Touch records that a key was used. Writes may be skipped when the recorded use is recent enough.
+	Touch(ctx context.Context, keyId string, usedAt time.Time) error
+}
+
+// OneTimeTokenStore keeps hashed single use tokens, such as password reset and email verification tokens
+type OneTimeTokenStore interface {
+	// Issue stores a new token for the user and invalidates the tokens previously issued for the same purpose
+	Issue(ctx context.Context, userId string, purpose string, tokenHash string, expiresAt time.Time) error
+	// Consume marks an unused and unexpired token as used and returns it. Only one caller can consume a token.
+	Consume(ctx context.Context, tokenHash string, purpose string) (*models.OneTimeToken, error)
+}
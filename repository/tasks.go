@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"log"
+
+	"github.com/hauchongtang/splatbackend/clock"
+	"github.com/hauchongtang/splatbackend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TaskRepository struct {
+	collection *mongo.Collection
+	ctx        context.Context
+	clock      clock.Clock
+}
+
+func NewTaskRepository(namespace MongoNamespace, ctx context.Context, clock clock.Clock) *TaskRepository {
+	collection := OpenCollection(namespace, "tasks")
+
+	return &TaskRepository{
+		collection: collection,
+		ctx:        ctx,
+		clock:      clock,
+	}
+}
+
+func (r *TaskRepository) InsertTask(ctx context.Context, task models.Task) error {
+	_, err := r.collection.InsertOne(ctx, task)
+	return err
+}
+
+func (r *TaskRepository) FindTaskById(ctx context.Context, targetId string) (*models.Task, error) {
+	objectId, err := primitive.ObjectIDFromHex(targetId)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	filter := bson.M{"_id": objectId}
+	result := models.Task{}
+	err = r.collection.FindOne(ctx, filter).Decode(&result)
+
+	if err != nil {
+		log.Default().Print("Unable to decode object from mongodb")
+		log.Default().Print(err)
+		return nil, notFound(err)
+	}
+
+	return &result, nil
+}
+
+func (r *TaskRepository) findTasks(ctx context.Context, filter bson.M) ([]models.Task, error) {
+	result := make([]models.Task, 0)
+	opts := options.Find().SetSort(bson.D{{"_id", -1}})
+
+	docCursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = docCursor.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *TaskRepository) FindTasks(ctx context.Context) ([]models.Task, error) {
+	return r.findTasks(ctx, bson.M{})
+}
+
+func (r *TaskRepository) FindTasksByUser(ctx context.Context, userId string) ([]models.Task, error) {
+	return r.findTasks(ctx, bson.M{"user_id": userId})
+}
+
+func (r *TaskRepository) SetTaskHidden(ctx context.Context, targetId string, hidden bool) (*models.Task, error) {
+	objectId, err := primitive.ObjectIDFromHex(targetId)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	result := models.Task{}
+	update := bson.M{"$set": bson.M{"hidden": hidden, "updated_at": r.clock.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectId}, update, opts).Decode(&result)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &result, nil
+}
+
+func (r *TaskRepository) ModulePopularity(ctx context.Context) ([]models.ModulePopularity, error) {
+	results := make([]models.ModulePopularity, 0)
+
+	docCursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{"$group", bson.D{{"count", bson.D{{"$sum", 1}}}, {"_id", bson.D{{"module_code", "$module_code"}}}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer docCursor.Close(ctx)
+
+	err = docCursor.All(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
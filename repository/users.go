@@ -3,7 +3,9 @@ package repository
 import (
 	"context"
 	"log"
+	"time"
 
+	"github.com/hauchongtang/splatbackend/clock"
 	"github.com/hauchongtang/splatbackend/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,22 +15,74 @@ import (
 type UserRepository struct {
 	collection *mongo.Collection
 	ctx        context.Context
+	clock      clock.Clock
 }
 
-func NewUserRepository(client *mongo.Client, ctx context.Context) *UserRepository {
-	err := client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func NewUserRepository(namespace MongoNamespace, ctx context.Context, clock clock.Clock) *UserRepository {
 	databaseName := "users"
 
-	collection := OpenCollection(client, databaseName)
+	collection := OpenCollection(namespace, databaseName)
 
 	return &UserRepository{
 		collection: collection,
 		ctx:        ctx,
+		clock:      clock,
+	}
+}
+
+// notFound turns the error of a query matching nothing into ErrNotFound
+func notFound(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// duplicate turns the error of a write breaking a unique index into ErrDuplicate
+func duplicate(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+func (r *UserRepository) findOne(ctx context.Context, filter bson.M) (*models.User, error) {
+	result := models.User{}
+	err := r.collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		return nil, notFound(err)
 	}
+
+	return &result, nil
+}
+
+// updateOne applies an update to a user and returns the user as it is afterwards.
+// Updated_at is set to now, unless the update sets it.
+func (r *UserRepository) updateOne(ctx context.Context, userId string, update bson.M) (*models.User, error) {
+	set, _ := update["$set"].(bson.M)
+	if set == nil {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	if _, found := set["updated_at"]; !found {
+		set["updated_at"] = updatedNow(r.clock)
+	}
+
+	result := models.User{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"user_id": userId}, update, opts).Decode(&result)
+	if err != nil {
+		return nil, duplicate(notFound(err))
+	}
+
+	return &result, nil
+}
+
+func (r *UserRepository) InsertUser(ctx context.Context, user models.User) error {
+	_, err := r.collection.InsertOne(ctx, user)
+	return duplicate(err)
 }
 
 func (r *UserRepository) FindUserById(ctx context.Context, targetId string) (*models.User, error) {
@@ -40,20 +94,37 @@ func (r *UserRepository) FindUserById(ctx context.Context, targetId string) (*mo
 	if err != nil {
 		log.Default().Print("Unable to decode object from mongodb")
 		log.Default().Print(err)
-		return nil, err
+		return nil, notFound(err)
 	}
 
 	return &result, nil
 }
 
-func (r *UserRepository) FindUsers(ctx context.Context) (*[]models.User, error) {
+func (r *UserRepository) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"email": email})
+}
+
+func (r *UserRepository) FindUserByIdentity(ctx context.Context, provider string, subject string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}})
+}
+
+// VerifiedUsersFilter matches users whose email is verified. Users created before verification existed count as verified.
+func VerifiedUsersFilter() bson.M {
+	return bson.M{"email_verified": bson.M{"$ne": false}}
+}
+
+func (r *UserRepository) FindUsers(ctx context.Context, verifiedOnly bool) (*[]models.User, error) {
 	filter := bson.M{}
+	if verifiedOnly {
+		filter = VerifiedUsersFilter()
+	}
 	result := make([]models.User, 0)
 	opts := options.Find().SetSort(bson.D{{"points", -1}})
 	docCursor, err := r.collection.Find(ctx, filter, opts)
 
 	if err != nil {
 		log.Default().Println("Find all tasks failed.")
+		return nil, err
 	}
 
 	err = docCursor.All(context.TODO(), &result)
@@ -66,3 +137,157 @@ func (r *UserRepository) FindUsers(ctx context.Context) (*[]models.User, error)
 
 	return &result, nil
 }
+
+func (r *UserRepository) DeleteUser(ctx context.Context, userId string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepository) UpdateParticulars(ctx context.Context, userId string, particulars models.UserParticulars) (*models.User, error) {
+	toUpdate := bson.M{}
+	if particulars.First_name != nil {
+		toUpdate["first_name"] = *particulars.First_name
+	}
+	if particulars.Last_name != nil {
+		toUpdate["last_name"] = *particulars.Last_name
+	}
+	if particulars.Email != nil {
+		toUpdate["email"] = *particulars.Email
+	}
+	if particulars.Email_verified != nil {
+		toUpdate["email_verified"] = *particulars.Email_verified
+	}
+	if particulars.Password != nil {
+		toUpdate["password"] = *particulars.Password
+	}
+
+	return r.updateOne(ctx, userId, bson.M{"$set": toUpdate})
+}
+
+func (r *UserRepository) AddPoints(ctx context.Context, userId string, points int) (*models.User, error) {
+	return r.updateOne(ctx, userId, bson.M{"$inc": bson.M{"points": points}})
+}
+
+func (r *UserRepository) SetTimetable(ctx context.Context, userId string, timetable string) (*models.User, error) {
+	return r.updateOne(ctx, userId, bson.M{"$set": bson.M{"timetable": timetable}})
+}
+
+func (r *UserRepository) SetRole(ctx context.Context, userId string, role string) (*models.User, error) {
+	return r.updateOne(ctx, userId, bson.M{"$set": bson.M{"user_type": role}})
+}
+
+func (r *UserRepository) HasRole(ctx context.Context, role string) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"user_type": role}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+func (r *UserRepository) SetPassword(ctx context.Context, userId string, passwordHash string) error {
+	_, err := r.updateOne(ctx, userId, bson.M{"$set": bson.M{"password": passwordHash}})
+	return err
+}
+
+func (r *UserRepository) SetEmailVerified(ctx context.Context, userId string, verified bool) error {
+	_, err := r.updateOne(ctx, userId, bson.M{"$set": bson.M{"email_verified": verified}})
+	return err
+}
+
+func (r *UserRepository) RecordLogin(ctx context.Context, userId string, at time.Time) error {
+	update := bson.M{"$set": bson.M{"updated_at": at}, "$unset": bson.M{"token": "", "refresh_token": ""}}
+	_, err := r.updateOne(ctx, userId, update)
+	return err
+}
+
+func (r *UserRepository) LinkIdentity(ctx context.Context, userId string, identity models.Identity, dropPassword bool) error {
+	update := bson.M{
+		"$push": bson.M{"identities": identity},
+		"$set":  bson.M{"email_verified": true},
+	}
+	if dropPassword {
+		update["$set"] = bson.M{"email_verified": true, "two_factor_enabled": false}
+		update["$unset"] = bson.M{
+			"password":               "",
+			"totp_secret":            "",
+			"totp_last_step":         "",
+			"recovery_codes":         "",
+			"totp_pending_secret":    "",
+			"pending_recovery_codes": "",
+		}
+	}
+
+	_, err := r.updateOne(ctx, userId, update)
+	return err
+}
+
+func (r *UserRepository) SetPendingTwoFactor(ctx context.Context, userId string, secret string, recoveryCodeHashes []string) error {
+	update := bson.M{"$set": bson.M{"totp_pending_secret": secret, "pending_recovery_codes": recoveryCodeHashes}}
+	_, err := r.updateOne(ctx, userId, update)
+	return err
+}
+
+func (r *UserRepository) EnableTwoFactor(ctx context.Context, userId string, pendingSecret string, recoveryCodeHashes []string, step int64) error {
+	filter := bson.M{"user_id": userId, "totp_pending_secret": pendingSecret}
+	update := bson.M{
+		"$set": bson.M{
+			"two_factor_enabled": true,
+			"totp_secret":        pendingSecret,
+			"totp_last_step":     step,
+			"recovery_codes":     recoveryCodeHashes,
+			"updated_at":         updatedNow(r.clock),
+		},
+		"$unset": bson.M{"totp_pending_secret": "", "pending_recovery_codes": ""},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepository) DisableTwoFactor(ctx context.Context, userId string) error {
+	update := bson.M{
+		"$set":   bson.M{"two_factor_enabled": false},
+		"$unset": bson.M{"totp_secret": "", "totp_last_step": "", "recovery_codes": ""},
+	}
+
+	_, err := r.updateOne(ctx, userId, update)
+	return err
+}
+
+func (r *UserRepository) UseTOTPStep(ctx context.Context, userId string, step int64) (bool, error) {
+	filter := bson.M{
+		"user_id": userId,
+		"$or":     bson.A{bson.M{"totp_last_step": bson.M{"$lt": step}}, bson.M{"totp_last_step": nil}},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, userId string, codeHash string) (bool, error) {
+	filter := bson.M{"user_id": userId, "recovery_codes": codeHash}
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"recovery_codes": codeHash}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
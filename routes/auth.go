@@ -2,12 +2,33 @@ package routes
 
 import (
 	controller "github.com/hauchongtang/splatbackend/controllers"
+	"github.com/hauchongtang/splatbackend/middleware"
+	"github.com/hauchongtang/splatbackend/models"
 
 	"github.com/gin-gonic/gin"
 )
 
 //UserRoutes function
-func AuthRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/users/signup", controller.SignUp())
-	incomingRoutes.POST("/users/login", controller.Login())
+func AuthRoutes(incomingRoutes *gin.Engine, handlers *controller.Handlers, auth *middleware.Auth) {
+	incomingRoutes.POST("/users/signup", handlers.SignUp())
+	incomingRoutes.POST("/users/login", handlers.Login())
+	incomingRoutes.POST("/users/login/2fa", handlers.LoginTwoFactor())
+	incomingRoutes.POST("/users/refresh", handlers.RefreshToken())
+	incomingRoutes.GET("/users/oidc/:provider/login", handlers.OIDCLogin())
+	incomingRoutes.GET("/users/oidc/:provider/callback", handlers.OIDCCallback())
+	incomingRoutes.POST("/users/password/forgot", handlers.ForgotPassword())
+	incomingRoutes.POST("/users/password/reset", handlers.ResetPassword())
+	incomingRoutes.GET("/users/verify", handlers.VerifyEmail())
+	incomingRoutes.POST("/users/verify/resend", auth.Authentication(), handlers.ResendVerificationEmail())
+	incomingRoutes.POST("/users/unlock", auth.Authentication(), middleware.RequireRole(models.RoleAdmin), handlers.UnlockAccount())
+	incomingRoutes.POST("/users/2fa/enroll", auth.Authentication(), handlers.EnrollTwoFactor())
+	incomingRoutes.POST("/users/2fa/confirm", auth.Authentication(), handlers.ConfirmTwoFactor())
+	incomingRoutes.POST("/users/2fa/disable", auth.Authentication(), handlers.DisableTwoFactor())
+	incomingRoutes.POST("/users/apikeys", auth.Authentication(), handlers.CreateApiKey())
+	incomingRoutes.GET("/users/apikeys", auth.Authentication(), handlers.GetApiKeys())
+	incomingRoutes.DELETE("/users/apikeys/:keyId", auth.Authentication(), handlers.RevokeApiKey())
+	incomingRoutes.GET("/users/me/sessions", auth.Authentication(), handlers.GetSessions())
+	incomingRoutes.DELETE("/users/me/sessions/:id", auth.Authentication(), handlers.RevokeSession())
+	incomingRoutes.POST("/users/logout", auth.Authentication(), handlers.Logout())
+	incomingRoutes.POST("/users/logout/all", auth.Authentication(), handlers.LogoutEverywhere())
 }
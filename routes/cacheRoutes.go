@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/controllers"
+	"github.com/hauchongtang/splatbackend/middleware"
+	"github.com/hauchongtang/splatbackend/models"
+)
+
+// get routes for cache metrics and administration
+func CacheRoutes(incomingRoutes *gin.Engine, handlers *controllers.Handlers, auth *middleware.Auth) {
+	admin := middleware.RequireRole(models.RoleAdmin)
+	// Scrapers authenticate with an API key of an admin holding stats:read
+	incomingRoutes.GET("/metrics", auth.Authentication(models.ScopeStatsRead), admin, handlers.GetCacheMetrics())
+	incomingRoutes.GET("/admin/cache/keys/:key", auth.Authentication(), admin, handlers.InspectCacheKey())
+	incomingRoutes.DELETE("/admin/cache/families/:family", auth.Authentication(), admin, handlers.PurgeCacheFamily())
+	incomingRoutes.POST("/admin/cache/families/:family/warm", auth.Authentication(), admin, handlers.WarmCacheFamily())
+	incomingRoutes.POST("/admin/cache/warm", auth.Authentication(), admin, handlers.WarmCaches())
+}
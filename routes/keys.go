@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hauchongtang/splatbackend/controllers"
+)
+
+// get routes for token verification keys
+func KeyRoutes(incomingRoutes *gin.Engine, handlers *controllers.Handlers) {
+	incomingRoutes.GET("/.well-known/jwks.json", handlers.GetJWKS())
+}
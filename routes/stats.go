@@ -4,9 +4,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hauchongtang/splatbackend/controllers"
 	"github.com/hauchongtang/splatbackend/middleware"
+	"github.com/hauchongtang/splatbackend/models"
 )
 
 // get routes for user signup and login
-func StatsRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.GET("/stats/mostpopular", middleware.Authentication(), controllers.GetMostPopularModule())
+func StatsRoutes(incomingRoutes *gin.Engine, handlers *controllers.Handlers, auth *middleware.Auth) {
+	incomingRoutes.GET("/stats/mostpopular", auth.Authentication(models.ScopeStatsRead), handlers.GetMostPopularModule())
 }
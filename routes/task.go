@@ -4,14 +4,15 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hauchongtang/splatbackend/controllers"
 	"github.com/hauchongtang/splatbackend/middleware"
+	"github.com/hauchongtang/splatbackend/models"
 )
 
 // get routes for user signup and login
-func TaskRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.GET("/tasks", middleware.Authentication(), controllers.GetAllActivity())
-	incomingRoutes.GET("/cached/tasks", middleware.Authentication(), controllers.GetCachedAllActivity())
-	incomingRoutes.GET("/tasks/:id", middleware.Authentication(), controllers.GetTasksByUserId())
-	incomingRoutes.GET("cached/tasks/:id", middleware.Authentication(), controllers.GetCachedTasksByUserId())
-	incomingRoutes.PUT("/tasks/:id", middleware.Authentication(), controllers.UpdateHiddenStatus())
-	incomingRoutes.POST("/tasks", middleware.Authentication(), controllers.AddTask())
+func TaskRoutes(incomingRoutes *gin.Engine, handlers *controllers.Handlers, auth *middleware.Auth) {
+	incomingRoutes.GET("/tasks", auth.Authentication(models.ScopeTasksRead), handlers.GetAllActivity())
+	incomingRoutes.GET("/cached/tasks", auth.Authentication(models.ScopeTasksRead), handlers.GetCachedAllActivity())
+	incomingRoutes.GET("/tasks/:id", auth.Authentication(models.ScopeTasksRead), handlers.GetTasksByUserId())
+	incomingRoutes.GET("cached/tasks/:id", auth.Authentication(models.ScopeTasksRead), handlers.GetCachedTasksByUserId())
+	incomingRoutes.PUT("/tasks/:id", auth.Authentication(models.ScopeTasksWrite), middleware.RequireOwnership(auth.TaskParamOwner), handlers.UpdateHiddenStatus())
+	incomingRoutes.POST("/tasks", auth.Authentication(models.ScopeTasksWrite), handlers.AddTask())
 }
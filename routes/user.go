@@ -4,16 +4,19 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hauchongtang/splatbackend/controllers"
 	"github.com/hauchongtang/splatbackend/middleware"
+	"github.com/hauchongtang/splatbackend/models"
 )
 
 // get routes for user authentication
-func UserRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.GET("/users", middleware.Authentication(), controllers.GetUsers())
-	incomingRoutes.GET("/users/:id", middleware.Authentication(), controllers.GetUserById())
-	incomingRoutes.GET("/cached/users/:id", middleware.Authentication(), controllers.GetCachedUserById())
-	incomingRoutes.GET("/cached/users", middleware.Authentication(), controllers.GetCachedUsers())
-	incomingRoutes.PUT("/users/:id", middleware.Authentication(), controllers.IncreasePoints())
-	incomingRoutes.PUT("/users/update/:id", middleware.Authentication(), controllers.ModifyParticulars())
-	incomingRoutes.PUT("/users/modules/:id", middleware.Authentication(), controllers.UpdateModuleImportLink())
-	incomingRoutes.DELETE("/users/:id", middleware.Authentication(), controllers.DeleteUserById())
+func UserRoutes(incomingRoutes *gin.Engine, handlers *controllers.Handlers, auth *middleware.Auth) {
+	// Profiles have more fields for their owner and admins
+	incomingRoutes.GET("/users", auth.Authentication(models.ScopeUsersRead), handlers.GetUsers())
+	incomingRoutes.GET("/users/:id", auth.Authentication(models.ScopeUsersRead), handlers.GetUserById())
+	incomingRoutes.GET("/cached/users/:id", auth.Authentication(models.ScopeUsersRead), handlers.GetCachedUserById())
+	incomingRoutes.GET("/cached/users", auth.Authentication(models.ScopeUsersRead), handlers.GetCachedUsers())
+	incomingRoutes.PUT("/users/:id", auth.Authentication(models.ScopeUsersWrite), middleware.RequireOwnership(middleware.UserParamOwner), handlers.IncreasePoints())
+	incomingRoutes.PUT("/users/update/:id", auth.Authentication(), middleware.RequireOwnership(middleware.UserParamOwner), handlers.ModifyParticulars())
+	incomingRoutes.PUT("/users/modules/:id", auth.Authentication(models.ScopeUsersWrite), middleware.RequireOwnership(middleware.UserParamOwner), handlers.UpdateModuleImportLink())
+	incomingRoutes.PUT("/users/role/:id", auth.Authentication(), middleware.RequireRole(models.RoleAdmin), handlers.UpdateUserRole())
+	incomingRoutes.DELETE("/users/:id", auth.Authentication(), middleware.RequireRole(models.RoleAdmin), handlers.DeleteUserById())
 }